@@ -5,6 +5,7 @@ go 1.12
 require (
 	github.com/cf-guardian/guardian v0.0.0-20151111082959-80736895e96f
 	github.com/frankban/quicktest v1.4.2
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b // indirect
 	github.com/google/uuid v1.1.1
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
@@ -16,5 +17,6 @@ require (
 	github.com/stretchr/objx v0.2.0 // indirect
 	github.com/stretchr/testify v1.3.0
 	github.com/vektra/mockery v0.0.0-20181123154057-e78b021dcbb5 // indirect
+	golang.org/x/image v0.0.0-20200927104501-e162460cd6b5
 	gopkg.in/src-d/go-billy.v4 v4.3.2
 )
@@ -0,0 +1,29 @@
+package grouse_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/capnfabs/grouse/grouse"
+)
+
+func ExampleCompare() {
+	result, err := grouse.Compare(context.Background(), grouse.Options{
+		RepoDir: "path/to/site",
+		Refs:    []string{"main", "feature/photo-albums"},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer result.Close()
+
+	for _, change := range result.Revisions[0].Changes {
+		fmt.Println(change.Status, change.Path)
+	}
+	index, err := result.ReadFile(result.Revisions[0], "index.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("index.html is %d bytes\n", len(index))
+}
@@ -0,0 +1,261 @@
+// Package grouse builds a Hugo site at several git revisions and compares the
+// generated output. It's the library behind the grouse command-line tool, for
+// embedding in other Go programs; unlike the command-line tool, it never
+// prints anything, exits or starts a pager.
+package grouse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+	"github.com/capnfabs/grouse/internal/pkg"
+	"github.com/pkg/errors"
+)
+
+// Options says what to compare, and how to build it.
+type Options struct {
+	// Any directory inside the Hugo site. Hugo gets run from the same place,
+	// relative to the root of the repo, for every revision. Defaults to the
+	// current directory.
+	RepoDir string
+	// The revisions to build, as anything that git understands, e.g. branch
+	// names or commit hashes. The first one is the base that the others get
+	// compared to, unless AgainstDir is set.
+	Refs []string
+	// If set, the contents of this directory (e.g. a copy of the deployed
+	// site) are the base, and every revision in Refs gets compared to it.
+	AgainstDir string
+	// Extra arguments for hugo.
+	BuildArgs []string
+	// Shell commands to run before each build in the Hugo site's directory,
+	// and after each build in the output directory. They get the same
+	// GROUSE_* environment variables as the command-line tool's hooks.
+	PreBuildHook  string
+	PostBuildHook string
+	// Filters to run over the output before comparing it. The first filter
+	// that matches a file wins.
+	Filters []Filter
+	// How to get the source for each revision onto disk: "worktree", "clone",
+	// "export", or "auto" (the default) to pick one.
+	SourceMode string
+	// Only check out the directory containing the Hugo site, plus
+	// SparsePaths (relative to the root of the repo).
+	Sparse      bool
+	SparsePaths []string
+	// Progress messages and Hugo's output get written here. If it's nil,
+	// they get discarded.
+	Log io.Writer
+}
+
+// Filter turns output files which don't compare well (e.g. PDFs) into
+// something that does, like gitattributes' textconv. Command gets run with
+// the system shell, with the original file on stdin, and whatever it writes
+// to stdout replaces the file.
+type Filter struct {
+	// Matched against the file's path relative to the root of the output,
+	// or just its name if Glob doesn't contain a slash, e.g. "*.pdf".
+	Glob    string
+	Command string
+}
+
+// ChangeStatus is how a file changed between two output trees.
+type ChangeStatus string
+
+const (
+	Added       ChangeStatus = "added"
+	Deleted     ChangeStatus = "deleted"
+	Modified    ChangeStatus = "modified"
+	TypeChanged ChangeStatus = "type-changed"
+)
+
+// Change is a file that differs between the base and another output tree.
+type Change struct {
+	Status ChangeStatus
+	// Relative to the root of the output, with forward slashes.
+	Path string
+}
+
+// Tree is the output of building one revision (or the contents of
+// AgainstDir), committed to the repository at Result.OutputDir.
+type Tree struct {
+	// The ref from Options.Refs, or the path of Options.AgainstDir.
+	Name string
+	// The commit in the site's repo that got built, or "" for AgainstDir.
+	SourceCommit string
+	// The commit in Result.OutputDir holding the output, after Filters.
+	Commit string
+	// The commit in Result.OutputDir holding the output exactly as it was
+	// built. The same as Commit if there aren't any Filters.
+	RawCommit string
+	// The files that differ from the base tree. Nil for the base itself.
+	Changes []Change
+}
+
+// Result is the output of every revision. It lives in a temporary directory
+// until Close gets called.
+type Result struct {
+	// A git repository with a commit for each tree. It's fine to run any
+	// read-only git commands in it, e.g. `git diff <base> <other>`.
+	OutputDir string
+	Base      Tree
+	// In the same order as Options.Refs (minus the base).
+	Revisions []Tree
+
+	build      *pkg.Build
+	outputRepo git.Repository
+	cancel     context.CancelFunc
+}
+
+// Compare builds every revision in opts and works out which output files
+// changed compared to the base. Cancelling ctx stops any builds that are
+// running. The Result doesn't need ctx afterwards.
+func Compare(ctx context.Context, opts Options) (*Result, error) {
+	buildOpts, err := opts.buildOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	log := opts.Log
+	if log == nil {
+		log = ioutil.Discard
+	}
+	logger := out.NewLogger(log, false)
+	ctx = out.WithLogger(ctx, logger)
+	buildOpts.BuildOutput = log
+
+	build, err := pkg.BuildRevisions(ctx, git.NewGit(ctx), buildOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reading from the output afterwards has its own context, which lasts
+	// until Close.
+	readCtx, cancel := context.WithCancel(out.WithLogger(context.Background(), logger))
+	outputRepo, err := git.NewGit(readCtx).OpenRepository(build.OutputRepo.RootDir())
+	if err != nil {
+		cancel()
+		build.Close()
+		return nil, errors.WithMessage(err, "Couldn't open the output repository")
+	}
+	result := &Result{
+		OutputDir:  build.OutputRepo.RootDir(),
+		Base:       treeFrom(build.Base),
+		build:      build,
+		outputRepo: outputRepo,
+		cancel:     cancel,
+	}
+	for _, revision := range build.Revisions {
+		tree := treeFrom(revision)
+		changes, err := build.OutputRepo.ChangedFiles(build.Base.Output, revision.Output)
+		if err != nil {
+			result.Close()
+			return nil, errors.WithMessagef(err, "Couldn't compare %s to %s", revision.Name, build.Base.Name)
+		}
+		tree.Changes = []Change{}
+		for _, change := range changes {
+			tree.Changes = append(tree.Changes, Change{Status: statusFrom(change.Status), Path: change.Path})
+		}
+		result.Revisions = append(result.Revisions, tree)
+	}
+	return result, nil
+}
+
+// ReadFile returns the content of the file at filePath (relative to the root
+// of the output) in tree.
+func (r *Result) ReadFile(tree Tree, filePath string) ([]byte, error) {
+	return r.outputRepo.ReadFile(git.Hash(tree.Commit), filepath.ToSlash(filePath))
+}
+
+// ReadRawFile is like ReadFile, but returns the file as it was built, before
+// any Filters ran over it.
+func (r *Result) ReadRawFile(tree Tree, filePath string) ([]byte, error) {
+	return r.outputRepo.ReadFile(git.Hash(tree.RawCommit), filepath.ToSlash(filePath))
+}
+
+// Close removes all the temporary files behind the Result, including
+// OutputDir.
+func (r *Result) Close() error {
+	r.cancel()
+	return r.build.Close()
+}
+
+func (opts Options) buildOptions() (pkg.BuildOptions, error) {
+	minRefs := 2
+	if opts.AgainstDir != "" {
+		minRefs = 1
+	}
+	if len(opts.Refs) < minRefs {
+		return pkg.BuildOptions{}, fmt.Errorf("Need at least %d refs to compare, got %d", minRefs, len(opts.Refs))
+	}
+
+	repoDir := opts.RepoDir
+	if repoDir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return pkg.BuildOptions{}, err
+		}
+		repoDir = wd
+	}
+
+	againstDir := opts.AgainstDir
+	if againstDir != "" {
+		info, err := os.Stat(againstDir)
+		if err != nil {
+			return pkg.BuildOptions{}, err
+		}
+		if !info.IsDir() {
+			return pkg.BuildOptions{}, fmt.Errorf("AgainstDir needs to be a directory, but %s isn't one", againstDir)
+		}
+		if againstDir, err = filepath.Abs(againstDir); err != nil {
+			return pkg.BuildOptions{}, err
+		}
+	}
+
+	filters := []pkg.OutputFilter{}
+	for _, filter := range opts.Filters {
+		if filter.Glob == "" || filter.Command == "" {
+			return pkg.BuildOptions{}, fmt.Errorf("Filters need a Glob and a Command, got %+v", filter)
+		}
+		filters = append(filters, pkg.OutputFilter{Glob: filter.Glob, Command: filter.Command})
+	}
+
+	return pkg.BuildOptions{
+		RepoDir:       repoDir,
+		Commits:       opts.Refs,
+		BuildArgs:     opts.BuildArgs,
+		PreBuildHook:  opts.PreBuildHook,
+		PostBuildHook: opts.PostBuildHook,
+		SourceMode:    opts.SourceMode,
+		Sparse:        opts.Sparse,
+		SparsePaths:   opts.SparsePaths,
+		AgainstDir:    againstDir,
+		Filters:       filters,
+	}, nil
+}
+
+func treeFrom(revision pkg.BuiltRevision) Tree {
+	tree := Tree{Name: revision.Name, Commit: string(revision.Output), RawCommit: string(revision.Raw)}
+	if revision.Source != git.NilHash {
+		tree.SourceCommit = string(revision.Source)
+	}
+	return tree
+}
+
+func statusFrom(status git.ChangeStatus) ChangeStatus {
+	switch status {
+	case git.Added:
+		return Added
+	case git.Deleted:
+		return Deleted
+	case git.TypeChanged:
+		return TypeChanged
+	default:
+		return Modified
+	}
+}
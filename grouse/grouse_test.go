@@ -0,0 +1,82 @@
+package grouse
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestBuildOptionsNeedsEnoughRefs(t *testing.T) {
+	c := qt.New(t)
+	_, err := Options{Refs: []string{"main"}}.buildOptions()
+	c.Check(err, qt.ErrorMatches, `Need at least 2 refs to compare, got 1`)
+
+	// With AgainstDir, the directory is the base, so one ref is enough.
+	opts, err := Options{Refs: []string{"main"}, AgainstDir: "."}.buildOptions()
+	c.Assert(err, qt.IsNil)
+	c.Check(opts.Commits, qt.DeepEquals, []string{"main"})
+	c.Check(opts.RepoDir, qt.Not(qt.Equals), "")
+}
+
+func TestBuildOptionsAgainstDirMustBeADirectory(t *testing.T) {
+	c := qt.New(t)
+	_, err := Options{Refs: []string{"main"}, AgainstDir: "grouse.go"}.buildOptions()
+	c.Check(err, qt.ErrorMatches, `AgainstDir needs to be a directory, but grouse.go isn't one`)
+}
+
+func TestCompareReturnsErrors(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	_, err := Compare(context.Background(), Options{RepoDir: c.Mkdir(), Refs: []string{"a", "b"}})
+	c.Check(err, qt.ErrorMatches, `Couldn't load the git repo in .*`)
+}
+
+// fakeHugo only builds: it copies index.html to the destination.
+const fakeHugo = `#!/bin/sh
+for arg; do
+  case "$arg" in --destination=*) dest="${arg#--destination=}" ;; esac
+done
+[ -n "$dest" ] || exit 1
+mkdir -p "$dest" && cp index.html "$dest/"
+`
+
+func TestResultOutlivesContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs a POSIX shell")
+	}
+	c := qt.New(t)
+	defer c.Done()
+	binDir := c.Mkdir()
+	c.Assert(ioutil.WriteFile(filepath.Join(binDir, "hugo"), []byte(fakeHugo), 0755), qt.IsNil)
+	c.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	repoDir := c.Mkdir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=grouse", "-c", "user.email=grouse@example.com"}, args...)...)
+		cmd.Dir = repoDir
+		output, err := cmd.CombinedOutput()
+		c.Assert(err, qt.IsNil, qt.Commentf("%s", output))
+	}
+	git("init", "-q")
+	for _, content := range []string{"one", "two"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(repoDir, "index.html"), []byte(content), 0644), qt.IsNil)
+		git("add", ".")
+		git("commit", "-q", "-m", content)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result, err := Compare(ctx, Options{RepoDir: repoDir, Refs: []string{"HEAD^", "HEAD"}})
+	cancel()
+	c.Assert(err, qt.IsNil)
+	defer result.Close()
+	c.Check(result.Revisions[0].Changes, qt.DeepEquals, []Change{{Status: Modified, Path: "index.html"}})
+	content, err := result.ReadFile(result.Revisions[0], "index.html")
+	c.Assert(err, qt.IsNil)
+	c.Check(string(content), qt.Equals, "two")
+}
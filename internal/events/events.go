@@ -0,0 +1,111 @@
+// Package events emits machine-readable progress events, for tools that wrap
+// grouse (e.g. editor integrations) and want to show what it's doing. Unlike
+// the messages in package out, the format of these is stable.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Type is the kind of event. New types may be added, so consumers should
+// ignore types they don't recognize.
+type Type string
+
+const (
+	RefResolved           Type = "ref_resolved"
+	SourcePrepareStarted  Type = "source_prepare_started"
+	SourcePrepareFinished Type = "source_prepare_finished"
+	CheckoutStarted       Type = "checkout_started"
+	CheckoutFinished      Type = "checkout_finished"
+	BuildStarted          Type = "build_started"
+	BuildFinished         Type = "build_finished"
+	HookStarted           Type = "hook_started"
+	HookFinished          Type = "hook_finished"
+	ImportStarted         Type = "import_started"
+	ImportFinished        Type = "import_finished"
+	// The output of a revision (built or imported) has been committed to
+	// the output repo.
+	OutputCommitted Type = "output_committed"
+	DiffStarted     Type = "diff_started"
+	DiffFinished    Type = "diff_finished"
+	// The last event of every run.
+	Finished Type = "finished"
+)
+
+// Event is a single line of the event stream. Fields which don't apply to an
+// event's Type are left out.
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	// The ref or directory that the user passed, if the event is about a
+	// single revision.
+	Ref string `json:"ref,omitempty"`
+	// The commit in the user's repo.
+	Commit string `json:"commit,omitempty"`
+	// The commit in the output repo.
+	OutputCommit string `json:"output_commit,omitempty"`
+	// How the source gets onto disk, for source_prepare_*.
+	SourceMode string `json:"source_mode,omitempty"`
+	// Which hook is running, for hook_*.
+	Hook string `json:"hook,omitempty"`
+	// For *_finished events.
+	DurationMS *int64 `json:"duration_ms,omitempty"`
+	// For build_finished, if hugo ran to completion or exited with an error.
+	ExitStatus *int `json:"exit_status,omitempty"`
+	// For *_finished events, if something went wrong.
+	Error string `json:"error,omitempty"`
+}
+
+// Sink writes events to a stream, one JSON object per line. A nil Sink
+// discards them.
+type Sink struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewSink returns a Sink that writes to w.
+func NewSink(w io.Writer) *Sink {
+	return &Sink{encoder: json.NewEncoder(w)}
+}
+
+// Emit writes e to the stream. Errors writing events are ignored, because they
+// shouldn't stop grouse from working.
+func (s *Sink) Emit(e Event) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	s.encoder.Encode(e)
+}
+
+type sinkKey struct{}
+
+// WithSink returns a copy of ctx that Emit sends events to s from.
+func WithSink(ctx context.Context, s *Sink) context.Context {
+	return context.WithValue(ctx, sinkKey{}, s)
+}
+
+// Emit writes e to the Sink in ctx, if there is one. Events are off unless
+// something calls WithSink.
+func Emit(ctx context.Context, e Event) {
+	s, _ := ctx.Value(sinkKey{}).(*Sink)
+	s.Emit(e)
+}
+
+// Finish fills in the duration since start and the error (if any) on e.
+func (e Event) Finish(start time.Time, err error) Event {
+	duration := time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	e.DurationMS = &duration
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
@@ -0,0 +1,43 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestEmitWritesOneJSONObjectPerLine(t *testing.T) {
+	c := qt.New(t)
+	var buf bytes.Buffer
+	ctx := WithSink(context.Background(), NewSink(&buf))
+
+	Emit(ctx, Event{Type: BuildStarted, Ref: "main"})
+	status := 1
+	finished := Event{Type: BuildFinished, Ref: "main", ExitStatus: &status}.Finish(time.Now(), errors.New("exit status 1"))
+	Emit(ctx, finished)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	c.Assert(lines, qt.HasLen, 2)
+	var decoded map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(lines[1]), &decoded), qt.IsNil)
+	c.Check(decoded["type"], qt.Equals, "build_finished")
+	c.Check(decoded["ref"], qt.Equals, "main")
+	c.Check(decoded["exit_status"], qt.Equals, float64(1))
+	c.Check(decoded["error"], qt.Equals, "exit status 1")
+	_, hasDuration := decoded["duration_ms"]
+	c.Check(hasDuration, qt.Equals, true)
+	// Fields which don't apply get left out.
+	_, hasSourceMode := decoded["source_mode"]
+	c.Check(hasSourceMode, qt.Equals, false)
+}
+
+func TestEmitWithoutSinkDoesNothing(t *testing.T) {
+	// Mostly checking that this doesn't panic.
+	Emit(context.Background(), Event{Type: Finished})
+}
@@ -2,6 +2,8 @@ package exec
 
 import (
 	"bytes"
+	"context"
+	"os"
 	"os/exec"
 	"strings"
 
@@ -15,7 +17,7 @@ type CmdResult struct {
 	Err    error
 }
 
-type Executor func(workDir string, args ...string) CmdResult
+type Executor func(ctx context.Context, workDir string, args ...string) CmdResult
 type CommandRunner func(cmd *Cmd) error
 
 type Cmd struct {
@@ -28,26 +30,38 @@ func (c *Cmd) Run(DONT_CALL_THIS string) {
 	panic("Don't call this; use Run(cmd) instead")
 }
 
-func Command(name string, arg ...string) *Cmd {
-	return &Cmd{exec.Command(name, arg...)}
+// Command is like exec.CommandContext; the process is killed if ctx is
+// cancelled before it finishes.
+func Command(ctx context.Context, name string, arg ...string) *Cmd {
+	return &Cmd{exec.CommandContext(ctx, name, arg...)}
 }
 
 var Run CommandRunner = func(cmd *Cmd) error {
 	return cmd.Cmd.Run()
 }
 
-var Exec Executor = func(workDir string, args ...string) CmdResult {
-	out.Debugln("Running Command: ", shellquote.Join(args...))
-	cmd := exec.Command(args[0], args[1:]...)
+var Exec Executor = func(ctx context.Context, workDir string, args ...string) CmdResult {
+	return ExecWithEnv(ctx, workDir, nil, args...)
+}
+
+// ExecWithEnv is like Exec, but the command also gets the environment
+// variables in env, in "key=value" form, on top of grouse's own.
+var ExecWithEnv = func(ctx context.Context, workDir string, env []string, args ...string) CmdResult {
+	log := out.FromContext(ctx)
+	log.Debugln("Running Command: ", shellquote.Join(args...))
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 	var stdoutBuf, stderrBuf bytes.Buffer
 	cmd.Stderr = &stderrBuf
 	cmd.Stdout = &stdoutBuf
 	cmd.Dir = workDir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	err := cmd.Run()
 	stderr := strings.TrimSpace(stderrBuf.String())
 	stdout := strings.TrimSpace(stdoutBuf.String())
-	out.Debugln("StdErr: ", stderr)
-	out.Debugln("StdOut: ", stdout)
+	log.Debugln("StdErr: ", stderr)
+	log.Debugln("StdOut: ", stdout)
 	return CmdResult{
 		StdErr: stderr,
 		StdOut: stdout,
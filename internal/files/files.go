@@ -0,0 +1,145 @@
+// Package files writes files to disk: copies of other files, and the contents
+// of tar and zip archives.
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Write writes everything from r to target, creating any directories that it
+// needs. If target already exists, it keeps its mode; otherwise it gets mode,
+// or 0644 if mode is 0 (some archivers don't record permissions at all).
+func Write(r io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Copy copies the file at src to dst, in the same way as Write.
+func Copy(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return Write(in, dst, mode)
+}
+
+// entryPath converts a path from inside an archive to a path inside dst,
+// making sure that it can't point outside dst.
+func entryPath(dst string, name string) string {
+	return filepath.Join(dst, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+type symlink struct {
+	target string
+	link   string
+}
+
+// createSymlinks creates the symlinks found in an archive. This happens after
+// everything else has been extracted, so that files can't be written through
+// a symlink that points outside the destination directory.
+func createSymlinks(links []symlink) error {
+	for _, l := range links {
+		if err := os.MkdirAll(filepath.Dir(l.link), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Symlink(l.target, l.link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtractTar extracts the tar archive read from r into dst. Nothing in the
+// archive can end up outside dst.
+func ExtractTar(r io.Reader, dst string) error {
+	links := []symlink{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		target := entryPath(dst, header.Name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, os.ModePerm)
+		case tar.TypeSymlink:
+			links = append(links, symlink{target: header.Linkname, link: target})
+		case tar.TypeReg, tar.TypeRegA:
+			err = Write(tr, target, os.FileMode(header.Mode).Perm())
+		case tar.TypeLink:
+			err = os.Link(entryPath(dst, header.Linkname), target)
+		default:
+			// e.g. the global header that git archive uses to store the commit
+			// ID. Devices, FIFOs etc. don't belong in a website anyway.
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return createSymlinks(links)
+}
+
+// ExtractZip extracts the zip archive at archivePath into dst. Nothing in the
+// archive can end up outside dst.
+func ExtractZip(archivePath string, dst string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	links := []symlink{}
+	for _, file := range zr.File {
+		target := entryPath(dst, file.Name)
+		mode := file.Mode()
+		if mode.IsDir() {
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		if mode&os.ModeSymlink != 0 {
+			// Symlinks in zip files store their target as the file content.
+			linkTarget, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			links = append(links, symlink{target: string(linkTarget), link: target})
+			continue
+		}
+		err = Write(rc, target, mode.Perm())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return createSymlinks(links)
+}
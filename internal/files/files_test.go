@@ -0,0 +1,38 @@
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestExtractTarDoesntWriteThroughSymlinks(t *testing.T) {
+	c := qt.New(t)
+	dir, err := ioutil.TempDir("", "grouse-files-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+	outside := filepath.Join(dir, "outside")
+	dst := filepath.Join(dir, "dst")
+	c.Assert(os.Mkdir(outside, os.ModePerm), qt.IsNil)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "link", Linkname: outside, Typeflag: tar.TypeSymlink}), qt.IsNil)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "link/evil.html", Mode: 0644, Size: 4, Typeflag: tar.TypeReg}), qt.IsNil)
+	_, err = tw.Write([]byte("nope"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(tw.Close(), qt.IsNil)
+
+	// The symlink gets created after the file, so the file is in the way.
+	c.Check(ExtractTar(&buf, dst), qt.Not(qt.IsNil))
+	_, err = os.Stat(filepath.Join(outside, "evil.html"))
+	c.Check(os.IsNotExist(err), qt.Equals, true)
+	content, err := ioutil.ReadFile(filepath.Join(dst, "link", "evil.html"))
+	c.Assert(err, qt.IsNil)
+	c.Check(string(content), qt.Equals, "nope")
+}
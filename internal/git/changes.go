@@ -0,0 +1,331 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/exec"
+)
+
+// ChangeStatus describes how a file changed between two commits. The values
+// match the status letters that `git diff --name-status` prints.
+type ChangeStatus byte
+
+const (
+	// Added means the file only exists in the newer commit.
+	Added ChangeStatus = 'A'
+	// Deleted means the file only exists in the older commit.
+	Deleted ChangeStatus = 'D'
+	// Modified means the file's contents changed.
+	Modified ChangeStatus = 'M'
+	// TypeChanged means the file changed type, e.g. from a file to a symlink.
+	TypeChanged ChangeStatus = 'T'
+)
+
+func (s ChangeStatus) String() string {
+	return string(s)
+}
+
+// FileChange is a single entry in the list of files that differ between two
+// commits.
+type FileChange struct {
+	Status ChangeStatus
+	Path   string
+}
+
+func (r *repository) ChangedFiles(from, to Hash) ([]FileChange, error) {
+	// -z so that paths with unusual characters don't get quoted, and
+	// --no-renames so that every change maps to exactly one path.
+	cmd := r.runCommand("git", "diff", "--name-status", "-z", "--no-renames", string(from), string(to))
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	return parseNameStatus(cmd.StdOut)
+}
+
+func (r *repository) ChangedFilesInWorkingTree(from Hash) ([]FileChange, error) {
+	cmd := r.runCommand("git", "diff", "--name-status", "-z", "--no-renames", string(from))
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	changes, err := parseNameStatus(cmd.StdOut)
+	if err != nil {
+		return nil, err
+	}
+	// git diff only knows about files that git already tracks.
+	cmd = r.runCommand("git", "ls-files", "-z", "--others", "--exclude-standard")
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	for _, file := range strings.Split(cmd.StdOut, "\x00") {
+		if file != "" {
+			changes = append(changes, FileChange{Status: Added, Path: file})
+		}
+	}
+	return changes, nil
+}
+
+func parseNameStatus(output string) ([]FileChange, error) {
+	changes := []FileChange{}
+	if output == "" {
+		return changes, nil
+	}
+	fields := strings.Split(strings.TrimSuffix(output, "\x00"), "\x00")
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("Unexpected output from git diff --name-status: %q", output)
+	}
+	for i := 0; i < len(fields); i += 2 {
+		if fields[i] == "" {
+			return nil, fmt.Errorf("Missing change status for %q", fields[i+1])
+		}
+		changes = append(changes, FileChange{
+			Status: ChangeStatus(fields[i][0]),
+			Path:   fields[i+1],
+		})
+	}
+	return changes, nil
+}
+
+func (r *repository) ListFiles(commit Hash, dir string) ([]string, error) {
+	cmd := r.runCommand("git", "ls-tree", "-r", "-z", "--name-only", string(commit), "--", dir)
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	files := []string{}
+	for _, file := range strings.Split(cmd.StdOut, "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func (r *repository) Submodules(commit Hash) (map[string]Hash, error) {
+	cmd := r.runCommand("git", "ls-tree", "-r", "-z", string(commit))
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	return parseSubmodules(cmd.StdOut)
+}
+
+// parseSubmodules picks the submodules out of the output of `git ls-tree -z`,
+// which has one `<mode> <type> <hash>\t<path>` entry per file.
+func parseSubmodules(output string) (map[string]Hash, error) {
+	submodules := map[string]Hash{}
+	for _, entry := range strings.Split(output, "\x00") {
+		if entry == "" {
+			continue
+		}
+		tab := strings.IndexByte(entry, '\t')
+		if tab < 0 {
+			return nil, fmt.Errorf("Unexpected output from git ls-tree: %q", entry)
+		}
+		fields := strings.Fields(entry[:tab])
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Unexpected output from git ls-tree: %q", entry)
+		}
+		if fields[1] == "commit" {
+			submodules[entry[tab+1:]] = Hash(fields[2])
+		}
+	}
+	return submodules, nil
+}
+
+func (r *repository) ReadFile(commit Hash, filePath string) ([]byte, error) {
+	// This doesn't go through exec.Exec, because that trims whitespace and
+	// converts to a string, which isn't what you want for binary files.
+	var buf bytes.Buffer
+	cmd := exec.Command(r.gitInterface.ctx, "git", "cat-file", "blob", string(commit)+":"+filePath)
+	cmd.Dir = r.rootDir
+	cmd.Stdout = &buf
+	if err := exec.Run(cmd); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *repository) ReadFiles(commit Hash, filePaths []string, read func(filePath string, content []byte) error) error {
+	return r.catFiles(commit, filePaths, read)
+}
+
+// catFileRequest returns the input for `git cat-file --batch` or
+// `--batch-check` which asks for each of filePaths in commit, and the paths
+// that it asks for, in order.
+func catFileRequest(commit Hash, filePaths []string) (string, []string) {
+	var request strings.Builder
+	requested := []string{}
+	for _, filePath := range filePaths {
+		// cat-file reads one object name per line.
+		if strings.ContainsAny(filePath, "\n\r") {
+			continue
+		}
+		fmt.Fprintf(&request, "%s:%s\n", commit, filePath)
+		requested = append(requested, filePath)
+	}
+	return request.String(), requested
+}
+
+// catFiles runs `git cat-file --batch` for each of filePaths in commit,
+// passing each file that exists to read.
+func (r *repository) catFiles(commit Hash, filePaths []string, read func(filePath string, content []byte) error) error {
+	request, requested := catFileRequest(commit, filePaths)
+	responses, output := io.Pipe()
+	cmd := exec.Command(r.gitInterface.ctx, "git", "cat-file", "--batch")
+	cmd.Dir = r.rootDir
+	cmd.Stdin = strings.NewReader(request)
+	cmd.Stdout = output
+	done := make(chan error, 1)
+	go func() {
+		err := exec.Run(cmd)
+		output.CloseWithError(err)
+		done <- err
+	}()
+
+	err := parseCatFileBatch(bufio.NewReader(responses), requested, read)
+	// Let git finish, even if there was no point reading any more.
+	responses.CloseWithError(err)
+	if runErr := <-done; err == nil {
+		err = runErr
+	}
+	return err
+}
+
+// parseCatFileBatch reads the output of `git cat-file --batch` for filePaths,
+// in order, passing each file that exists to read.
+func parseCatFileBatch(r *bufio.Reader, filePaths []string, read func(filePath string, content []byte) error) error {
+	for _, filePath := range filePaths {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(header)
+		if len(fields) == 2 && fields[1] == "missing" {
+			continue
+		}
+		if len(fields) != 3 {
+			return fmt.Errorf("Unexpected output from git cat-file: %q", header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("Unexpected output from git cat-file: %q", header)
+		}
+		// Every object is followed by a newline.
+		content := make([]byte, size+1)
+		if _, err := io.ReadFull(r, content); err != nil {
+			return err
+		}
+		if fields[1] != "blob" {
+			continue
+		}
+		if err := read(filePath, content[:size]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *repository) FileSizes(commit Hash, filePaths []string) (map[string]int, error) {
+	request, requested := catFileRequest(commit, filePaths)
+	var buf bytes.Buffer
+	cmd := exec.Command(r.gitInterface.ctx, "git", "cat-file", "--batch-check")
+	cmd.Dir = r.rootDir
+	cmd.Stdin = strings.NewReader(request)
+	cmd.Stdout = &buf
+	if err := exec.Run(cmd); err != nil {
+		return nil, err
+	}
+	return parseCatFileBatchCheck(buf.String(), requested)
+}
+
+// parseCatFileBatchCheck reads the output of `git cat-file --batch-check` for
+// filePaths, and returns the size of each one that's a file.
+func parseCatFileBatchCheck(output string, filePaths []string) (map[string]int, error) {
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if output == "" {
+		lines = nil
+	}
+	if len(lines) != len(filePaths) {
+		return nil, fmt.Errorf("Expected %d lines from git cat-file, but got %d", len(filePaths), len(lines))
+	}
+	sizes := map[string]int{}
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "missing" {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Unexpected output from git cat-file: %q", line)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("Unexpected output from git cat-file: %q", line)
+		}
+		if fields[1] == "blob" {
+			sizes[filePaths[i]] = size
+		}
+	}
+	return sizes, nil
+}
+
+// ErrBinaryFile means that git can't show the lines that changed in a file,
+// because it isn't text.
+var ErrBinaryFile = errors.New("Binary file")
+
+// LineChange is a run of lines that got replaced between two commits, like a
+// hunk in `git diff -U0`. Either side may be empty, for lines that were only
+// added or only removed.
+type LineChange struct {
+	Removed []string
+	Added   []string
+}
+
+func (r *repository) ChangedLines(from, to Hash, filePath string) ([]LineChange, error) {
+	// Like ReadFile, this doesn't go through exec.Exec, because trimming the
+	// output would change the last line.
+	var buf bytes.Buffer
+	cmd := exec.Command(r.gitInterface.ctx, "git", "diff", "-U0", "--no-color", "--no-ext-diff", "--no-textconv", "--no-renames", string(from), string(to), "--", filePath)
+	cmd.Dir = r.rootDir
+	cmd.Stdout = &buf
+	if err := exec.Run(cmd); err != nil {
+		return nil, err
+	}
+	return parseUnifiedDiff(buf.String())
+}
+
+func (r *repository) FileDiff(from, to Hash, filePath string) (string, error) {
+	var buf bytes.Buffer
+	cmd := exec.Command(r.gitInterface.ctx, "git", "diff", "--no-color", "--no-ext-diff", "--no-renames", string(from), string(to), "--", filePath)
+	cmd.Dir = r.rootDir
+	cmd.Stdout = &buf
+	if err := exec.Run(cmd); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// parseUnifiedDiff parses the output of `git diff -U0` for a single file.
+func parseUnifiedDiff(output string) ([]LineChange, error) {
+	changes := []LineChange{}
+	for _, line := range strings.Split(output, "\n") {
+		current := len(changes) - 1
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			changes = append(changes, LineChange{})
+		case current < 0:
+			// Still in the header.
+			if strings.HasPrefix(line, "Binary files ") {
+				return nil, ErrBinaryFile
+			}
+		case strings.HasPrefix(line, "-"):
+			changes[current].Removed = append(changes[current].Removed, line[1:])
+		case strings.HasPrefix(line, "+"):
+			changes[current].Added = append(changes[current].Added, line[1:])
+		}
+	}
+	return changes, nil
+}
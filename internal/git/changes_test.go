@@ -0,0 +1,104 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestParseUnifiedDiff(t *testing.T) {
+	c := qt.New(t)
+	output := `diff --git a/index.html b/index.html
+index 3b18e51..a96ba3c 100644
+--- a/index.html
++++ b/index.html
+@@ -3 +3 @@
+-<p>Built at 10:01</p>
++<p>Built at 10:02</p>
+@@ -10,2 +10,0 @@
+-<li>one</li>
+-<li>two</li>
+@@ -20,0 +19 @@
++--not a header--
+\ No newline at end of file
+`
+	changes, err := parseUnifiedDiff(output)
+	c.Assert(err, qt.IsNil)
+	c.Assert(changes, qt.HasLen, 3)
+	c.Check(changes[0], qt.DeepEquals, LineChange{Removed: []string{"<p>Built at 10:01</p>"}, Added: []string{"<p>Built at 10:02</p>"}})
+	c.Check(changes[1], qt.DeepEquals, LineChange{Removed: []string{"<li>one</li>", "<li>two</li>"}})
+	c.Check(changes[2], qt.DeepEquals, LineChange{Added: []string{"--not a header--"}})
+}
+
+func TestParseUnifiedDiffBinary(t *testing.T) {
+	c := qt.New(t)
+	output := `diff --git a/logo.png b/logo.png
+index 3b18e51..a96ba3c 100644
+Binary files a/logo.png and b/logo.png differ
+`
+	_, err := parseUnifiedDiff(output)
+	c.Check(err, qt.Equals, ErrBinaryFile)
+}
+
+func TestParseSubmodules(t *testing.T) {
+	c := qt.New(t)
+	output := "100644 blob 3b18e512dba79e4c8300dd08aeb37f8e728b8dad\tconfig.toml\x00" +
+		"160000 commit a96ba3c0bb1e1a3d29d6c8a9a5b5a8e1c1e1c1e1\tthemes/my theme\x00" +
+		"100644 blob 1b18e512dba79e4c8300dd08aeb37f8e728b8dad\tcontent/a.md\x00"
+	submodules, err := parseSubmodules(output)
+	c.Assert(err, qt.IsNil)
+	c.Check(submodules, qt.DeepEquals, map[string]Hash{"themes/my theme": "a96ba3c0bb1e1a3d29d6c8a9a5b5a8e1c1e1c1e1"})
+
+	_, err = parseSubmodules("garbage\x00")
+	c.Check(err, qt.Not(qt.IsNil))
+}
+
+func TestParseCatFileBatchCheck(t *testing.T) {
+	c := qt.New(t)
+	output := "3b18e512dba79e4c8300dd08aeb37f8e728b8dad blob 1234\n" +
+		"abc:gone.html missing\n" +
+		"a96ba3c0bb1e1a3d29d6c8a9a5b5a8e1c1e1c1e1 tree 66\n"
+	sizes, err := parseCatFileBatchCheck(output, []string{"index.html", "gone.html", "posts"})
+	c.Assert(err, qt.IsNil)
+	c.Check(sizes, qt.DeepEquals, map[string]int{"index.html": 1234})
+
+	sizes, err = parseCatFileBatchCheck("", nil)
+	c.Assert(err, qt.IsNil)
+	c.Check(sizes, qt.HasLen, 0)
+
+	_, err = parseCatFileBatchCheck(output, []string{"index.html"})
+	c.Check(err, qt.Not(qt.IsNil))
+}
+
+func TestChangedFilesInWorkingTree(t *testing.T) {
+	c := qt.New(t)
+	dir := tempDir(c)
+	defer os.RemoveAll(dir)
+	repo, err := NewGit(context.Background()).(git).newExecRepository(dir)
+	c.Assert(err, qt.IsNil)
+
+	write := func(name, content string) {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), qt.IsNil)
+	}
+	write(".gitignore", "ignored.txt\n")
+	write("a.txt", "a")
+	write("b.txt", "b")
+	commit, err := repo.CommitEverythingInWorktree("First")
+	c.Assert(err, qt.IsNil)
+
+	write("a.txt", "changed")
+	c.Assert(os.Remove(filepath.Join(dir, "b.txt")), qt.IsNil)
+	write("c.txt", "new")
+	write("ignored.txt", "ignored")
+	changes, err := repo.ChangedFilesInWorkingTree(commit)
+	c.Assert(err, qt.IsNil)
+	c.Check(changes, qt.DeepEquals, []FileChange{
+		{Status: Modified, Path: "a.txt"},
+		{Status: Deleted, Path: "b.txt"},
+		{Status: Added, Path: "c.txt"},
+	})
+}
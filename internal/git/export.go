@@ -0,0 +1,189 @@
+package git
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/files"
+)
+
+// exportedTree is a plain directory containing the files from a commit, with
+// no git metadata at all. It's the cheapest way to get the source for a build,
+// but it doesn't work for builds that need to look at git history (e.g. Hugo's
+// enableGitInfo).
+type exportedTree struct {
+	repository
+	// The repository that commits get exported from.
+	src *repository
+	// If set, only these paths get exported.
+	sparsePaths []string
+}
+
+func (r *repository) ExportTo(dst string, sparsePaths []string) (WorktreeRepository, error) {
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &exportedTree{
+		repository:  repository{rootDir: dst, gitInterface: r.gitInterface},
+		src:         r,
+		sparsePaths: cleanSparsePaths(sparsePaths),
+	}, nil
+}
+
+func (e *exportedTree) Checkout(commit ResolvedCommit) error {
+	if err := removeContents(e.rootDir, ""); err != nil {
+		return err
+	}
+	if err := exportCommit(e.src, commit.Hash(), e.rootDir, e.sparsePaths); err != nil {
+		return err
+	}
+	return hydrateLFSObjects(e.src, e.rootDir)
+}
+
+func (e *exportedTree) Remove() error {
+	return os.RemoveAll(e.rootDir)
+}
+
+// exportCommit writes the tree for commit into dst, and then recursively does
+// the same for every submodule in the tree. If paths isn't empty, only those
+// paths get exported.
+func exportCommit(src *repository, commit Hash, dst string, paths []string) error {
+	if len(paths) > 0 {
+		// git archive fails if any of the paths don't exist.
+		var err error
+		paths, err = src.existingPaths(commit, paths)
+		if err != nil || len(paths) == 0 {
+			return err
+		}
+	}
+	if err := exportTreeTo(src, commit, dst, paths); err != nil {
+		return err
+	}
+
+	submodules, err := src.submoduleCommits(commit)
+	if err != nil {
+		return err
+	}
+	for _, submod := range submodules {
+		if !isWithinPaths(submod.path, paths) {
+			continue
+		}
+		submodRepo, err := src.gitInterface.openRepository(path.Join(src.rootDir, submod.path))
+		if err != nil || submodRepo.rootDir == src.rootDir {
+			return fmt.Errorf("The submodule at %s isn't initialized, so it can't be exported. Try `git submodule update --init`", submod.path)
+		}
+		src.log().Debugf("Exporting submodule at %s (%s)\n", submod.path, submod.commit)
+		if err := exportCommit(submodRepo, submod.commit, path.Join(dst, submod.path), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// existingPaths returns the ones out of paths that exist in commit. It's
+// reasonable for sparse paths not to exist in older commits, but lots of git
+// commands fail if they're given a path that doesn't exist.
+func (r *repository) existingPaths(commit Hash, paths []string) ([]string, error) {
+	cmd := r.runCommand(append([]string{"git", "ls-tree", "--name-only", string(commit), "--"}, paths...)...)
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	if cmd.StdOut == "" {
+		r.log().Debugf("None of the sparse paths exist in %s\n", commit)
+		return nil, nil
+	}
+	return strings.Split(cmd.StdOut, "\n"), nil
+}
+
+type submoduleCommit struct {
+	path   string
+	commit Hash
+}
+
+// submoduleCommits lists the submodules recorded in the tree for commit, along
+// with the commit that each one points at.
+func (r *repository) submoduleCommits(commit Hash) ([]submoduleCommit, error) {
+	cmd := r.runCommand("git", "ls-tree", "-r", "-z", string(commit))
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	submodules := []submoduleCommit{}
+	for _, entry := range strings.Split(cmd.StdOut, "\x00") {
+		// Each entry looks like "<mode> <type> <hash>\t<path>".
+		tab := strings.IndexByte(entry, '\t')
+		if tab < 0 {
+			continue
+		}
+		fields := strings.Fields(entry[:tab])
+		if len(fields) == 3 && fields[1] == "commit" {
+			submodules = append(submodules, submoduleCommit{path: entry[tab+1:], commit: Hash(fields[2])})
+		}
+	}
+	return submodules, nil
+}
+
+// exportTreeTo writes the files in commit into dst, like checking it out
+// would. If paths isn't empty, only those paths get exported. This doesn't use
+// `git archive`, because that applies the export-ignore and export-subst
+// attributes, so the source would differ from a checkout.
+func exportTreeTo(src *repository, commit Hash, dst string, paths []string) error {
+	cmd := src.runCommand(append([]string{"git", "ls-tree", "-r", "-z", string(commit), "--"}, paths...)...)
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	modes := map[string]string{}
+	filePaths := []string{}
+	for _, entry := range strings.Split(cmd.StdOut, "\x00") {
+		// Each entry looks like "<mode> <type> <hash>\t<path>".
+		tab := strings.IndexByte(entry, '\t')
+		if tab < 0 {
+			continue
+		}
+		fields := strings.Fields(entry[:tab])
+		if len(fields) != 3 || fields[1] != "blob" {
+			// Submodules get exported separately.
+			continue
+		}
+		filePath := entry[tab+1:]
+		modes[filePath] = fields[0]
+		filePaths = append(filePaths, filePath)
+	}
+
+	// The files go through a tar archive, so that they get extracted just
+	// like archives do, e.g. with symlinks last.
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		write := func(filePath string, content []byte) error {
+			header := &tar.Header{Name: filePath, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+			switch modes[filePath] {
+			case modeExecutable:
+				header.Mode = 0755
+			case modeSymlink:
+				return tw.WriteHeader(&tar.Header{Name: filePath, Linkname: string(content), Typeflag: tar.TypeSymlink})
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err := tw.Write(content)
+			return err
+		}
+		// The files are exactly as committed, so LFS files are still pointers
+		// (see hydrateLFSObjects), and line endings don't get converted.
+		err := src.catFiles(commit, filePaths, write)
+		if err == nil {
+			err = tw.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	err := files.ExtractTar(reader, dst)
+	// Drain anything left so that the goroutine doesn't block writing to the
+	// pipe.
+	io.Copy(ioutil.Discard, reader)
+	return err
+}
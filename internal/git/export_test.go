@@ -0,0 +1,54 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestExportIgnoresExportAttributes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs symlinks and POSIX file permissions")
+	}
+	c := qt.New(t)
+	dir := tempDir(c)
+	defer os.RemoveAll(dir)
+	exportDir := tempDir(c)
+	defer os.RemoveAll(exportDir)
+	src, err := NewGit(context.Background()).(git).newExecRepository(dir)
+	c.Assert(err, qt.IsNil)
+	write := func(name, content string, mode os.FileMode) {
+		p := filepath.Join(src.RootDir(), filepath.FromSlash(name))
+		c.Assert(os.MkdirAll(filepath.Dir(p), os.ModePerm), qt.IsNil)
+		c.Assert(ioutil.WriteFile(p, []byte(content), mode), qt.IsNil)
+	}
+	write(".gitattributes", "drafts/** export-ignore\nversion.txt export-subst\n", 0644)
+	write("drafts/post.md", "draft", 0644)
+	write("version.txt", "$Format:%H$", 0644)
+	write("scripts/build.sh", "#!/bin/sh\n", 0755)
+	c.Assert(os.Symlink("version.txt", filepath.Join(src.RootDir(), "link.txt")), qt.IsNil)
+	commit, err := src.CommitEverythingInWorktree("First")
+	c.Assert(err, qt.IsNil)
+	ref, err := src.ResolveCommit(string(commit))
+	c.Assert(err, qt.IsNil)
+
+	exported, err := src.(*execWriteableRepo).ExportTo(filepath.Join(exportDir, "export"), nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(exported.Checkout(ref.Commit()), qt.IsNil)
+	read := func(name string) string {
+		content, err := ioutil.ReadFile(filepath.Join(exported.RootDir(), filepath.FromSlash(name)))
+		c.Assert(err, qt.IsNil)
+		return string(content)
+	}
+	c.Check(read("drafts/post.md"), qt.Equals, "draft")
+	c.Check(read("version.txt"), qt.Equals, "$Format:%H$")
+	c.Check(read("link.txt"), qt.Equals, "$Format:%H$")
+	info, err := os.Stat(filepath.Join(exported.RootDir(), "scripts", "build.sh"))
+	c.Assert(err, qt.IsNil)
+	c.Check(info.Mode().Perm()&0100, qt.Equals, os.FileMode(0100))
+}
@@ -1,7 +1,9 @@
 package git
 
 import (
+	"context"
 	"errors"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -14,6 +16,9 @@ type Git interface {
 	NewRepository(dst string) (WriteableRepository, error)
 	OpenRepository(repoDir string) (Repository, error)
 	GetRelativeLocation(currentDir string) (string, error)
+	// SupportsDetachedWorktrees returns true if the installed version of git
+	// is new enough to use Repository.AddDetachedWorktree.
+	SupportsDetachedWorktrees() bool
 }
 
 type gitVersion struct {
@@ -57,20 +62,24 @@ func parseVersionString(vs string) gitVersion {
 
 var versionRegexp = regexp.MustCompile(`^git version (\d+\.\d+\.\d+)`)
 
-// NewGit returns a new git interface.
-func NewGit() Git {
-	cmd := exec.Exec("", "git", "version")
+// NewGit returns a new git interface. Every git command run through it, or
+// through any repository that it opens, is killed when ctx is cancelled, and
+// messages about them go to the Logger in ctx.
+func NewGit(ctx context.Context) Git {
+	cmd := exec.Exec(ctx, "", "git", "version")
 	submatches := versionRegexp.FindStringSubmatch(cmd.StdOut)
 	var version gitVersion = noVersion
 	if submatches != nil {
 		version = parseVersionString(submatches[1])
 	}
 	return git{
+		ctx:     ctx,
 		version: version,
 	}
 }
 
 type git struct {
+	ctx     context.Context
 	version gitVersion
 }
 
@@ -80,17 +89,35 @@ var (
 	ErrRepoExists = errors.New("Repo already exists")
 )
 
-// NewRepository creates a new git repository in the given directory.
+// NewRepository creates a new git repository in the given directory. The
+// repository is written in-process rather than by running git, which is
+// considerably faster for sites with lots of files.
 func (g git) NewRepository(dst string) (WriteableRepository, error) {
 	_, err := g.OpenRepository(dst)
 	if err == nil {
 		return nil, ErrRepoExists
 	}
-	cmd := exec.Exec(dst, "git", "init")
+	if err := initRepository(dst); err != nil {
+		return nil, err
+	}
+	return &writeableRepo{
+		repository: repository{rootDir: dst, gitInterface: &g},
+		objects:    newObjectStore(path.Join(dst, gitDirName)),
+	}, nil
+}
+
+// newExecRepository is like NewRepository, but creates a repository that
+// shells out to git for every operation.
+func (g git) newExecRepository(dst string) (WriteableRepository, error) {
+	_, err := g.OpenRepository(dst)
+	if err == nil {
+		return nil, ErrRepoExists
+	}
+	cmd := exec.Exec(g.ctx, dst, "git", "init")
 	if cmd.Err != nil {
 		return nil, cmd.Err
 	}
-	return &writeableRepo{
+	return &execWriteableRepo{
 		repository{rootDir: dst, gitInterface: &g},
 	}, nil
 }
@@ -103,7 +130,7 @@ func (g git) OpenRepository(repoDir string) (Repository, error) {
 }
 
 func (g git) openRepository(repoDir string) (*repository, error) {
-	cmd := exec.Exec(repoDir, "git", "rev-parse", "--show-toplevel")
+	cmd := exec.Exec(g.ctx, repoDir, "git", "rev-parse", "--show-toplevel")
 	if cmd.Err != nil {
 		return nil, cmd.Err
 	}
@@ -118,7 +145,7 @@ func (g git) openRepository(repoDir string) (*repository, error) {
 // currentDir. e.g. if there's a git repo in ~/hello, and currentDir is
 // ~/hello/potato/tomato, returns "potato/tomato".
 func (g git) GetRelativeLocation(currentDir string) (string, error) {
-	cmd := exec.Exec(currentDir, "git", "rev-parse", "--show-prefix")
+	cmd := exec.Exec(g.ctx, currentDir, "git", "rev-parse", "--show-prefix")
 	if cmd.Err != nil {
 		return "", cmd.Err
 	}
@@ -0,0 +1,308 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/files"
+)
+
+// Files stored in Git LFS get checked out as small pointer files, and the
+// git-lfs smudge filter normally swaps them for the real content, downloading
+// it if necessary. Grouse never wants to touch the network, so instead of
+// running the filter, it replaces the pointers with objects from the LFS
+// stores in the user's repo (and its submodules) directly.
+
+const (
+	// If git-lfs is installed, its smudge filter would try to download LFS
+	// objects whenever git writes files out. This goes in the environment of
+	// those git commands to stop it.
+	lfsSkipSmudge    = "GIT_LFS_SKIP_SMUDGE=1"
+	lfsPointerHeader = "version https://git-lfs.github.com/spec/v1\n"
+	// Pointer files are always smaller than this, according to the spec.
+	lfsMaxPointerSize = 1024
+)
+
+type lfsPointer struct {
+	// Path of the pointer file, relative to the root of the checkout.
+	path string
+	oid  string
+	size int64
+}
+
+var lfsOidRegexp = regexp.MustCompile(`^oid sha256:([0-9a-f]{64})$`)
+
+// parseLFSPointer returns nil if content isn't an LFS pointer.
+func parseLFSPointer(content []byte) *lfsPointer {
+	if !bytes.HasPrefix(content, []byte(lfsPointerHeader)) {
+		return nil
+	}
+	pointer := &lfsPointer{size: -1}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := lfsOidRegexp.FindStringSubmatch(line); match != nil {
+			pointer.oid = match[1]
+		} else if strings.HasPrefix(line, "size ") {
+			size, err := strconv.ParseInt(strings.TrimPrefix(line, "size "), 10, 64)
+			if err == nil {
+				pointer.size = size
+			}
+		}
+	}
+	if pointer.oid == "" || pointer.size < 0 {
+		return nil
+	}
+	return pointer
+}
+
+// MissingLFSObjectsError means that a checkout contained LFS pointers, but the
+// objects that they point to aren't in the user's local LFS store.
+type MissingLFSObjectsError struct {
+	Pointers []string
+}
+
+func (e *MissingLFSObjectsError) Error() string {
+	return fmt.Sprintf(
+		"These files are stored in Git LFS, but their content isn't available locally, "+
+			"and grouse doesn't download anything. Try `git lfs fetch` for the revisions you're comparing.\n  %s",
+		strings.Join(e.Pointers, "\n  "))
+}
+
+// lfsAttributeRule is a line from a .gitattributes file which sets or unsets
+// the filter attribute.
+type lfsAttributeRule struct {
+	// The directory holding the .gitattributes file, relative to the root of
+	// the checkout; "" for the root itself.
+	dir     string
+	pattern string
+	// Whether matching files use the LFS filter. Rules that set some other
+	// filter, or unset it, turn it off again.
+	lfs bool
+}
+
+// parseLFSAttributes returns the rules in a .gitattributes file that say
+// whether files use the LFS filter.
+func parseLFSAttributes(content []byte, dir string) []lfsAttributeRule {
+	rules := []lfsAttributeRule{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "[attr]") {
+			continue
+		}
+		for _, attr := range fields[1:] {
+			if attr == "filter" || attr == "-filter" || attr == "!filter" || strings.HasPrefix(attr, "filter=") {
+				rules = append(rules, lfsAttributeRule{dir: dir, pattern: fields[0], lfs: attr == "filter=lfs"})
+			}
+		}
+	}
+	return rules
+}
+
+// matches reports whether the rule applies to the file at relPath, relative
+// to the root of the checkout, using the same rules as git: patterns without
+// a slash match the file name at any depth, and other patterns match the
+// whole path relative to the .gitattributes file.
+func (r lfsAttributeRule) matches(relPath string) bool {
+	if r.dir != "" {
+		if !strings.HasPrefix(relPath, r.dir+"/") {
+			return false
+		}
+		relPath = strings.TrimPrefix(relPath, r.dir+"/")
+	}
+	if !strings.Contains(r.pattern, "/") {
+		ok, _ := path.Match(r.pattern, path.Base(relPath))
+		return ok
+	}
+	return matchPathSegments(strings.Split(strings.TrimPrefix(r.pattern, "/"), "/"), strings.Split(relPath, "/"))
+}
+
+// matchPathSegments matches a path against a pattern one segment at a time,
+// where a "**" segment matches any number of segments.
+func matchPathSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchPathSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], name[0])
+	return ok && matchPathSegments(pattern[1:], name[1:])
+}
+
+// findLFSPointers walks dir and returns all the LFS pointer files in it. Only
+// files that a .gitattributes file in dir puts through the LFS filter get
+// read; other files that look like pointers aren't LFS files.
+func findLFSPointers(dir string) ([]lfsPointer, error) {
+	rules := []lfsAttributeRule{}
+	candidates := []string{}
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == gitDirName {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isFile(info) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.Name() == ".gitattributes" {
+			content, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				return err
+			}
+			attrDir := path.Dir(rel)
+			if attrDir == "." {
+				attrDir = ""
+			}
+			rules = append(rules, parseLFSAttributes(content, attrDir)...)
+		} else if info.Size() <= lfsMaxPointerSize {
+			candidates = append(candidates, rel)
+		}
+		return nil
+	})
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	// Rules in deeper directories take precedence, and within a directory,
+	// later lines do.
+	sort.SliceStable(rules, func(i, j int) bool {
+		return pathDepth(rules[i].dir) < pathDepth(rules[j].dir)
+	})
+
+	pointers := []lfsPointer{}
+	for _, candidate := range candidates {
+		usesLFS := false
+		for _, rule := range rules {
+			if rule.matches(candidate) {
+				usesLFS = rule.lfs
+			}
+		}
+		if !usesLFS {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(candidate)))
+		if err != nil {
+			return nil, err
+		}
+		if pointer := parseLFSPointer(content); pointer != nil {
+			pointer.path = candidate
+			pointers = append(pointers, *pointer)
+		}
+	}
+	return pointers, nil
+}
+
+func pathDepth(dir string) int {
+	if dir == "" {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// hydrateLFSObjects replaces every LFS pointer in the checkout at dst with the
+// corresponding object from the LFS stores of the user's repository at src,
+// and any submodules checked out inside it.
+func hydrateLFSObjects(src *repository, dst string) error {
+	pointers, err := findLFSPointers(dst)
+	if err != nil || len(pointers) == 0 {
+		return err
+	}
+	src.log().Debugf("Found %d LFS pointers, replacing them with local LFS objects\n", len(pointers))
+
+	stores := map[string]string{}
+	missing := []string{}
+	for _, pointer := range pointers {
+		store, err := src.lfsStoreFor(pointer.path, stores)
+		if err != nil {
+			return err
+		}
+		object := filepath.Join(store, pointer.oid[0:2], pointer.oid[2:4], pointer.oid)
+		info, err := os.Stat(object)
+		if err != nil || info.Size() != pointer.size {
+			missing = append(missing, fmt.Sprintf("%s (%s)", pointer.path, pointer.oid))
+			continue
+		}
+		// The pointer file is already there, so it keeps its mode.
+		if err := files.Copy(object, filepath.Join(dst, filepath.FromSlash(pointer.path)), 0); err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingLFSObjectsError{Pointers: missing}
+	}
+	return nil
+}
+
+// lfsStoreFor finds the LFS object store for the repository that filePath
+// belongs to; that's the repo itself, unless the file is inside a submodule.
+// cache maps repository directories to their stores.
+func (r *repository) lfsStoreFor(filePath string, cache map[string]string) (string, error) {
+	// Walk up from the file's directory until we find a repository in the
+	// user's checkout; the deepest one is the one that owns the file.
+	for dir := path.Dir(filePath); ; dir = path.Dir(dir) {
+		if dir == "." || dir == "/" {
+			dir = ""
+		}
+		repoDir := filepath.Join(r.rootDir, filepath.FromSlash(dir))
+		if store, ok := cache[repoDir]; ok {
+			return store, nil
+		}
+		if _, err := os.Lstat(filepath.Join(repoDir, gitDirName)); err == nil || dir == "" {
+			repo := repository{rootDir: repoDir, gitInterface: r.gitInterface}
+			store, err := repo.lfsStore()
+			if err != nil {
+				return "", err
+			}
+			cache[repoDir] = store
+			return store, nil
+		}
+	}
+}
+
+// lfsStore returns the directory that git-lfs stores objects in for this repo.
+func (r *repository) lfsStore() (string, error) {
+	cmd := r.runCommand("git", "rev-parse", "--git-common-dir")
+	if cmd.Err != nil {
+		return "", cmd.Err
+	}
+	gitDir := cmd.StdOut
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(r.rootDir, gitDir)
+	}
+	// lfs.storage overrides the default location; relative paths are relative
+	// to the git dir.
+	storage := filepath.Join(gitDir, "lfs")
+	if cmd := r.runCommand("git", "config", "lfs.storage"); cmd.Err == nil && cmd.StdOut != "" {
+		storage = cmd.StdOut
+		if !filepath.IsAbs(storage) {
+			storage = filepath.Join(gitDir, storage)
+		}
+	}
+	return filepath.Join(storage, "objects"), nil
+}
@@ -0,0 +1,98 @@
+package git
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func pointerFor(content []byte) (string, string) {
+	oid := fmt.Sprintf("%x", sha256.Sum256(content))
+	return oid, fmt.Sprintf("%soid sha256:%s\nsize %d\n", lfsPointerHeader, oid, len(content))
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	c := qt.New(t)
+	oid, pointer := pointerFor([]byte("hello"))
+	parsed := parseLFSPointer([]byte(pointer))
+	c.Assert(parsed, qt.Not(qt.IsNil))
+	c.Check(parsed.oid, qt.Equals, oid)
+	c.Check(parsed.size, qt.Equals, int64(5))
+	c.Check(parseLFSPointer([]byte("version 1\noid sha256:abc\n")), qt.IsNil)
+	// Missing the size.
+	c.Check(parseLFSPointer([]byte(lfsPointerHeader+"oid sha256:"+oid+"\n")), qt.IsNil)
+}
+
+func TestHydrateLFSObjects(t *testing.T) {
+	c := qt.New(t)
+	g := NewGit(context.Background()).(git)
+
+	srcDir, dstDir := tempDir(c), tempDir(c)
+	defer os.RemoveAll(srcDir)
+	defer os.RemoveAll(dstDir)
+	_, err := g.NewRepository(srcDir)
+	c.Assert(err, qt.IsNil)
+	src := &repository{rootDir: srcDir, gitInterface: &g}
+
+	content := []byte("\x89PNG not really")
+	oid, pointer := pointerFor(content)
+	object := filepath.Join(srcDir, ".git", "lfs", "objects", oid[0:2], oid[2:4], oid)
+	c.Assert(os.MkdirAll(filepath.Dir(object), os.ModePerm), qt.IsNil)
+	c.Assert(ioutil.WriteFile(object, content, 0644), qt.IsNil)
+
+	image := filepath.Join(dstDir, "static", "image.png")
+	c.Assert(os.MkdirAll(filepath.Dir(image), os.ModePerm), qt.IsNil)
+	c.Assert(ioutil.WriteFile(image, []byte(pointer), 0644), qt.IsNil)
+
+	// Without any LFS attributes, it's just a file that looks like a pointer.
+	c.Assert(hydrateLFSObjects(src, dstDir), qt.IsNil)
+	written, err := ioutil.ReadFile(image)
+	c.Assert(err, qt.IsNil)
+	c.Check(string(written), qt.Equals, pointer)
+
+	// Big .gitattributes files still count, and only files that match an
+	// LFS pattern get replaced.
+	notLFS := filepath.Join(dstDir, "static", "pointer.txt")
+	c.Assert(ioutil.WriteFile(notLFS, []byte(pointer), 0644), qt.IsNil)
+	attributes := "# " + strings.Repeat("padding ", 200) + "\n*.png filter=lfs diff=lfs merge=lfs -text\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(dstDir, ".gitattributes"), []byte(attributes), 0644), qt.IsNil)
+	c.Assert(hydrateLFSObjects(src, dstDir), qt.IsNil)
+	written, err = ioutil.ReadFile(image)
+	c.Assert(err, qt.IsNil)
+	c.Check(written, qt.DeepEquals, content)
+	written, err = ioutil.ReadFile(notLFS)
+	c.Assert(err, qt.IsNil)
+	c.Check(string(written), qt.Equals, pointer)
+
+	// Objects that aren't in the local store are reported, not downloaded.
+	_, missingPointer := pointerFor([]byte("not stored"))
+	c.Assert(ioutil.WriteFile(image, []byte(missingPointer), 0644), qt.IsNil)
+	err = hydrateLFSObjects(src, dstDir)
+	c.Assert(err, qt.Not(qt.IsNil))
+	c.Check(err.(*MissingLFSObjectsError).Pointers, qt.HasLen, 1)
+}
+
+func TestLFSAttributeRules(t *testing.T) {
+	c := qt.New(t)
+	root := parseLFSAttributes([]byte("# comment\n*.png filter=lfs -text\nassets/**/*.psd filter=lfs\n*.md text\n"), "")
+	c.Assert(root, qt.HasLen, 2)
+	c.Check(root[0].matches("static/img/logo.png"), qt.Equals, true)
+	c.Check(root[0].matches("logo.png.txt"), qt.Equals, false)
+	c.Check(root[1].matches("assets/design.psd"), qt.Equals, true)
+	c.Check(root[1].matches("assets/a/b/design.psd"), qt.Equals, true)
+	c.Check(root[1].matches("web/assets/design.psd"), qt.Equals, false)
+
+	nested := parseLFSAttributes([]byte("/logo.png -filter\n"), "themes/paper")
+	c.Assert(nested, qt.HasLen, 1)
+	c.Check(nested[0].lfs, qt.Equals, false)
+	c.Check(nested[0].matches("themes/paper/logo.png"), qt.Equals, true)
+	c.Check(nested[0].matches("themes/paper/static/logo.png"), qt.Equals, false)
+	c.Check(nested[0].matches("logo.png"), qt.Equals, false)
+}
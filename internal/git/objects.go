@@ -0,0 +1,245 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// This file writes git repositories in-process, without forking git. It only
+// implements the small subset of git that the output repository needs: loose
+// objects, trees, commits, and a single branch. The results are ordinary git
+// repositories, so `git diff` and friends work on them as usual.
+
+const (
+	gitDirName    = ".git"
+	defaultBranch = "refs/heads/master"
+
+	modeFile       = "100644"
+	modeExecutable = "100755"
+	modeSymlink    = "120000"
+	modeTree       = "40000"
+)
+
+// initRepository lays out an empty repository in dst, equivalent to `git init`.
+func initRepository(dst string) error {
+	gitDir := filepath.Join(dst, gitDirName)
+	for _, dir := range []string{"objects", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(gitDir, filepath.FromSlash(dir)), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	config := "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = false\n"
+	if err := ioutil.WriteFile(filepath.Join(gitDir, "config"), []byte(config), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: "+defaultBranch+"\n"), 0644)
+}
+
+type objectStore struct {
+	gitDir string
+	// Scratch space which gets reused for every object, so that writing lots
+	// of small files doesn't turn into lots of allocations.
+	buf        bytes.Buffer
+	compressor *zlib.Writer
+	// Fan-out directories (e.g. `objects/ab`) which are known to exist.
+	createdDirs map[string]bool
+}
+
+func newObjectStore(gitDir string) *objectStore {
+	// Git also uses the fastest compression level for loose objects.
+	compressor, _ := zlib.NewWriterLevel(nil, zlib.BestSpeed)
+	return &objectStore{
+		gitDir:      gitDir,
+		compressor:  compressor,
+		createdDirs: make(map[string]bool),
+	}
+}
+
+// writeObject stores an object of the given type, streaming its content from r,
+// and returns its hash. size must be the exact length of the content.
+func (s *objectStore) writeObject(objType string, size int64, r io.Reader) (Hash, error) {
+	s.buf.Reset()
+	s.compressor.Reset(&s.buf)
+	hasher := sha1.New()
+	w := io.MultiWriter(hasher, s.compressor)
+	if _, err := fmt.Fprintf(w, "%s %d\x00", objType, size); err != nil {
+		return NilHash, err
+	}
+	written, err := io.Copy(w, r)
+	if err != nil {
+		return NilHash, err
+	}
+	if written != size {
+		return NilHash, fmt.Errorf("Expected %d bytes for %s object but got %d", size, objType, written)
+	}
+	if err := s.compressor.Close(); err != nil {
+		return NilHash, err
+	}
+
+	h := hashFrom(hasher)
+	fanOutDir := filepath.Join(s.gitDir, "objects", string(h[:2]))
+	if !s.createdDirs[fanOutDir] {
+		if err := os.MkdirAll(fanOutDir, os.ModePerm); err != nil {
+			return NilHash, err
+		}
+		s.createdDirs[fanOutDir] = true
+	}
+	// Git creates objects read-only.
+	f, err := os.OpenFile(filepath.Join(fanOutDir, string(h[2:])), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if os.IsExist(err) {
+		// Objects are immutable, so if it's already there, we're done.
+		return h, nil
+	} else if err != nil {
+		return NilHash, err
+	}
+	if _, err := f.Write(s.buf.Bytes()); err != nil {
+		f.Close()
+		return NilHash, err
+	}
+	return h, f.Close()
+}
+
+func (s *objectStore) writeBytes(objType string, content []byte) (Hash, error) {
+	return s.writeObject(objType, int64(len(content)), bytes.NewReader(content))
+}
+
+func hashFrom(h hash.Hash) Hash {
+	return Hash(hex.EncodeToString(h.Sum(nil)))
+}
+
+type treeEntry struct {
+	mode string
+	name string
+	hash Hash
+}
+
+// sortKey returns the name that git uses to order tree entries: subtrees sort
+// as if their names had a trailing slash.
+func (e treeEntry) sortKey() string {
+	if e.mode == modeTree {
+		return e.name + "/"
+	}
+	return e.name
+}
+
+// writeTree recursively stores the contents of dir, returning the hash of the
+// resulting tree, or NilHash if there's nothing in it (git doesn't store empty
+// directories).
+func (s *objectStore) writeTree(dir string, skipGitDir bool) (Hash, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return NilHash, err
+	}
+	entries := []treeEntry{}
+	for _, file := range files {
+		if skipGitDir && file.Name() == gitDirName {
+			continue
+		}
+		entry, err := s.writeEntry(filepath.Join(dir, file.Name()), file)
+		if err != nil {
+			return NilHash, err
+		}
+		if entry.hash != NilHash {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return NilHash, nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].sortKey() < entries[j].sortKey()
+	})
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		raw, err := hex.DecodeString(string(entry.hash))
+		if err != nil {
+			return NilHash, err
+		}
+		fmt.Fprintf(&buf, "%s %s\x00", entry.mode, entry.name)
+		buf.Write(raw)
+	}
+	return s.writeBytes("tree", buf.Bytes())
+}
+
+func (s *objectStore) writeEntry(filePath string, file os.FileInfo) (treeEntry, error) {
+	entry := treeEntry{name: file.Name()}
+	var err error
+	switch {
+	case file.IsDir():
+		entry.mode = modeTree
+		entry.hash, err = s.writeTree(filePath, false)
+	case file.Mode()&os.ModeSymlink != 0:
+		entry.mode = modeSymlink
+		var target string
+		target, err = os.Readlink(filePath)
+		if err == nil {
+			entry.hash, err = s.writeBytes("blob", []byte(target))
+		}
+	case isFile(file):
+		entry.mode = modeFile
+		if file.Mode()&0111 != 0 {
+			entry.mode = modeExecutable
+		}
+		entry.hash, err = s.writeFile(filePath, file.Size())
+	default:
+		// Sockets, devices etc. Git ignores these too.
+	}
+	return entry, err
+}
+
+func (s *objectStore) writeFile(filePath string, size int64) (Hash, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return NilHash, err
+	}
+	defer f.Close()
+	return s.writeObject("blob", size, f)
+}
+
+func (s *objectStore) writeCommit(tree Hash, parent Hash, message string) (Hash, error) {
+	if tree == NilHash {
+		var err error
+		if tree, err = s.writeBytes("tree", []byte{}); err != nil {
+			return NilHash, err
+		}
+	}
+	signature := fmt.Sprintf("Grouse Diff <grouse-diff@example.com> %d +0000", time.Now().Unix())
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", tree)
+	if parent != NilHash {
+		fmt.Fprintf(&buf, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&buf, "author %s\ncommitter %s\n\n%s\n", signature, signature, message)
+	return s.writeBytes("commit", buf.Bytes())
+}
+
+func (s *objectStore) readRef(ref string) (Hash, error) {
+	content, err := ioutil.ReadFile(filepath.Join(s.gitDir, filepath.FromSlash(ref)))
+	if os.IsNotExist(err) {
+		return NilHash, nil
+	} else if err != nil {
+		return NilHash, err
+	}
+	return Hash(strings.TrimSpace(string(content))), nil
+}
+
+func (s *objectStore) updateRef(ref string, h Hash) error {
+	refPath := filepath.Join(s.gitDir, filepath.FromSlash(ref))
+	tmp := refPath + ".lock"
+	if err := ioutil.WriteFile(tmp, []byte(string(h)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, refPath)
+}
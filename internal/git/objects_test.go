@@ -0,0 +1,137 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// writeSite fills dir with a fake website containing numFiles pages.
+func writeSite(c *qt.C, dir string, numFiles int, variant string) {
+	for i := 0; i < numFiles; i++ {
+		page := filepath.Join(dir, "posts", fmt.Sprintf("post-%d", i%50), fmt.Sprintf("page-%d", i), "index.html")
+		c.Assert(os.MkdirAll(filepath.Dir(page), os.ModePerm), qt.IsNil)
+		content := fmt.Sprintf("<html><body>Page %d, %s</body></html>\n", i, variant)
+		c.Assert(ioutil.WriteFile(page, []byte(content), 0644), qt.IsNil)
+	}
+	// Some edge-cases for tree ordering and file modes.
+	c.Assert(os.MkdirAll(filepath.Join(dir, "a", "empty"), os.ModePerm), qt.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644), qt.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "a", "run.sh"), []byte("#!/bin/sh\n"), 0755), qt.IsNil)
+	c.Assert(os.Symlink("a.txt", filepath.Join(dir, "link")), qt.IsNil)
+}
+
+func tempDir(c *qt.C) string {
+	dir, err := ioutil.TempDir("", "grouse-objects-test")
+	c.Assert(err, qt.IsNil)
+	return dir
+}
+
+func treeOf(c *qt.C, repo *repository, commit Hash) string {
+	cmd := repo.runCommand("git", "rev-parse", string(commit)+"^{tree}")
+	c.Assert(cmd.Err, qt.IsNil)
+	return cmd.StdOut
+}
+
+func TestInProcessCommitsMatchGit(t *testing.T) {
+	c := qt.New(t)
+	g := NewGit(context.Background()).(git)
+
+	inProcessDir, execDir := tempDir(c), tempDir(c)
+	defer os.RemoveAll(inProcessDir)
+	defer os.RemoveAll(execDir)
+
+	inProcess, err := g.NewRepository(inProcessDir)
+	c.Assert(err, qt.IsNil)
+	viaExec, err := g.newExecRepository(execDir)
+	c.Assert(err, qt.IsNil)
+
+	for _, variant := range []string{"first", "second"} {
+		for _, repo := range []WriteableRepository{inProcess, viaExec} {
+			c.Assert(repo.ClearSourceControlledFilesFromWorktree(), qt.IsNil)
+			writeSite(c, repo.RootDir(), 20, variant)
+		}
+		inProcessHash, err := inProcess.CommitEverythingInWorktree("Built " + variant)
+		c.Assert(err, qt.IsNil)
+		execHash, err := viaExec.CommitEverythingInWorktree("Built " + variant)
+		c.Assert(err, qt.IsNil)
+
+		// Commit hashes differ because of timestamps, but the trees should be
+		// identical, and git should be able to read everything we wrote.
+		c.Check(
+			treeOf(c, &inProcess.(*writeableRepo).repository, inProcessHash),
+			qt.Equals,
+			treeOf(c, &viaExec.(*execWriteableRepo).repository, execHash))
+		cmd := inProcess.(*writeableRepo).runCommand("git", "fsck", "--strict")
+		c.Check(cmd.Err, qt.IsNil, qt.Commentf("fsck output: %s", cmd.StdErr))
+	}
+
+	repo := &inProcess.(*writeableRepo).repository
+	cmd := repo.runCommand("git", "rev-list", "--count", "HEAD")
+	c.Assert(cmd.Err, qt.IsNil)
+	c.Check(cmd.StdOut, qt.Equals, "2")
+
+	changes, err := repo.ChangedFiles("HEAD^", "HEAD")
+	c.Assert(err, qt.IsNil)
+	c.Check(changes, qt.HasLen, 20)
+	c.Check(changes[0].Status, qt.Equals, Modified)
+
+	files, err := repo.ListFiles("HEAD", "a")
+	c.Assert(err, qt.IsNil)
+	c.Check(files, qt.DeepEquals, []string{"a/run.sh"})
+	files, err = repo.ListFiles("HEAD", ".")
+	c.Assert(err, qt.IsNil)
+	c.Check(files, qt.HasLen, 23)
+
+	diff, err := repo.FileDiff("HEAD^", "HEAD", "posts/post-0/page-0/index.html")
+	c.Assert(err, qt.IsNil)
+	c.Check(diff, qt.Contains, "-<html><body>Page 0, first</body></html>\n+<html><body>Page 0, second</body></html>\n")
+
+	read := map[string]string{}
+	err = repo.ReadFiles("HEAD", []string{"a/run.sh", "missing.html", "a", "posts/post-1/page-1/index.html"}, func(filePath string, content []byte) error {
+		read[filePath] = string(content)
+		return nil
+	})
+	c.Assert(err, qt.IsNil)
+	c.Check(read, qt.DeepEquals, map[string]string{
+		"a/run.sh":                       "#!/bin/sh\n",
+		"posts/post-1/page-1/index.html": "<html><body>Page 1, second</body></html>\n",
+	})
+	stop := errors.New("stop")
+	err = repo.ReadFiles("HEAD", files, func(string, []byte) error { return stop })
+	c.Check(err, qt.Equals, stop)
+}
+
+func benchmarkCommit(b *testing.B, newRepo func(g git, dst string) (WriteableRepository, error)) {
+	c := qt.New(b)
+	g := NewGit(context.Background()).(git)
+	dir := tempDir(c)
+	defer os.RemoveAll(dir)
+	repo, err := newRepo(g, dir)
+	c.Assert(err, qt.IsNil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		c.Assert(repo.ClearSourceControlledFilesFromWorktree(), qt.IsNil)
+		writeSite(c, dir, 2000, fmt.Sprint(i))
+		b.StartTimer()
+
+		_, err := repo.CommitEverythingInWorktree("Benchmark")
+		c.Assert(err, qt.IsNil)
+	}
+}
+
+func BenchmarkCommitInProcess(b *testing.B) {
+	benchmarkCommit(b, git.NewRepository)
+}
+
+func BenchmarkCommitExec(b *testing.B) {
+	benchmarkCommit(b, git.newExecRepository)
+}
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
@@ -14,7 +15,54 @@ import (
 type Repository interface {
 	RootDir() string
 	ResolveCommit(ref string) (ResolvedUserRef, error)
-	RecursiveSharedCloneTo(dst string) (WorktreeRepository, error)
+	// RecursiveSharedCloneTo, AddDetachedWorktree and ExportTo all create a
+	// scratch directory at dst that commits can be checked out into. If
+	// sparsePaths isn't empty, only those paths (relative to the root of the
+	// repo), and submodules within them, get checked out.
+
+	// RecursiveSharedCloneTo makes a shared clone of the repository, and all
+	// its submodules, at dst.
+	RecursiveSharedCloneTo(dst string, sparsePaths []string) (WorktreeRepository, error)
+	// AddDetachedWorktree creates a linked worktree (as in `git worktree add`)
+	// at dst. It's faster than RecursiveSharedCloneTo, but doesn't support
+	// submodules.
+	AddDetachedWorktree(dst string, sparsePaths []string) (WorktreeRepository, error)
+	// ExportTo creates a directory at dst which has commits exported into it
+	// on Checkout, without any git metadata.
+	ExportTo(dst string, sparsePaths []string) (WorktreeRepository, error)
+	// UsesSubmodules returns true if the given commit has a .gitmodules file.
+	UsesSubmodules(commit Hash) bool
+	// ChangedFiles lists the files that differ between two commits.
+	ChangedFiles(from, to Hash) ([]FileChange, error)
+	// ChangedFilesInWorkingTree lists the files in the repository's working
+	// tree that differ from the given commit, including new files which
+	// aren't ignored.
+	ChangedFilesInWorkingTree(from Hash) ([]FileChange, error)
+	// ChangedLines lists the runs of lines that differ in the file at
+	// filePath between two commits. It returns ErrBinaryFile if git thinks
+	// the file isn't text.
+	ChangedLines(from, to Hash, filePath string) ([]LineChange, error)
+	// FileDiff returns the output of `git diff` for the file at filePath
+	// between two commits.
+	FileDiff(from, to Hash, filePath string) (string, error)
+	// ReadFile returns the contents of the file at filePath in the given
+	// commit.
+	ReadFile(commit Hash, filePath string) ([]byte, error)
+	// ReadFiles calls read with the contents of each of the files at
+	// filePaths in the given commit, in order, using a single git process.
+	// Files which don't exist get skipped. If read returns an error, ReadFiles
+	// stops and returns it.
+	ReadFiles(commit Hash, filePaths []string, read func(filePath string, content []byte) error) error
+	// FileSizes returns the size in bytes of each of the files at filePaths
+	// in the given commit, using a single git process. Files which don't exist
+	// are left out.
+	FileSizes(commit Hash, filePaths []string) (map[string]int, error)
+	// ListFiles returns the paths of all the files in dir (relative to the
+	// root of the repo, or "." for all of it) in the given commit.
+	ListFiles(commit Hash, dir string) ([]string, error)
+	// Submodules returns the commit that each submodule is at in the given
+	// commit, by path.
+	Submodules(commit Hash) (map[string]Hash, error)
 }
 
 // concrete implementation
@@ -34,6 +82,10 @@ type WorktreeRepository interface {
 
 type worktreeRepository struct {
 	repository
+	// If set, only these paths get checked out.
+	sparsePaths []string
+	// The user's repository, which LFS objects get copied from.
+	source *repository
 }
 
 // WriteableRepository is a Repository that allows commits / edits to the git
@@ -49,14 +101,26 @@ type WriteableRepository interface {
 
 type writeableRepo struct {
 	repository
+	objects *objectStore
 }
 
 func (r *repository) RootDir() string {
 	return r.rootDir
 }
 
+// log is where messages about r go; see out.FromContext.
+func (r *repository) log() *out.Logger {
+	return out.FromContext(r.gitInterface.ctx)
+}
+
 func (r *repository) runCommand(args ...string) exec.CmdResult {
-	return exec.Exec(r.rootDir, args...)
+	return exec.Exec(r.gitInterface.ctx, r.rootDir, args...)
+}
+
+// runCheckoutCommand is like runCommand, for commands that write files from
+// the repo into a checkout.
+func (r *repository) runCheckoutCommand(args ...string) exec.CmdResult {
+	return exec.ExecWithEnv(r.gitInterface.ctx, r.rootDir, []string{lfsSkipSmudge}, args...)
 }
 
 func (r *repository) ResolveCommit(ref string) (ResolvedUserRef, error) {
@@ -88,18 +152,18 @@ func (r *repository) getSubmodulePaths() ([]submodInfo, error) {
 	_, err := os.Lstat(path.Join(r.rootDir, ".gitmodules"))
 	if err != nil && os.IsNotExist(err) {
 		// No submodules, just chill.
-		out.Debugln("No submodules found in repo.")
+		r.log().Debugln("No submodules found in repo.")
 		return []submodInfo{}, nil
 	} else if err != nil {
 		// I can't imagine what error this could be...
-		out.Debugf("Got error %v when attempting to find .gitmodules file; pretending there isn't any\n", err)
+		r.log().Debugf("Got error %v when attempting to find .gitmodules file; pretending there isn't any\n", err)
 		return []submodInfo{}, err
 	}
 	// This fetches one line per submodule, e.g.
 	// submodule.themes/paperesque.path themes/paperesque
 	cmd := r.runCommand("git", "config", "--file", ".gitmodules", "--get-regexp", `submodule\..*\.path`)
 	if cmd.Err != nil {
-		out.Debugf("Got error %v when attempting to load submodule config; pretending there aren't any submodules\n", err)
+		r.log().Debugf("Got error %v when attempting to load submodule config; pretending there aren't any submodules\n", err)
 		return []submodInfo{}, cmd.Err
 	}
 	lines := strings.Split(cmd.StdOut, "\n")
@@ -133,11 +197,15 @@ func prepSubmodulesForSharedClone(src *repository, dst *worktreeRepository) erro
 	}
 
 	for _, submod := range submodPaths {
+		if !isWithinPaths(submod.path, dst.sparsePaths) {
+			src.log().Debugf("Skipping submodule at %s, it's outside the sparse checkout\n", submod.path)
+			continue
+		}
 		submodRepo, err := src.gitInterface.openRepository(path.Join(src.rootDir, submod.path))
 
 		if err != nil {
 			// Something mysterious went wrong; just ignore and continue.
-			out.Debugf("Unable to prepare submodule: %v\n", err)
+			src.log().Debugf("Unable to prepare submodule: %v\n", err)
 			continue
 		}
 
@@ -146,17 +214,15 @@ func prepSubmodulesForSharedClone(src *repository, dst *worktreeRepository) erro
 			// openRepository take an argument as to whether it's allowed to look further up the tree.
 
 			// This indicates that the submodule isn't in the right place etc etc.
-			out.Debugf("Thought %s was a repo, but it wasn't, abandoning attempt to load submodule\n", submod.path)
+			src.log().Debugf("Thought %s was a repo, but it wasn't, abandoning attempt to load submodule\n", submod.path)
 			continue
 		}
 
-		out.Debugf("Recursively cloning submodule at %s...\n", submod.path)
-		clonedSubmodRepo, err := submodRepo.recursiveSharedCloneTo(path.Join(dst.rootDir, submod.path))
+		src.log().Debugf("Recursively cloning submodule at %s...\n", submod.path)
+		clonedSubmodRepo, err := submodRepo.recursiveSharedCloneTo(path.Join(dst.rootDir, submod.path), nil)
 
 		if err != nil {
-			// this should only happen in real bad circumstances, so panic,
-			// even though it's probably recoverable.
-			panic(err)
+			return err
 		}
 
 		// TODO: extract this code to patch up the config
@@ -164,41 +230,52 @@ func prepSubmodulesForSharedClone(src *repository, dst *worktreeRepository) erro
 		cmd = src.runCommand("git", "config", urlConfigName)
 		realRemoteURL := cmd.StdOut
 		if cmd.Err != nil {
-			panic(cmd.Err)
+			return cmd.Err
 		}
 		cmd = dst.runCommand("git", "config", urlConfigName, realRemoteURL)
 		if cmd.Err != nil {
-			panic(cmd.Err)
+			return cmd.Err
 		}
 		// _AND_ we have to change the remote so we can pull commits if we have to.
 		cmd = clonedSubmodRepo.runCommand("git", "remote", "set-url", "origin", realRemoteURL)
 		if cmd.Err != nil {
-			panic(cmd.Err)
+			return cmd.Err
 		}
 	}
 	return nil
 }
 
-func (r *repository) RecursiveSharedCloneTo(dst string) (WorktreeRepository, error) {
-	return r.recursiveSharedCloneTo(dst)
+func (r *repository) RecursiveSharedCloneTo(dst string, sparsePaths []string) (WorktreeRepository, error) {
+	return r.recursiveSharedCloneTo(dst, cleanSparsePaths(sparsePaths))
 }
 
-func (r *repository) recursiveSharedCloneTo(dst string) (*worktreeRepository, error) {
+func (r *repository) recursiveSharedCloneTo(dst string, sparsePaths []string) (*worktreeRepository, error) {
 	var args []string
 
 	// Note: using "--no-checkout" here works great for the root repo, but
 	// breaks things if you use it on submodule repos, because when you run
 	// `git submodule update` later, it refuses to clobber the dirty state in
-	// the submodule.
+	// the submodule. It's only used for sparse checkouts, which only apply to
+	// the root repo, because otherwise the first checkout would be a full one.
 	args = []string{"git", "clone", "--shared", r.rootDir, dst}
+	if len(sparsePaths) > 0 {
+		args = append(args, "--no-checkout")
+	}
 
-	cmd := r.runCommand(args...)
+	cmd := r.runCheckoutCommand(args...)
 	if cmd.Err != nil {
 		return nil, cmd.Err
 	}
 
 	wt := &worktreeRepository{
-		repository: repository{rootDir: dst, gitInterface: r.gitInterface},
+		repository:  repository{rootDir: dst, gitInterface: r.gitInterface},
+		sparsePaths: sparsePaths,
+		source:      r,
+	}
+	if len(sparsePaths) > 0 {
+		if err := wt.writeSparsePatterns(); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := prepSubmodulesForSharedClone(r, wt); err != nil {
@@ -215,7 +292,12 @@ func (w *worktreeRepository) Checkout(commit ResolvedCommit) error {
 	// when attempting this on something with nested submodules that had been
 	// moved into the root repo. You can test this out with
 	// `test-fixtures/unirepo-gitinfo.zip` between commits "742de0a", "353bfcb".
-	cmd := w.runCommand("git", "checkout", "--force", "--detach", string(commit.Hash()))
+	args := []string{"git"}
+	if len(w.sparsePaths) > 0 {
+		args = append(args, "-c", "core.sparseCheckout=true")
+	}
+	args = append(args, "checkout", "--force", "--detach", string(commit.Hash()))
+	cmd := w.runCheckoutCommand(args...)
 	if cmd.Err != nil {
 		return cmd.Err
 	}
@@ -231,8 +313,26 @@ func (w *worktreeRepository) Checkout(commit ResolvedCommit) error {
 	// Checkout submodules.
 	// --recursive -- automatically do everything in the entire tree
 	// --init -- if there are uninitialized submodules, then init them.
-	cmd = w.runCommand("git", "submodule", "update", "--recursive", "--init")
-	return cmd.Err
+	// Restricting to the sparse paths means that submodules outside them
+	// don't get initialized at all.
+	args = []string{"git", "submodule", "update", "--recursive", "--init"}
+	update := true
+	if len(w.sparsePaths) > 0 {
+		// git submodule update fails if any of the paths don't exist.
+		paths, err := w.existingPaths(commit.Hash(), w.sparsePaths)
+		if err != nil {
+			return err
+		}
+		args = append(append(args, "--"), paths...)
+		update = len(paths) > 0
+	}
+	if update {
+		cmd = w.runCheckoutCommand(args...)
+		if cmd.Err != nil {
+			return cmd.Err
+		}
+	}
+	return hydrateLFSObjects(w.source, w.rootDir)
 }
 
 func (w *worktreeRepository) Remove() error {
@@ -240,12 +340,62 @@ func (w *worktreeRepository) Remove() error {
 }
 
 func (r *writeableRepo) ClearSourceControlledFilesFromWorktree() error {
+	// Everything in the worktree was committed by CommitEverythingInWorktree,
+	// so everything except the git directory itself goes.
+	return removeContents(r.rootDir, gitDirName)
+}
+
+// removeContents removes everything inside dir except for the entry named
+// keep, if any.
+func removeContents(dir string, keep string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.Name() == keep {
+			continue
+		}
+		if err := os.RemoveAll(path.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *writeableRepo) CommitEverythingInWorktree(message string) (Hash, error) {
+	// Unlike `git add .`, this doesn't pay attention to .gitignore files, so
+	// if your build produces one, everything still gets committed.
+	tree, err := r.objects.writeTree(r.rootDir, true)
+	if err != nil {
+		return NilHash, err
+	}
+	parent, err := r.objects.readRef(defaultBranch)
+	if err != nil {
+		return NilHash, err
+	}
+	commit, err := r.objects.writeCommit(tree, parent, message)
+	if err != nil {
+		return NilHash, err
+	}
+	return commit, r.objects.updateRef(defaultBranch, commit)
+}
+
+// execWriteableRepo is the original implementation of WriteableRepository,
+// which shells out to git for everything. It's much slower than writeableRepo
+// for large sites, but it's kept around as a reference implementation to test
+// and benchmark against.
+type execWriteableRepo struct {
+	repository
+}
+
+func (r *execWriteableRepo) ClearSourceControlledFilesFromWorktree() error {
 	// TODO: document switches.
 	cmd := r.runCommand("git", "rm", "-r", "-q", "--ignore-unmatch", ".")
 	return cmd.Err
 }
 
-func (r *writeableRepo) CommitEverythingInWorktree(message string) (Hash, error) {
+func (r *execWriteableRepo) CommitEverythingInWorktree(message string) (Hash, error) {
 	// TODO: if your build produces a .gitignore file, everything that it
 	// references will be excluded from the commit. It probably shouldn't be. 😅
 	cmd := r.runCommand("git", "add", ".")
@@ -0,0 +1,71 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Sparse checkouts are done the old-fashioned way (core.sparseCheckout plus
+// an info/sparse-checkout file) rather than with `git sparse-checkout`, so that
+// they work with older versions of git. core.sparseCheckout is passed with -c
+// on every checkout instead of being written to the config, because linked
+// worktrees share their config with the user's repository.
+
+// cleanSparsePaths normalizes paths relative to the root of the repo (e.g.
+// "docs/site/" or "./assets") to the form "docs/site", and drops anything that
+// refers to the root of the repo, since that's not sparse at all.
+func cleanSparsePaths(paths []string) []string {
+	cleaned := []string{}
+	for _, p := range paths {
+		p = strings.Trim(path.Clean("/"+filepath.ToSlash(p)), "/")
+		if p == "" {
+			return nil
+		}
+		cleaned = append(cleaned, p)
+	}
+	return cleaned
+}
+
+// sparsePatterns renders paths in the format used by info/sparse-checkout.
+func sparsePatterns(paths []string) string {
+	// .gitmodules is always needed so that submodules inside the sparse paths
+	// can be initialized.
+	lines := []string{"/.gitmodules"}
+	for _, p := range paths {
+		// No trailing slash, so that these match files as well as directories.
+		lines = append(lines, "/"+p)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// isWithinPaths returns true if p is one of paths, or is inside one of them.
+// An empty list of paths means "everything".
+func isWithinPaths(p string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, prefix := range paths {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *worktreeRepository) writeSparsePatterns() error {
+	cmd := w.runCommand("git", "rev-parse", "--git-path", "info/sparse-checkout")
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	patternsFile := cmd.StdOut
+	if !filepath.IsAbs(patternsFile) {
+		patternsFile = filepath.Join(w.rootDir, patternsFile)
+	}
+	if err := os.MkdirAll(filepath.Dir(patternsFile), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(patternsFile, []byte(sparsePatterns(w.sparsePaths)), 0644)
+}
@@ -0,0 +1,66 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCleanSparsePaths(t *testing.T) {
+	c := qt.New(t)
+	c.Check(cleanSparsePaths([]string{"docs/site/", "./assets", "/shared//config.toml"}),
+		qt.DeepEquals, []string{"docs/site", "assets", "shared/config.toml"})
+	// Including the root of the repo means there's nothing sparse about it.
+	c.Check(cleanSparsePaths([]string{"docs/site", "."}), qt.IsNil)
+}
+
+func TestSparsePatterns(t *testing.T) {
+	c := qt.New(t)
+	c.Check(sparsePatterns([]string{"docs/site", "assets"}), qt.Equals, "/.gitmodules\n/docs/site\n/assets\n")
+}
+
+func TestIsWithinPaths(t *testing.T) {
+	c := qt.New(t)
+	paths := []string{"docs/site", "assets"}
+	c.Check(isWithinPaths("docs/site/themes/paperesque", paths), qt.Equals, true)
+	c.Check(isWithinPaths("assets", paths), qt.Equals, true)
+	c.Check(isWithinPaths("docs/site-old/themes/paperesque", paths), qt.Equals, false)
+	c.Check(isWithinPaths("vendor/x", paths), qt.Equals, false)
+	c.Check(isWithinPaths("vendor/x", nil), qt.Equals, true)
+}
+
+func TestSparseCloneOfCommitWithoutSparsePaths(t *testing.T) {
+	c := qt.New(t)
+	dir := tempDir(c)
+	defer os.RemoveAll(dir)
+	cloneDir := tempDir(c)
+	defer os.RemoveAll(cloneDir)
+	src, err := NewGit(context.Background()).(git).newExecRepository(dir)
+	c.Assert(err, qt.IsNil)
+	write := func(name, content string) {
+		p := filepath.Join(src.RootDir(), filepath.FromSlash(name))
+		c.Assert(os.MkdirAll(filepath.Dir(p), os.ModePerm), qt.IsNil)
+		c.Assert(ioutil.WriteFile(p, []byte(content), 0644), qt.IsNil)
+	}
+	write("README.md", "hi")
+	first, err := src.CommitEverythingInWorktree("First")
+	c.Assert(err, qt.IsNil)
+	write("docs/site/index.md", "site")
+	second, err := src.CommitEverythingInWorktree("Second")
+	c.Assert(err, qt.IsNil)
+
+	clone, err := src.RecursiveSharedCloneTo(filepath.Join(cloneDir, "clone"), []string{"docs/site", "assets"})
+	c.Assert(err, qt.IsNil)
+	for _, commit := range []Hash{first, second} {
+		ref, err := src.ResolveCommit(string(commit))
+		c.Assert(err, qt.IsNil)
+		c.Assert(clone.Checkout(ref.Commit()), qt.IsNil, qt.Commentf("commit %s", commit))
+	}
+	content, err := ioutil.ReadFile(filepath.Join(clone.RootDir(), "docs", "site", "index.md"))
+	c.Assert(err, qt.IsNil)
+	c.Check(string(content), qt.Equals, "site")
+}
@@ -0,0 +1,74 @@
+package git
+
+import (
+	"context"
+	"os"
+
+	"github.com/capnfabs/grouse/internal/exec"
+)
+
+// `git worktree add` has been around since 2.5, but `git worktree remove`
+// (which cleans up properly after us) only arrived in 2.17.
+func (g git) SupportsDetachedWorktrees() bool {
+	return g.version.isNewerThanOrEqualTo(2, 17, 0)
+}
+
+func (r *repository) UsesSubmodules(commit Hash) bool {
+	// cat-file -e exits non-zero if the file doesn't exist. Any other failure
+	// gets picked up when we try to check the commit out later.
+	cmd := r.runCommand("git", "cat-file", "-e", string(commit)+":.gitmodules")
+	return cmd.Err == nil
+}
+
+// linkedWorktreeRepository is a worktree created with `git worktree add`. It
+// shares the object database and refs with the user's repository, so it's much
+// cheaper to create than a clone. It doesn't work well with submodules though,
+// because they don't get shared between worktrees.
+type linkedWorktreeRepository struct {
+	worktreeRepository
+}
+
+func (r *repository) AddDetachedWorktree(dst string, sparsePaths []string) (WorktreeRepository, error) {
+	// --no-checkout because we're going to check something out straight
+	// away in Checkout() anyway, and for big repos that's slow.
+	cmd := r.runCommand("git", "worktree", "add", "--detach", "--no-checkout", dst, "HEAD")
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	wt := &linkedWorktreeRepository{
+		worktreeRepository: worktreeRepository{
+			repository:  repository{rootDir: dst, gitInterface: r.gitInterface},
+			sparsePaths: cleanSparsePaths(sparsePaths),
+			source:      r,
+		},
+	}
+	if len(wt.sparsePaths) > 0 {
+		if err := wt.writeSparsePatterns(); err != nil {
+			wt.Remove()
+			return nil, err
+		}
+	}
+	return wt, nil
+}
+
+func (w *linkedWorktreeRepository) Remove() error {
+	// This uses a fresh context, because Remove is usually called while
+	// cleaning up after the user hits Ctrl-C, and the worktree metadata in the
+	// user's repo should get cleaned up regardless.
+	cmd := exec.Exec(context.Background(), w.source.rootDir, "git", "worktree", "remove", "--force", w.rootDir)
+	if cmd.Err != nil {
+		w.log().Debugf("Couldn't remove worktree at %s: %v\n", w.rootDir, cmd.Err)
+		// Remove the directory manually and let git notice that it's gone.
+		if err := os.RemoveAll(w.rootDir); err != nil {
+			return err
+		}
+		return exec.Exec(context.Background(), w.source.rootDir, "git", "worktree", "prune").Err
+	}
+	return nil
+}
+
+// PruneWorktrees removes the metadata for any worktrees attached to the
+// repository in repoDir whose directories no longer exist.
+func PruneWorktrees(ctx context.Context, repoDir string) error {
+	return exec.Exec(ctx, repoDir, "git", "worktree", "prune").Err
+}
@@ -0,0 +1,253 @@
+// Package imgdiff compares pairs of raster images, so that changes to images
+// in a generated site can be reported as something more useful than "Binary
+// files differ".
+package imgdiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"path"
+	"strings"
+
+	// Register decoders for all the formats we know how to compare.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+var imageExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".webp": true,
+}
+
+// IsImagePath returns true if the file at filePath looks like an image that
+// imgdiff knows how to compare, judging by its extension.
+func IsImagePath(filePath string) bool {
+	return imageExtensions[strings.ToLower(path.Ext(filePath))]
+}
+
+// Info describes a single encoded image.
+type Info struct {
+	// Format is the name of the format, as registered with the image package,
+	// e.g. "png" or "webp".
+	Format string
+	Width  int
+	Height int
+	// Size is the size of the encoded image, in bytes.
+	Size int
+}
+
+// Comparison is the result of comparing two images.
+type Comparison struct {
+	A Info
+	B Info
+	// PixelIdentical is true if both images decode to exactly the same pixels,
+	// even if the encoded bytes differ.
+	PixelIdentical bool
+	// Score is a perceptual difference score from 0 (indistinguishable) to 1
+	// (completely different). It's computed as 1 - SSIM on the luma channel.
+	Score float64
+	// ChangedFraction is the fraction of pixels which visibly changed.
+	ChangedFraction float64
+	// Diff is an image highlighting changed pixels in red over a faded copy of
+	// image A. It's nil if the images are pixel-identical, or if either of
+	// them has no pixels at all.
+	Diff image.Image
+}
+
+// The minimum per-channel difference (out of 255) for a pixel to be highlighted
+// in the diff image. Anything less than this is usually compression noise.
+const changedThreshold = 3
+
+// Compare decodes two encoded images and compares them. Images of different
+// sizes are both resampled to the smaller of the two sizes before comparison.
+func Compare(a, b []byte) (*Comparison, error) {
+	imgA, formatA, err := image.Decode(bytes.NewReader(a))
+	if err != nil {
+		return nil, err
+	}
+	imgB, formatB, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	result := &Comparison{
+		A: info(imgA, formatA, len(a)),
+		B: info(imgB, formatB, len(b)),
+	}
+	if pixelsEqual(imgA, imgB) {
+		result.PixelIdentical = true
+		return result, nil
+	}
+
+	width := min(result.A.Width, result.B.Width)
+	height := min(result.A.Height, result.B.Height)
+	if width == 0 || height == 0 {
+		// Nothing to compare pixel-wise; one of them is empty.
+		result.Score = 1
+		result.ChangedFraction = 1
+		return result, nil
+	}
+	sampledA := resample(imgA, width, height)
+	sampledB := resample(imgB, width, height)
+
+	result.Score = 1 - ssim(sampledA, sampledB)
+	result.Diff, result.ChangedFraction = diffImage(sampledA, sampledB)
+	return result, nil
+}
+
+func info(img image.Image, format string, size int) Info {
+	bounds := img.Bounds()
+	return Info{
+		Format: format,
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+		Size:   size,
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func pixelsEqual(a, b image.Image) bool {
+	boundsA, boundsB := a.Bounds(), b.Bounds()
+	if boundsA.Dx() != boundsB.Dx() || boundsA.Dy() != boundsB.Dy() {
+		return false
+	}
+	for y := 0; y < boundsA.Dy(); y++ {
+		for x := 0; x < boundsA.Dx(); x++ {
+			r1, g1, b1, a1 := a.At(boundsA.Min.X+x, boundsA.Min.Y+y).RGBA()
+			r2, g2, b2, a2 := b.At(boundsB.Min.X+x, boundsB.Min.Y+y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// rgb is an image flattened onto a white background, with channels scaled to
+// [0, 1].
+type rgb struct {
+	width  int
+	height int
+	pix    [][3]float64
+}
+
+func (img *rgb) at(x, y int) [3]float64 {
+	return img.pix[y*img.width+x]
+}
+
+func (img *rgb) luma(x, y int) float64 {
+	p := img.at(x, y)
+	return 0.299*p[0] + 0.587*p[1] + 0.114*p[2]
+}
+
+// resample box-filters img down (or up) to width × height, and composites it
+// over white so that transparency changes are visible.
+func resample(img image.Image, width, height int) *rgb {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	out := &rgb{width: width, height: height, pix: make([][3]float64, width*height)}
+	for dy := 0; dy < height; dy++ {
+		y0 := dy * srcH / height
+		y1 := max((dy+1)*srcH/height, y0+1)
+		for dx := 0; dx < width; dx++ {
+			x0 := dx * srcW / width
+			x1 := max((dx+1)*srcW/width, x0+1)
+			var sum [3]float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+					// The colour values are alpha-premultiplied, so compositing
+					// over white is just adding the uncovered fraction.
+					white := float64(0xffff - a)
+					sum[0] += (float64(r) + white) / 0xffff
+					sum[1] += (float64(g) + white) / 0xffff
+					sum[2] += (float64(b) + white) / 0xffff
+				}
+			}
+			n := float64((y1 - y0) * (x1 - x0))
+			out.pix[dy*width+dx] = [3]float64{sum[0] / n, sum[1] / n, sum[2] / n}
+		}
+	}
+	return out
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// ssim computes the mean structural similarity of the luma channels of two
+// equally-sized images, over non-overlapping 8×8 windows.
+func ssim(a, b *rgb) float64 {
+	const window = 8
+	const c1 = 0.01 * 0.01
+	const c2 = 0.03 * 0.03
+	total := 0.0
+	windows := 0
+	for wy := 0; wy < a.height; wy += window {
+		for wx := 0; wx < a.width; wx += window {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			n := 0.0
+			for y := wy; y < a.height && y < wy+window; y++ {
+				for x := wx; x < a.width && x < wx+window; x++ {
+					la, lb := a.luma(x, y), b.luma(x, y)
+					sumA += la
+					sumB += lb
+					sumAA += la * la
+					sumBB += lb * lb
+					sumAB += la * lb
+					n++
+				}
+			}
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			covar := sumAB/n - meanA*meanB
+			total += ((2*meanA*meanB + c1) * (2*covar + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	return math.Max(0, math.Min(1, total/float64(windows)))
+}
+
+// diffImage renders changed pixels in red over a faded greyscale copy of a,
+// and returns the fraction of pixels that changed.
+func diffImage(a, b *rgb) (image.Image, float64) {
+	out := image.NewNRGBA(image.Rect(0, 0, a.width, a.height))
+	changed := 0
+	for y := 0; y < a.height; y++ {
+		for x := 0; x < a.width; x++ {
+			pa, pb := a.at(x, y), b.at(x, y)
+			delta := 0.0
+			for c := 0; c < 3; c++ {
+				delta = math.Max(delta, math.Abs(pa[c]-pb[c]))
+			}
+			if delta*255 >= changedThreshold {
+				changed++
+				// Scale so that even small changes are clearly visible.
+				intensity := uint8(128 + 127*math.Min(1, delta*4))
+				out.SetNRGBA(x, y, color.NRGBA{R: intensity, A: 0xff})
+			} else {
+				faded := uint8(255 - (1-a.luma(x, y))*255*0.3)
+				out.SetNRGBA(x, y, color.NRGBA{R: faded, G: faded, B: faded, A: 0xff})
+			}
+		}
+	}
+	return out, float64(changed) / float64(a.width*a.height)
+}
@@ -0,0 +1,88 @@
+package imgdiff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func checkerboard(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (x/4+y/4)%2 == 0 {
+				img.SetNRGBA(x, y, color.NRGBA{R: 0x20, G: 0x40, B: 0x80, A: 0xff})
+			} else {
+				img.SetNRGBA(x, y, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+			}
+		}
+	}
+	return img
+}
+
+func encodePNG(c *qt.C, img image.Image, level png.CompressionLevel) []byte {
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: level}
+	c.Assert(enc.Encode(&buf, img), qt.IsNil)
+	return buf.Bytes()
+}
+
+func TestPixelIdenticalButByteDifferent(t *testing.T) {
+	c := qt.New(t)
+	img := checkerboard(32, 32)
+	a := encodePNG(c, img, png.NoCompression)
+	b := encodePNG(c, img, png.BestCompression)
+	c.Assert(bytes.Equal(a, b), qt.Equals, false)
+
+	result, err := Compare(a, b)
+	c.Assert(err, qt.IsNil)
+	c.Check(result.PixelIdentical, qt.Equals, true)
+	c.Check(result.Diff, qt.IsNil)
+	c.Check(result.A.Size, qt.Equals, len(a))
+	c.Check(result.B.Size, qt.Equals, len(b))
+}
+
+func TestChangedPixels(t *testing.T) {
+	c := qt.New(t)
+	imgA := checkerboard(32, 32)
+	imgB := checkerboard(32, 32)
+	for x := 0; x < 32; x++ {
+		imgB.SetNRGBA(x, 0, color.NRGBA{R: 0xff, A: 0xff})
+	}
+
+	result, err := Compare(encodePNG(c, imgA, png.DefaultCompression), encodePNG(c, imgB, png.DefaultCompression))
+	c.Assert(err, qt.IsNil)
+	c.Check(result.PixelIdentical, qt.Equals, false)
+	c.Check(result.Score > 0, qt.Equals, true)
+	c.Check(result.Score < 0.5, qt.Equals, true)
+	c.Check(result.ChangedFraction, qt.Equals, 1.0/32)
+	c.Assert(result.Diff, qt.Not(qt.IsNil))
+	c.Check(result.Diff.Bounds(), qt.Equals, image.Rect(0, 0, 32, 32))
+}
+
+func TestFormatAndDimensionChange(t *testing.T) {
+	c := qt.New(t)
+	a := encodePNG(c, checkerboard(64, 32), png.DefaultCompression)
+	var buf bytes.Buffer
+	c.Assert(gif.Encode(&buf, checkerboard(32, 16), nil), qt.IsNil)
+
+	result, err := Compare(a, buf.Bytes())
+	c.Assert(err, qt.IsNil)
+	c.Check(result.A, qt.Equals, Info{Format: "png", Width: 64, Height: 32, Size: len(a)})
+	c.Check(result.B, qt.Equals, Info{Format: "gif", Width: 32, Height: 16, Size: buf.Len()})
+	c.Check(result.PixelIdentical, qt.Equals, false)
+	c.Check(result.Diff.Bounds(), qt.Equals, image.Rect(0, 0, 32, 16))
+}
+
+func TestIsImagePath(t *testing.T) {
+	c := qt.New(t)
+	c.Check(IsImagePath("img/photo.JPG"), qt.Equals, true)
+	c.Check(IsImagePath("img/photo_hu1234_resize.webp"), qt.Equals, true)
+	c.Check(IsImagePath("index.html"), qt.Equals, false)
+	c.Check(IsImagePath("logo.svg"), qt.Equals, false)
+}
@@ -1,6 +1,8 @@
 package out
 
 import (
+	"context"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -8,31 +10,81 @@ import (
 	"github.com/logrusorgru/aurora"
 )
 
-var loggerOut = log.New(os.Stderr, "", 0)
-var loggerDebug = log.New(ioutil.Discard, "", 0)
+// Logger is where messages for the user go, and debug messages if they're
+// turned on.
+type Logger struct {
+	out   *log.Logger
+	debug *log.Logger
+}
 
-func Reinit(debug bool) {
+// NewLogger returns a Logger that writes to w, with debug messages if debug is
+// set.
+func NewLogger(w io.Writer, debug bool) *Logger {
 	if debug {
-		loggerOut = log.New(os.Stderr, aurora.Magenta(" [USER] ").String(), log.LstdFlags)
-		loggerDebug = log.New(os.Stderr, "[DEBUG] ", log.LstdFlags)
-	} else {
-		loggerOut = log.New(os.Stderr, "", 0)
-		loggerDebug = log.New(ioutil.Discard, "", 0)
+		return &Logger{
+			out:   log.New(w, aurora.Magenta(" [USER] ").String(), log.LstdFlags),
+			debug: log.New(w, "[DEBUG] ", log.LstdFlags),
+		}
+	}
+	return &Logger{
+		out:   log.New(w, "", 0),
+		debug: log.New(ioutil.Discard, "", 0),
 	}
 }
 
+// The Logger for the command-line tool, which everything uses unless the
+// context says otherwise.
+var defaultLogger = NewLogger(os.Stderr, false)
+
+func Reinit(debug bool) {
+	defaultLogger = NewLogger(os.Stderr, debug)
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx which sends messages to l instead. It's for
+// when grouse is used as a library.
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the Logger that WithLogger put in ctx, or the one for
+// the command-line tool if there isn't one.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+	return defaultLogger
+}
+
+func (l *Logger) Outf(format string, args ...interface{}) {
+	l.out.Printf(format, args...)
+}
+
+func (l *Logger) Outln(args ...interface{}) {
+	l.out.Println(args...)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.debug.Printf(format, args...)
+}
+
+func (l *Logger) Debugln(args ...interface{}) {
+	l.debug.Println(args...)
+}
+
 func Outf(format string, args ...interface{}) {
-	loggerOut.Printf(format, args...)
+	defaultLogger.Outf(format, args...)
 }
 
 func Outln(args ...interface{}) {
-	loggerOut.Println(args...)
+	defaultLogger.Outln(args...)
 }
 
 func Debugf(format string, args ...interface{}) {
-	loggerDebug.Printf(format, args...)
+	defaultLogger.Debugf(format, args...)
 }
 
 func Debugln(args ...interface{}) {
-	loggerDebug.Println(args...)
+	defaultLogger.Debugln(args...)
 }
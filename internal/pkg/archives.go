@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/files"
+)
+
+// archiveFormat is a kind of archive that `grouse dirs` can read.
+type archiveFormat int
+
+const (
+	notAnArchive archiveFormat = iota
+	formatTar
+	formatTarGz
+	formatZip
+)
+
+func archiveFormatOf(filePath string) archiveFormat {
+	lower := strings.ToLower(filePath)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return formatTarGz
+	case strings.HasSuffix(lower, ".tar"):
+		return formatTar
+	case strings.HasSuffix(lower, ".zip"):
+		return formatZip
+	default:
+		return notAnArchive
+	}
+}
+
+// extractArchive extracts the archive at archivePath into dst, and returns
+// the directory inside dst that holds the archive's content; see
+// archiveContentRoot.
+func extractArchive(archivePath string, dst string) (string, error) {
+	var err error
+	switch archiveFormatOf(archivePath) {
+	case formatTar:
+		err = extractTarFile(archivePath, dst, false)
+	case formatTarGz:
+		err = extractTarFile(archivePath, dst, true)
+	case formatZip:
+		err = files.ExtractZip(archivePath, dst)
+	default:
+		err = fmt.Errorf("Don't know how to read %s; expected a directory, or a .tar, .tar.gz, .tgz or .zip archive", archivePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	return archiveContentRoot(dst)
+}
+
+// archiveContentRoot returns the directory inside dir, where an archive was
+// extracted, that holds the built site. That's dir itself, unless everything
+// in the archive is inside a single directory (e.g. an archive of public/
+// rather than its contents), in which case it's that directory. Directories
+// on disk are always compared as they are.
+func archiveContentRoot(dir string) (string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
+
+func extractTarFile(archivePath string, dst string, gzipped bool) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return files.ExtractTar(r, dst)
+}
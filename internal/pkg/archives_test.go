@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func writeTarGz(c *qt.C, archivePath string, files map[string]string) {
+	f, err := os.Create(archivePath)
+	c.Assert(err, qt.IsNil)
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		c.Assert(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}), qt.IsNil)
+		_, err := tw.Write([]byte(content))
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(tw.Close(), qt.IsNil)
+	c.Assert(gz.Close(), qt.IsNil)
+}
+
+func TestExtractArchiveUsesSingleTopLevelDirectory(t *testing.T) {
+	c := qt.New(t)
+	dir, err := ioutil.TempDir("", "grouse-archives-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "site.tar.gz")
+	writeTarGz(c, archive, map[string]string{
+		"public/index.html":      "home",
+		"public/posts/one.html":  "one",
+		"public/../../evil.html": "nope",
+	})
+	dst := filepath.Join(dir, "extracted")
+	c.Assert(os.Mkdir(dst, os.ModePerm), qt.IsNil)
+
+	contentDir, err := extractArchive(archive, dst)
+	c.Assert(err, qt.IsNil)
+	// evil.html gets extracted inside dst, so there are two top-level entries.
+	c.Check(contentDir, qt.Equals, dst)
+	_, err = os.Stat(filepath.Join(dst, "evil.html"))
+	c.Check(err, qt.IsNil)
+	_, err = os.Stat(filepath.Join(dir, "evil.html"))
+	c.Check(os.IsNotExist(err), qt.Equals, true)
+
+	c.Assert(os.RemoveAll(dst), qt.IsNil)
+	c.Assert(os.Mkdir(dst, os.ModePerm), qt.IsNil)
+	writeTarGz(c, archive, map[string]string{
+		"public/index.html":     "home",
+		"public/posts/one.html": "one",
+	})
+	contentDir, err = extractArchive(archive, dst)
+	c.Assert(err, qt.IsNil)
+	c.Check(contentDir, qt.Equals, filepath.Join(dst, "public"))
+	content, err := ioutil.ReadFile(filepath.Join(contentDir, "posts", "one.html"))
+	c.Assert(err, qt.IsNil)
+	c.Check(string(content), qt.Equals, "one")
+}
+
+func TestArchiveContentRoot(t *testing.T) {
+	c := qt.New(t)
+	dir, err := ioutil.TempDir("", "grouse-archives-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+
+	// An archive of public/ itself.
+	extracted := filepath.Join(dir, "extracted")
+	c.Assert(os.MkdirAll(filepath.Join(extracted, "public", "posts"), os.ModePerm), qt.IsNil)
+	root, err := archiveContentRoot(extracted)
+	c.Assert(err, qt.IsNil)
+	c.Check(root, qt.Equals, filepath.Join(extracted, "public"))
+
+	// An archive of the contents of public/.
+	extracted = filepath.Join(dir, "extracted-contents")
+	c.Assert(os.MkdirAll(filepath.Join(extracted, "posts"), os.ModePerm), qt.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(extracted, "index.html"), []byte("home"), 0644), qt.IsNil)
+	root, err = archiveContentRoot(extracted)
+	c.Assert(err, qt.IsNil)
+	c.Check(root, qt.Equals, extracted)
+}
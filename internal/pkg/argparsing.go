@@ -3,6 +3,7 @@ package pkg
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/kballard/go-shellquote"
 	"github.com/pkg/errors"
@@ -11,11 +12,16 @@ import (
 // Internal interface used for testing
 type flagSet interface {
 	GetBool(string) (bool, error)
+	GetInt(string) (int, error)
 	GetString(string) (string, error)
+	GetStringSlice(string) ([]string, error)
+	GetStringArray(string) ([]string, error)
 	Args() []string
 }
 
-func parseArgs(flags flagSet) (*cmdArgs, error) {
+// parseOutputArgs parses the flags that control how grouse shows the
+// differences between builds, which all the comparison commands share.
+func parseOutputArgs(flags flagSet) (*cmdArgs, error) {
 	var diffCommand string
 
 	// Error handling in this section: parsing handles validation for all the
@@ -45,11 +51,142 @@ func parseArgs(flags flagSet) (*cmdArgs, error) {
 	if err != nil {
 		return nil, errors.WithMessage(err, "Couldn't parse the value provided to --diffargs")
 	}
+
+	imageReportDir, err := flags.GetString("image-report")
+	check(err)
+
+	exportA, err := flags.GetString("export-a")
+	check(err)
+	exportB, err := flags.GetString("export-b")
+	check(err)
+	for _, export := range []string{exportA, exportB} {
+		if export != "" && archiveFormatOf(export) == notAnArchive {
+			return nil, fmt.Errorf("Don't know what kind of archive to write to %s; use a .tar, .tar.gz, .tgz or .zip extension", export)
+		}
+	}
+	exportPatch, err := flags.GetString("export-patch")
+	check(err)
+
+	eventsFormat, err := flags.GetString("events")
+	check(err)
+	if eventsFormat != "" && eventsFormat != "jsonl" {
+		return nil, fmt.Errorf("Unknown event format '%s'; the only supported format is jsonl", eventsFormat)
+	}
+	eventsTo, err := flags.GetString("events-to")
+	check(err)
+	// Events go nowhere by default, because stderr and stdout already have
+	// grouse's and hugo's output on them.
+	if eventsFormat != "" && eventsTo == "" {
+		return nil, errors.New("--events needs --events-to to say where the events should go")
+	}
+	if eventsTo != "" && eventsFormat == "" {
+		return nil, errors.New("--events-to only makes sense together with --events")
+	}
+
+	filterSpecs, err := flags.GetStringArray("filter")
+	check(err)
+	filters := []OutputFilter{}
+	for _, spec := range filterSpecs {
+		filter, err := parseOutputFilter(spec)
+		if err != nil {
+			return nil, errors.WithMessage(err, "Couldn't parse the value provided to --filter")
+		}
+		filters = append(filters, filter)
+	}
+
+	ignoreRulesPath, err := flags.GetString("ignore-rules")
+	check(err)
+	var rules *ignoreRules
+	if ignoreRulesPath != "" {
+		rules, err = readIgnoreRules(ignoreRulesPath)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "Couldn't read the rules in %s", ignoreRulesPath)
+		}
+	}
+
+	noTaxonomyDiff, err := flags.GetBool("no-taxonomy-diff")
+	check(err)
+	noLanguageBreakdown, err := flags.GetBool("no-language-breakdown")
+	check(err)
+	noFormatBreakdown, err := flags.GetBool("no-format-breakdown")
+	check(err)
+	formats, err := flags.GetStringSlice("formats")
+	check(err)
+
+	formatStr, err := flags.GetString("format")
+	check(err)
+	format, err := parseReportFormat(formatStr)
+	if err != nil {
+		return nil, err
+	}
+	reportTo, err := flags.GetString("report-to")
+	check(err)
+	if reportTo != "" && format == reportText {
+		return nil, errors.New("--report-to only makes sense together with --format")
+	}
+	budgetSpecs, err := flags.GetStringArray("size-budget")
+	check(err)
+	budgets := []sizeBudget{}
+	for _, spec := range budgetSpecs {
+		budget, err := parseSizeBudget(spec)
+		if err != nil {
+			return nil, errors.WithMessage(err, "Couldn't parse the value provided to --size-budget")
+		}
+		budgets = append(budgets, budget)
+	}
+
+	return &cmdArgs{
+		diffCommand:       diffCommand,
+		noPager:           noPager,
+		diffArgs:          diffArgs,
+		debug:             debug,
+		keepWorktree:      keepWorktree,
+		imageReportDir:    imageReportDir,
+		exportA:           exportA,
+		exportB:           exportB,
+		exportPatch:       exportPatch,
+		eventsFormat:      eventsFormat,
+		eventsTo:          eventsTo,
+		filters:           filters,
+		ignoreRules:       rules,
+		taxonomyDiff:      !noTaxonomyDiff,
+		languageBreakdown: !noLanguageBreakdown,
+		formatBreakdown:   !noFormatBreakdown,
+		formats:           formats,
+		reportFormat:      format,
+		reportTo:          reportTo,
+		sizeBudgets:       budgets,
+	}, nil
+}
+
+// parseBuildArgs parses the flags that control how each revision gets built,
+// which all the commands that build things share, into args.
+func parseBuildArgs(flags flagSet, args *cmdArgs) error {
 	buildArgsStr, err := flags.GetString("buildargs")
 	check(err)
 	buildArgs, err := shellquote.Split(buildArgsStr)
 	if err != nil {
-		return nil, errors.WithMessage(err, "Couldn't parse the value provided to --buildargs")
+		return errors.WithMessage(err, "Couldn't parse the value provided to --buildargs")
+	}
+
+	preBuildHook, err := flags.GetString("pre-build")
+	check(err)
+	postBuildHook, err := flags.GetString("post-build")
+	check(err)
+
+	sourceModeStr, err := flags.GetString("source-mode")
+	check(err)
+	sourceMode, err := parseSourceMode(sourceModeStr)
+	if err != nil {
+		return err
+	}
+
+	sparse, err := flags.GetBool("sparse")
+	check(err)
+	sparseExtraPaths, err := flags.GetStringSlice("sparse-paths")
+	check(err)
+	if len(sparseExtraPaths) > 0 && !sparse {
+		return errors.New("--sparse-paths only makes sense together with --sparse")
 	}
 
 	repoDir, err := os.Getwd()
@@ -58,26 +195,192 @@ func parseArgs(flags flagSet) (*cmdArgs, error) {
 	// directory?
 	check(err)
 
+	args.repoDir = repoDir
+	args.buildArgs = buildArgs
+	args.sourceMode = sourceMode
+	args.sparse = sparse
+	args.sparseExtraPaths = sparseExtraPaths
+	args.preBuildHook = preBuildHook
+	args.postBuildHook = postBuildHook
+	return nil
+}
+
+func parseArgs(flags flagSet) (*cmdArgs, error) {
+	args, err := parseOutputArgs(flags)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseBuildArgs(flags, args); err != nil {
+		return nil, err
+	}
+
+	againstDir, err := flags.GetString("against-dir")
+	check(err)
+	if againstDir != "" {
+		info, err := os.Stat(againstDir)
+		if err != nil {
+			return nil, errors.WithMessage(err, "Couldn't read the directory provided to --against-dir")
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("--against-dir needs a directory, but %s isn't one", againstDir)
+		}
+		againstDir, err = filepath.Abs(againstDir)
+		check(err)
+	}
+
 	commits := flags.Args()
-	switch len(commits) {
+	if againstDir != "" {
+		// Every commit gets compared to the directory.
+		if len(commits) == 0 {
+			commits = []string{"HEAD"}
+		}
+	} else {
+		switch len(commits) {
+		case 1:
+			commits = append(commits, "HEAD")
+		case 0:
+			return nil, errors.New("Requires at least one git reference to diff")
+		default:
+			// The first is the base, and everything else gets compared to it.
+		}
+	}
+
+	noConfigDiff, err := flags.GetBool("no-config-diff")
+	check(err)
+	noContentDiff, err := flags.GetBool("no-content-diff")
+	check(err)
+	noAttribution, err := flags.GetBool("no-attribution")
+	check(err)
+	sourceDiff, err := flags.GetBool("source-diff")
+	check(err)
+	if sourceDiff && againstDir != "" {
+		return nil, errors.New("--source-diff doesn't work with --against-dir, because the directory doesn't have any source to diff")
+	}
+
+	severalRevisions := len(commits) > 2 || (againstDir != "" && len(commits) > 1)
+	if severalRevisions && (args.exportB != "" || args.exportPatch != "") {
+		return nil, errors.New("--export-b and --export-patch only work when comparing two revisions")
+	}
+
+	args.commits = commits
+	args.againstDir = againstDir
+	args.configDiff = !noConfigDiff
+	args.contentDiff = !noContentDiff
+	args.attribution = !noAttribution
+	args.sourceDiff = sourceDiff
+	return args, nil
+}
+
+// parseDirsArgs parses the arguments for `grouse dirs`.
+func parseDirsArgs(flags flagSet) (*cmdArgs, error) {
+	args, err := parseOutputArgs(flags)
+	if err != nil {
+		return nil, err
+	}
+	sides := flags.Args()
+	if len(sides) != 2 {
+		return nil, fmt.Errorf("Requires two directories or archives to compare, got %v", len(sides))
+	}
+	for i, side := range sides {
+		info, err := os.Stat(side)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "Couldn't read %s", side)
+		}
+		if !info.IsDir() && archiveFormatOf(side) == notAnArchive {
+			return nil, fmt.Errorf("Don't know how to read %s; expected a directory, or a .tar, .tar.gz, .tgz or .zip archive", side)
+		}
+		sides[i], err = filepath.Abs(side)
+		check(err)
+	}
+	args.sides = sides
+	return args, nil
+}
+
+// parseCheckDeterminismArgs parses the arguments for
+// `grouse check-determinism`.
+func parseCheckDeterminismArgs(flags flagSet) (*cmdArgs, error) {
+	args, err := parseOutputArgs(flags)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseBuildArgs(flags, args); err != nil {
+		return nil, err
+	}
+
+	refs := flags.Args()
+	switch len(refs) {
+	case 0:
+		refs = []string{"HEAD"}
 	case 1:
-		commits = append(commits, "HEAD")
-	case 2:
-		// no-op
 	default:
-		return nil, fmt.Errorf("Requires one or two git references to diff, got %v", len(commits))
+		return nil, fmt.Errorf("Checks one git reference at a time, got %v", len(refs))
 	}
 
-	return &cmdArgs{
-		repoDir:      repoDir,
-		diffCommand:  diffCommand,
-		commits:      commits,
-		noPager:      noPager,
-		diffArgs:     diffArgs,
-		buildArgs:    buildArgs,
-		debug:        debug,
-		keepWorktree: keepWorktree,
-	}, nil
+	builds, err := flags.GetInt("builds")
+	check(err)
+	if builds < 2 {
+		return nil, fmt.Errorf("--builds needs to be at least 2, got %d", builds)
+	}
+	if builds > 2 && (args.exportB != "" || args.exportPatch != "") {
+		return nil, errors.New("--export-b and --export-patch only work with --builds=2")
+	}
+
+	writeRules, err := flags.GetString("write-rules")
+	check(err)
+
+	args.commits = refs
+	args.builds = builds
+	args.writeRules = writeRules
+	return args, nil
+}
+
+// parseWatchArgs parses the arguments for `grouse watch`.
+func parseWatchArgs(flags flagSet) (*cmdArgs, error) {
+	args, err := parseOutputArgs(flags)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseBuildArgs(flags, args); err != nil {
+		return nil, err
+	}
+
+	refs := flags.Args()
+	switch len(refs) {
+	case 0:
+		refs = []string{"HEAD"}
+	case 1:
+	default:
+		return nil, fmt.Errorf("Watches against one git reference at a time, got %v", len(refs))
+	}
+
+	// There's no diff to show or export, only the summary or report.
+	for _, unsupported := range []struct {
+		flag string
+		set  bool
+	}{
+		{"--tool", args.diffCommand == "difftool"},
+		{"--image-report", args.imageReportDir != ""},
+		{"--export-a", args.exportA != ""},
+		{"--export-b", args.exportB != ""},
+		{"--export-patch", args.exportPatch != ""},
+	} {
+		if unsupported.set {
+			return nil, fmt.Errorf("%s doesn't work with watch", unsupported.flag)
+		}
+	}
+
+	noConfigDiff, err := flags.GetBool("no-config-diff")
+	check(err)
+	noContentDiff, err := flags.GetBool("no-content-diff")
+	check(err)
+	noAttribution, err := flags.GetBool("no-attribution")
+	check(err)
+
+	args.commits = refs
+	args.configDiff = !noConfigDiff
+	args.contentDiff = !noContentDiff
+	args.attribution = !noAttribution
+	return args, nil
 }
 
 type cmdArgs struct {
@@ -90,4 +393,79 @@ type cmdArgs struct {
 	debug       bool
 	// TODO: rename
 	keepWorktree bool
+	// If set, compare changed images and write visual diffs to this
+	// directory.
+	imageReportDir string
+	sourceMode     sourceMode
+	// Only check out the Hugo root and sparseExtraPaths (relative to the
+	// root of the repo).
+	sparse           bool
+	sparseExtraPaths []string
+	// If set, this directory is the base that all the commits get compared
+	// to, instead of the first commit.
+	againstDir string
+	// For `grouse dirs`, the two directories or archives to compare.
+	sides []string
+	// If set, save the output of the base revision / the other revision to
+	// these archives, and the diff between them to exportPatch.
+	exportA     string
+	exportB     string
+	exportPatch string
+	// If set, emit progress events in this format to eventsTo; see
+	// openEventsDestination.
+	eventsFormat string
+	eventsTo     string
+	// Run over every output tree before it gets diffed.
+	filters []OutputFilter
+	// Whether to compare Hugo's configuration / the content that `hugo list`
+	// finds for each revision.
+	configDiff  bool
+	contentDiff bool
+	// Whether to work out which source changes caused which output changes.
+	attribution bool
+	// Whether to show the diff of the source before the diff of the output.
+	sourceDiff bool
+	// Whether to compare the taxonomy terms in the outputs, and to break
+	// down the changes by language for multilingual sites.
+	taxonomyDiff      bool
+	languageBreakdown bool
+	// Whether to break down the changes by output format, and which output
+	// formats to diff; all of them if it's empty.
+	formatBreakdown bool
+	formats         []string
+	// How to show the differences, and where to write them for formats
+	// other than reportText; stdout if it's empty.
+	reportFormat reportFormat
+	reportTo     string
+	// For the size-budget check in junit and sarif reports.
+	sizeBudgets []sizeBudget
+	// For `grouse check-determinism`, how many times to build the commit,
+	// and where to write rules that hide the differences between the builds.
+	builds     int
+	writeRules string
+	// Differences to hide, from --ignore-rules.
+	ignoreRules *ignoreRules
+	// Shell commands to run before / after each build.
+	preBuildHook  string
+	postBuildHook string
+}
+
+func (a cmdArgs) buildOptions() BuildOptions {
+	return BuildOptions{
+		RepoDir:        a.repoDir,
+		Commits:        a.commits,
+		BuildArgs:      a.buildArgs,
+		SourceMode:     string(a.sourceMode),
+		Sparse:         a.sparse,
+		SparsePaths:    a.sparseExtraPaths,
+		AgainstDir:     a.againstDir,
+		PreBuildHook:   a.preBuildHook,
+		PostBuildHook:  a.postBuildHook,
+		Filters:        a.filters,
+		IgnoreRules:    a.ignoreRules,
+		ReadConfig:     a.configDiff,
+		ReadContent:    a.contentDiff,
+		SourceChanges:  a.attribution,
+		KeepScratchDir: a.keepWorktree,
+	}
 }
@@ -1,7 +1,7 @@
 package pkg
 
 import (
-	"fmt"
+	"path/filepath"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -13,23 +13,62 @@ func (f flags) GetBool(key string) (bool, error) {
 	return f[key].(bool), nil
 }
 
+func (f flags) GetInt(key string) (int, error) {
+	return f[key].(int), nil
+}
+
 func (f flags) GetString(key string) (string, error) {
 	return f[key].(string), nil
 }
 
+func (f flags) GetStringSlice(key string) ([]string, error) {
+	return f[key].([]string), nil
+}
+
+func (f flags) GetStringArray(key string) ([]string, error) {
+	return f[key].([]string), nil
+}
+
 func (f flags) Args() []string {
 	return f["_args"].([]string)
 }
 
 func defaultFlags() flags {
 	return flags{
-		"no-pager":   false,
-		"diffargs":   "--potato 'excellent'",
-		"buildargs":  "--carrot",
-		"tool":       true,
-		"_args":      []string{"b1234553", "HEAD^"},
-		"keep-cache": false,
-		"debug":      false,
+		"no-pager":              false,
+		"diffargs":              "--potato 'excellent'",
+		"buildargs":             "--carrot",
+		"tool":                  true,
+		"_args":                 []string{"b1234553", "HEAD^"},
+		"keep-cache":            false,
+		"debug":                 false,
+		"against-dir":           "",
+		"image-report":          "",
+		"export-a":              "",
+		"export-b":              "",
+		"export-patch":          "",
+		"events":                "",
+		"pre-build":             "",
+		"post-build":            "",
+		"events-to":             "",
+		"filter":                []string{},
+		"ignore-rules":          "",
+		"no-taxonomy-diff":      false,
+		"no-language-breakdown": false,
+		"no-format-breakdown":   false,
+		"formats":               []string{},
+		"format":                "text",
+		"report-to":             "",
+		"size-budget":           []string{},
+		"builds":                2,
+		"no-config-diff":        false,
+		"no-content-diff":       false,
+		"no-attribution":        false,
+		"source-diff":           false,
+		"write-rules":           "",
+		"source-mode":           "auto",
+		"sparse":                false,
+		"sparse-paths":          []string{},
 	}
 }
 
@@ -82,16 +121,212 @@ func TestHandlesOneCommit(t *testing.T) {
 	c.Check(context.commits, qt.DeepEquals, []string{"b1234553", "HEAD"})
 }
 
-func TestHandlesWrongNumberOfCommits(t *testing.T) {
+func TestHandlesNoCommits(t *testing.T) {
 	c := qt.New(t)
-	for _, testcase := range [][]string{{}, {"b1234553", "HEAD^^", "HEAD"}} {
-		subtest := fmt.Sprintf("With%dArgs", len(testcase))
-		c.Run(subtest, func(c *qt.C) {
-			f := defaultFlags()
-			f["_args"] = testcase
-			context, err := parseArgs(f)
-			c.Check(context, qt.IsNil)
-			c.Check(err, qt.ErrorMatches, `Requires one or two git references.*got \d`)
-		})
-	}
+	f := defaultFlags()
+	f["_args"] = []string{}
+	context, err := parseArgs(f)
+	c.Check(context, qt.IsNil)
+	c.Check(err, qt.ErrorMatches, `Requires at least one git reference.*`)
+}
+
+func TestArgParsingSeveralRevisions(t *testing.T) {
+	c := qt.New(t)
+	f := defaultFlags()
+	f["_args"] = []string{"main", "feat-a", "feat-b", "feat-c"}
+	context, err := parseArgs(f)
+	c.Check(err, qt.IsNil)
+	c.Check(context.commits, qt.DeepEquals, []string{"main", "feat-a", "feat-b", "feat-c"})
+}
+
+func TestArgParsingSourceMode(t *testing.T) {
+	c := qt.New(t)
+	f := defaultFlags()
+	f["source-mode"] = "export"
+	context, err := parseArgs(f)
+	c.Check(err, qt.IsNil)
+	c.Check(context.sourceMode, qt.Equals, sourceModeExport)
+
+	f["source-mode"] = "potato"
+	context, err = parseArgs(f)
+	c.Check(context, qt.IsNil)
+	c.Check(err, qt.ErrorMatches, `Unknown source mode 'potato'.*`)
+}
+
+func TestArgParsingReportFormat(t *testing.T) {
+	c := qt.New(t)
+	f := defaultFlags()
+	f["report-to"] = "summary.md"
+	context, err := parseArgs(f)
+	c.Check(context, qt.IsNil)
+	c.Check(err, qt.ErrorMatches, `--report-to only makes sense together with --format`)
+
+	f["format"] = "markdown"
+	context, err = parseArgs(f)
+	c.Assert(err, qt.IsNil)
+	c.Check(context.reportFormat, qt.Equals, reportMarkdown)
+	c.Check(context.reportTo, qt.Equals, "summary.md")
+
+	f["format"] = "sarif"
+	context, err = parseArgs(f)
+	c.Assert(err, qt.IsNil)
+	c.Check(context.reportFormat, qt.Equals, reportSARIF)
+
+	f["format"] = "html"
+	_, err = parseArgs(f)
+	c.Check(err, qt.ErrorMatches, `Unknown format 'html'.*`)
+}
+
+func TestArgParsingSizeBudget(t *testing.T) {
+	c := qt.New(t)
+	f := defaultFlags()
+	f["size-budget"] = []string{"1MB", "*.js: 100KB"}
+	context, err := parseArgs(f)
+	c.Assert(err, qt.IsNil)
+	c.Assert(context.sizeBudgets, qt.HasLen, 2)
+	c.Check(context.sizeBudgets[0], qt.Equals, sizeBudget{limit: 1024 * 1024})
+	c.Check(context.sizeBudgets[1], qt.Equals, sizeBudget{glob: "*.js", limit: 100 * 1024})
+
+	f["size-budget"] = []string{"lots"}
+	context, err = parseArgs(f)
+	c.Check(context, qt.IsNil)
+	c.Check(err, qt.ErrorMatches, `Couldn't parse the value provided to --size-budget: .*`)
+}
+
+func TestArgParsingEventsRequireDestination(t *testing.T) {
+	c := qt.New(t)
+	f := defaultFlags()
+	f["events"] = "jsonl"
+	context, err := parseArgs(f)
+	c.Check(context, qt.IsNil)
+	c.Check(err, qt.ErrorMatches, `--events needs --events-to.*`)
+
+	f["events-to"] = "fd:3"
+	context, err = parseArgs(f)
+	c.Assert(err, qt.IsNil)
+	c.Check(context.eventsFormat, qt.Equals, "jsonl")
+	c.Check(context.eventsTo, qt.Equals, "fd:3")
+
+	f["events"] = ""
+	_, err = parseArgs(f)
+	c.Check(err, qt.ErrorMatches, `--events-to only makes sense together with --events`)
+}
+
+func TestArgParsingSparsePathsRequireSparse(t *testing.T) {
+	c := qt.New(t)
+	f := defaultFlags()
+	f["sparse-paths"] = []string{"assets"}
+	context, err := parseArgs(f)
+	c.Check(context, qt.IsNil)
+	c.Check(err, qt.ErrorMatches, `--sparse-paths only makes sense together with --sparse`)
+
+	f["sparse"] = true
+	context, err = parseArgs(f)
+	c.Check(err, qt.IsNil)
+	c.Check(context.sparseExtraPaths, qt.DeepEquals, []string{"assets"})
+}
+
+func TestArgParsingAgainstDir(t *testing.T) {
+	c := qt.New(t)
+	f := defaultFlags()
+	f["against-dir"] = "does/not/exist"
+	context, err := parseArgs(f)
+	c.Check(context, qt.IsNil)
+	c.Check(err, qt.ErrorMatches, `Couldn't read the directory provided to --against-dir.*`)
+
+	f["against-dir"] = "."
+	f["_args"] = []string{}
+	context, err = parseArgs(f)
+	c.Assert(err, qt.IsNil)
+	c.Check(filepath.IsAbs(context.againstDir), qt.Equals, true)
+	// Without any commits, the directory gets compared to HEAD, rather than
+	// HEAD being compared to itself.
+	c.Check(context.commits, qt.DeepEquals, []string{"HEAD"})
+}
+
+func TestDirsArgParsing(t *testing.T) {
+	c := qt.New(t)
+	f := defaultFlags()
+	f["_args"] = []string{".", ".."}
+	context, err := parseDirsArgs(f)
+	c.Assert(err, qt.IsNil)
+	c.Check(context.sides, qt.HasLen, 2)
+	c.Check(filepath.IsAbs(context.sides[1]), qt.Equals, true)
+	c.Check(context.diffCommand, qt.Equals, "difftool")
+
+	f["_args"] = []string{"."}
+	_, err = parseDirsArgs(f)
+	c.Check(err, qt.ErrorMatches, `Requires two directories or archives to compare, got 1`)
+
+	f["_args"] = []string{".", "argparsing.go"}
+	_, err = parseDirsArgs(f)
+	c.Check(err, qt.ErrorMatches, `Don't know how to read .*argparsing.go.*`)
+}
+
+func TestArgParsingExports(t *testing.T) {
+	c := qt.New(t)
+	f := defaultFlags()
+	f["export-a"] = "a.tar.gz"
+	f["export-b"] = "b.rar"
+	context, err := parseArgs(f)
+	c.Check(context, qt.IsNil)
+	c.Check(err, qt.ErrorMatches, `Don't know what kind of archive to write to b.rar.*`)
+
+	f["export-b"] = "b.zip"
+	f["export-patch"] = "diff.patch"
+	context, err = parseArgs(f)
+	c.Assert(err, qt.IsNil)
+	c.Check(context.exportA, qt.Equals, "a.tar.gz")
+	c.Check(context.exportPatch, qt.Equals, "diff.patch")
+
+	// With several revisions, it's not clear which one is B.
+	f["_args"] = []string{"main", "feat-a", "feat-b"}
+	context, err = parseArgs(f)
+	c.Check(context, qt.IsNil)
+	c.Check(err, qt.ErrorMatches, `--export-b and --export-patch only work when comparing two revisions`)
+}
+
+func TestCheckDeterminismArgParsing(t *testing.T) {
+	c := qt.New(t)
+	f := defaultFlags()
+	f["_args"] = []string{}
+	args, err := parseCheckDeterminismArgs(f)
+	c.Assert(err, qt.IsNil)
+	c.Check(args.commits, qt.DeepEquals, []string{"HEAD"})
+	c.Check(args.builds, qt.Equals, 2)
+	c.Check(args.buildArgs, qt.DeepEquals, []string{"--carrot"})
+
+	f["_args"] = []string{"main", "HEAD"}
+	_, err = parseCheckDeterminismArgs(f)
+	c.Check(err, qt.ErrorMatches, "Checks one git reference at a time.*")
+
+	f["_args"] = []string{"main"}
+	f["builds"] = 1
+	_, err = parseCheckDeterminismArgs(f)
+	c.Check(err, qt.ErrorMatches, "--builds needs to be at least 2.*")
+
+	f["builds"] = 3
+	f["export-patch"] = "changes.patch"
+	_, err = parseCheckDeterminismArgs(f)
+	c.Check(err, qt.ErrorMatches, "--export-b and --export-patch only work with --builds=2")
+}
+
+func TestWatchArgParsing(t *testing.T) {
+	c := qt.New(t)
+	f := defaultFlags()
+	f["tool"] = false
+	f["_args"] = []string{}
+	args, err := parseWatchArgs(f)
+	c.Assert(err, qt.IsNil)
+	c.Check(args.commits, qt.DeepEquals, []string{"HEAD"})
+	c.Check(args.attribution, qt.Equals, true)
+
+	f["_args"] = []string{"main", "HEAD"}
+	_, err = parseWatchArgs(f)
+	c.Check(err, qt.ErrorMatches, "Watches against one git reference at a time.*")
+
+	f["_args"] = []string{"main"}
+	f["export-patch"] = "changes.patch"
+	_, err = parseWatchArgs(f)
+	c.Check(err, qt.ErrorMatches, "--export-patch doesn't work with watch")
 }
@@ -0,0 +1,415 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+)
+
+// sourceChangesBetween lists the source files that changed between two
+// commits of repo, relative to siteDir (the Hugo site's directory, relative to
+// the root of the repo). Changed submodules get expanded into the files that
+// changed in them, if they're checked out in repo; otherwise they're listed as
+// a whole.
+func sourceChangesBetween(ctx context.Context, git_ git.Git, repo git.Repository, siteDir string, from, to git.Hash) ([]git.FileChange, error) {
+	changes, err := repo.ChangedFiles(from, to)
+	if err != nil {
+		return nil, err
+	}
+	fromSubmodules, err := repo.Submodules(from)
+	if err != nil {
+		return nil, err
+	}
+	toSubmodules, err := repo.Submodules(to)
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := []git.FileChange{}
+	for _, change := range changes {
+		fromCommit, inFrom := fromSubmodules[change.Path]
+		toCommit, inTo := toSubmodules[change.Path]
+		if inFrom && inTo {
+			if submoduleChanges, ok := changesInSubmodule(ctx, git_, repo, change.Path, fromCommit, toCommit); ok {
+				expanded = append(expanded, submoduleChanges...)
+				continue
+			}
+		}
+		expanded = append(expanded, change)
+	}
+	return changesInSite(expanded, siteDir), nil
+}
+
+// changesInSite returns the changes to files in siteDir, relative to it.
+func changesInSite(changes []git.FileChange, siteDir string) []git.FileChange {
+	// It's "web/" rather than "web", coming from `git rev-parse --show-prefix`.
+	siteDir = strings.TrimSuffix(siteDir, "/")
+	if siteDir == "" {
+		return changes
+	}
+	inSite := []git.FileChange{}
+	for _, change := range changes {
+		if strings.HasPrefix(change.Path, siteDir+"/") {
+			inSite = append(inSite, git.FileChange{Status: change.Status, Path: strings.TrimPrefix(change.Path, siteDir+"/")})
+		}
+	}
+	return inSite
+}
+
+// changesInSubmodule lists the files that changed in the submodule at
+// submodulePath between two of its commits, or returns false if that isn't
+// possible, e.g. because it isn't checked out.
+func changesInSubmodule(ctx context.Context, git_ git.Git, repo git.Repository, submodulePath string, from, to git.Hash) ([]git.FileChange, bool) {
+	dir := filepath.Join(repo.RootDir(), filepath.FromSlash(submodulePath))
+	submodule, err := git_.OpenRepository(dir)
+	// If the submodule isn't initialized, its directory is just part of the
+	// parent repo.
+	if err != nil || filepath.Clean(submodule.RootDir()) != filepath.Clean(dir) {
+		return nil, false
+	}
+	changes, err := submodule.ChangedFiles(from, to)
+	if err != nil {
+		out.FromContext(ctx).Debugf("Couldn't diff the submodule at %s: %v\n", submodulePath, err)
+		return nil, false
+	}
+	for i := range changes {
+		changes[i].Path = submodulePath + "/" + changes[i].Path
+	}
+	return changes, true
+}
+
+// Kinds of page, as Hugo calls them.
+const (
+	kindHome     = "home"
+	kindPage     = "page"
+	kindSection  = "section"
+	kindTaxonomy = "taxonomy"
+	kindTerm     = "term"
+)
+
+// outputPage is what's known about a page in the output, for working out which
+// sources it depends on.
+type outputPage struct {
+	path     string
+	kind     string
+	section  string
+	language string
+}
+
+// attributionSite is what's known about a revision's site as a whole.
+type attributionSite struct {
+	languages  []siteLanguage
+	taxonomies []string
+	// The content file for each page, by its path in the output.
+	contentFiles map[string]string
+}
+
+// newAttributionSite collects what's known about the site in revision. The
+// content of base is included too, for pages which got deleted.
+func newAttributionSite(base, revision BuiltRevision, languages []siteLanguage) attributionSite {
+	site := attributionSite{
+		languages:    languages,
+		taxonomies:   taxonomiesOf(revision.Config),
+		contentFiles: map[string]string{},
+	}
+	var baseURL string
+	json.Unmarshal([]byte(revision.Config["baseurl"]), &baseURL)
+	basePath := ""
+	if u, err := url.Parse(baseURL); err == nil {
+		basePath = strings.Trim(u.Path, "/")
+	}
+	for _, content := range []contentInventory{base.Content, revision.Content} {
+		for contentFile, entry := range content {
+			if page := outputPathOf(entry.permalink, basePath); page != "" {
+				site.contentFiles[page] = contentFile
+			}
+		}
+	}
+	return site
+}
+
+// outputPathOf returns the path in the output of the page with the given
+// permalink, or "" if it can't be worked out. basePath is the path of the
+// site's baseURL, which isn't part of the output.
+func outputPathOf(permalink string, basePath string) string {
+	u, err := url.Parse(permalink)
+	if err != nil || u.Path == "" {
+		return ""
+	}
+	p := strings.TrimPrefix(strings.TrimPrefix(u.Path, "/"), basePath)
+	p = strings.TrimPrefix(p, "/")
+	if strings.HasSuffix(p, ".html") {
+		// uglyURLs
+		return p
+	}
+	return path.Join(p, "index.html")
+}
+
+// guessOutputPath returns where the content file at contentFile probably ends
+// up in the output, for when `hugo list` wasn't run.
+func guessOutputPath(contentFile string) string {
+	p := strings.TrimPrefix(contentFile, "content/")
+	p = strings.TrimSuffix(p, path.Ext(p))
+	if base := path.Base(p); base == "index" || base == "_index" {
+		p = path.Dir(p)
+	}
+	return path.Join(p, "index.html")
+}
+
+func (s attributionSite) pageAt(relPath string) outputPage {
+	page := outputPage{path: relPath, language: languageOf(s.languages, relPath)}
+	rest := relPath
+	for _, language := range s.languages {
+		if language.dir != "" && language.code == page.language {
+			rest = strings.TrimPrefix(relPath, language.dir+"/")
+		}
+	}
+	dirs := strings.Split(path.Dir(rest), "/")
+	if dirs[0] == "." {
+		page.kind = kindHome
+		return page
+	}
+	page.section = dirs[0]
+	for _, taxonomy := range s.taxonomies {
+		if dirs[0] == taxonomy {
+			page.kind = kindTerm
+			if len(dirs) == 1 {
+				page.kind = kindTaxonomy
+			}
+			return page
+		}
+	}
+	contentFile := s.contentFiles[relPath]
+	if strings.HasPrefix(path.Base(contentFile), "_index.") || (contentFile == "" && len(dirs) == 1) {
+		page.kind = kindSection
+	} else {
+		page.kind = kindPage
+	}
+	return page
+}
+
+// layoutKinds is which kinds of page each of the standard layout names in
+// layouts/_default/ (or a section's directory) gets used for.
+var layoutKinds = map[string][]string{
+	"single":   {kindPage},
+	"list":     {kindHome, kindSection, kindTaxonomy, kindTerm},
+	"home":     {kindHome},
+	"index":    {kindHome},
+	"section":  {kindSection},
+	"taxonomy": {kindTaxonomy, kindTerm},
+	"terms":    {kindTaxonomy},
+	"term":     {kindTerm},
+}
+
+// affects reports whether the changed source file at source (relative to the
+// Hugo site) is likely to affect page. contentPages is the output path of
+// each changed content file.
+func (s attributionSite) affects(source string, page outputPage, contentPages map[string]string) bool {
+	if strings.HasPrefix(source, "content/") {
+		return contentPages[source] == page.path
+	}
+	// Hugo's own config, and everything from modules or themes, work the
+	// same as the site's.
+	rest := source
+	if strings.HasPrefix(rest, "themes/") {
+		parts := strings.SplitN(rest, "/", 3)
+		if len(parts) < 3 {
+			// A theme which is a submodule, which couldn't be looked into.
+			return true
+		}
+		rest = parts[2]
+	}
+	parts := strings.Split(rest, "/")
+	switch parts[0] {
+	case "data", "assets":
+		return true
+	case "config":
+		return len(parts) > 1
+	case "i18n":
+		language := strings.SplitN(parts[len(parts)-1], ".", 2)[0]
+		return s.languages == nil || strings.EqualFold(language, page.language)
+	case "layouts":
+		return layoutAffects(parts[1:], page)
+	}
+	if len(parts) == 1 {
+		name := strings.SplitN(parts[0], ".", 2)[0]
+		return name == "config" || name == "hugo"
+	}
+	return false
+}
+
+// layoutAffects reports whether the layout at parts (relative to layouts/)
+// is likely to get used for page.
+func layoutAffects(parts []string, page outputPage) bool {
+	name := strings.SplitN(parts[len(parts)-1], ".", 2)[0]
+	if len(parts) == 1 {
+		// e.g. layouts/index.html, layouts/404.html, layouts/robots.txt.
+		return (name == "index" || name == "home") && page.kind == kindHome
+	}
+	switch parts[0] {
+	case "partials", "shortcodes", "_markup":
+		// Could be used anywhere.
+		return true
+	case "_default":
+	case "taxonomy":
+		// Old-style layouts/taxonomy/<singular>.html etc.
+		if page.kind != kindTaxonomy && page.kind != kindTerm {
+			return false
+		}
+	default:
+		if parts[0] != page.section {
+			return false
+		}
+	}
+	if name == "baseof" {
+		return true
+	}
+	kinds, ok := layoutKinds[name]
+	if !ok {
+		// A custom layout, e.g. for .Render or `layout` in front matter.
+		return true
+	}
+	for _, kind := range kinds {
+		if kind == page.kind {
+			return true
+		}
+	}
+	return false
+}
+
+// pageAttribution is a group of changed pages, and the changed sources that
+// probably caused them to change.
+type pageAttribution struct {
+	pages   []string
+	sources []string
+}
+
+// attributeChanges works out which of sourceChanges probably caused each of
+// the changed pages in outputChanges. Pages with the same sources get
+// grouped together.
+func attributeChanges(site attributionSite, outputChanges, sourceChanges []git.FileChange) []pageAttribution {
+	contentFiles := map[string]string{}
+	contentPages := map[string]string{}
+	for page, contentFile := range site.contentFiles {
+		contentFiles[page] = contentFile
+		contentPages[contentFile] = page
+	}
+	for _, change := range sourceChanges {
+		if strings.HasPrefix(change.Path, "content/") && contentPages[change.Path] == "" {
+			page := guessOutputPath(change.Path)
+			contentFiles[page] = change.Path
+			contentPages[change.Path] = page
+		}
+	}
+	site.contentFiles = contentFiles
+
+	bySources := map[string]*pageAttribution{}
+	groups := []*pageAttribution{}
+	for _, change := range outputChanges {
+		if path.Ext(change.Path) != ".html" {
+			continue
+		}
+		page := site.pageAt(change.Path)
+		sources := []string{}
+		for _, source := range sourceChanges {
+			if site.affects(source.Path, page, contentPages) {
+				sources = append(sources, source.Path)
+			}
+		}
+		key := strings.Join(sources, "\x00")
+		group, ok := bySources[key]
+		if !ok {
+			group = &pageAttribution{sources: sources}
+			bySources[key] = group
+			groups = append(groups, group)
+		}
+		group.pages = append(group.pages, change.Path)
+	}
+
+	attributions := []pageAttribution{}
+	for _, group := range groups {
+		attributions = append(attributions, *group)
+	}
+	// The most specific explanations first.
+	sort.SliceStable(attributions, func(i, j int) bool {
+		return len(attributions[i].pages) < len(attributions[j].pages)
+	})
+	return attributions
+}
+
+// The most pages to list in one row of the attribution report, before
+// summarizing the rest.
+const maxPagesPerRow = 3
+
+// The widest that the pages column gets.
+const maxPagesColumnWidth = 60
+
+// printAttribution shows which changed sources probably caused each changed
+// page in revision, compared to base, as two columns.
+func printAttribution(outputRepo git.Repository, base, revision BuiltRevision, languages []siteLanguage) error {
+	if revision.SourceChanges == nil {
+		return nil
+	}
+	outputChanges, err := outputRepo.ChangedFiles(base.Output, revision.Output)
+	if err != nil {
+		return err
+	}
+	attributions := attributeChanges(newAttributionSite(base, revision, languages), outputChanges, revision.SourceChanges)
+	if len(attributions) == 0 {
+		return nil
+	}
+
+	rows := [][2][]string{}
+	width := len("Changed pages")
+	for _, attribution := range attributions {
+		pages := attribution.pages
+		if len(pages) > maxPagesPerRow {
+			pages = append(append([]string{}, pages[:maxPagesPerRow]...),
+				fmt.Sprintf("(and %d more)", len(attribution.pages)-maxPagesPerRow))
+		}
+		sources := attribution.sources
+		if len(sources) == 0 {
+			sources = []string{"(no changed source found)"}
+		}
+		for _, page := range pages {
+			if len(page) > width {
+				width = len(page)
+			}
+		}
+		rows = append(rows, [2][]string{pages, sources})
+	}
+	if width > maxPagesColumnWidth {
+		width = maxPagesColumnWidth
+	}
+
+	out.Outln("Likely sources of the changes to pages:")
+	out.Outf("  %-*s  %s\n", width, "Changed pages", "Changed sources")
+	for _, row := range rows {
+		for i := 0; i < len(row[0]) || i < len(row[1]); i++ {
+			left, right := "", ""
+			if i < len(row[0]) {
+				left = row[0][i]
+			}
+			if i < len(row[1]) {
+				right = row[1][i]
+			}
+			out.Outln(strings.TrimRight(padRight(left, width)+"  "+right, " "))
+		}
+	}
+	return nil
+}
+
+func padRight(s string, width int) string {
+	if len(s) >= width {
+		return "  " + s
+	}
+	return "  " + s + strings.Repeat(" ", width-len(s))
+}
@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/mocks"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestSourceChangesBetween(t *testing.T) {
+	c := qt.New(t)
+	repo := new(mocks.Repository)
+	repo.On("RootDir").Return("/src")
+	repo.On("ChangedFiles", git.Hash("a"), git.Hash("b")).Return([]git.FileChange{
+		{Status: git.Modified, Path: "README.md"},
+		{Status: git.Modified, Path: "site/content/post.md"},
+		{Status: git.Modified, Path: "site/themes/checked-out"},
+		{Status: git.Modified, Path: "site/themes/missing"},
+		{Status: git.Added, Path: "site/themes/new"},
+	}, nil)
+	repo.On("Submodules", git.Hash("a")).Return(map[string]git.Hash{
+		"site/themes/checked-out": "c1",
+		"site/themes/missing":     "m1",
+	}, nil)
+	repo.On("Submodules", git.Hash("b")).Return(map[string]git.Hash{
+		"site/themes/checked-out": "c2",
+		"site/themes/missing":     "m2",
+		"site/themes/new":         "n1",
+	}, nil)
+
+	theme := new(mocks.Repository)
+	theme.On("RootDir").Return(filepath.Join("/src", "site", "themes", "checked-out"))
+	theme.On("ChangedFiles", git.Hash("c1"), git.Hash("c2")).Return([]git.FileChange{
+		{Status: git.Modified, Path: "layouts/_default/single.html"},
+	}, nil)
+	git_ := new(mocks.Git)
+	git_.On("OpenRepository", filepath.Join("/src", "site", "themes", "checked-out")).Return(theme, nil)
+	git_.On("OpenRepository", filepath.Join("/src", "site", "themes", "missing")).Return(nil, errors.New("not a git repo"))
+
+	// It gets the site's directory from `git rev-parse --show-prefix`.
+	changes, err := sourceChangesBetween(context.Background(), git_, repo, "site/", "a", "b")
+	c.Assert(err, qt.IsNil)
+	c.Check(changes, qt.DeepEquals, []git.FileChange{
+		{Status: git.Modified, Path: "content/post.md"},
+		{Status: git.Modified, Path: "themes/checked-out/layouts/_default/single.html"},
+		{Status: git.Modified, Path: "themes/missing"},
+		{Status: git.Added, Path: "themes/new"},
+	})
+}
+
+func TestOutputPathOf(t *testing.T) {
+	c := qt.New(t)
+	c.Check(outputPathOf("https://example.org/posts/hello/", ""), qt.Equals, "posts/hello/index.html")
+	c.Check(outputPathOf("https://example.org/blog/posts/hello/", "blog"), qt.Equals, "posts/hello/index.html")
+	c.Check(outputPathOf("https://example.org/posts/hello.html", ""), qt.Equals, "posts/hello.html")
+	c.Check(outputPathOf("https://example.org/", ""), qt.Equals, "index.html")
+	c.Check(outputPathOf("", ""), qt.Equals, "")
+
+	c.Check(guessOutputPath("content/posts/hello.md"), qt.Equals, "posts/hello/index.html")
+	c.Check(guessOutputPath("content/posts/bundle/index.md"), qt.Equals, "posts/bundle/index.html")
+	c.Check(guessOutputPath("content/posts/_index.md"), qt.Equals, "posts/index.html")
+	c.Check(guessOutputPath("content/_index.md"), qt.Equals, "index.html")
+}
+
+func TestAttributeChanges(t *testing.T) {
+	c := qt.New(t)
+	site := newAttributionSite(BuiltRevision{}, BuiltRevision{
+		Config: hugoConfig{"baseurl": `"https://example.org/"`},
+		Content: contentInventory{
+			"content/posts/hello.md":  {permalink: "https://example.org/2020/hello/"},
+			"content/posts/_index.md": {permalink: "https://example.org/posts/"},
+		},
+	}, []siteLanguage{{code: "en", dir: ""}, {code: "de", dir: "de"}})
+
+	attributions := attributeChanges(site,
+		[]git.FileChange{
+			{Status: git.Modified, Path: "index.html"},
+			{Status: git.Modified, Path: "2020/hello/index.html"},
+			{Status: git.Modified, Path: "posts/index.html"},
+			{Status: git.Modified, Path: "tags/go/index.html"},
+			{Status: git.Modified, Path: "de/tags/go/index.html"},
+			{Status: git.Modified, Path: "index.xml"},
+			{Status: git.Added, Path: "about/index.html"},
+			{Status: git.Modified, Path: "elsewhere/page/index.html"},
+		},
+		[]git.FileChange{
+			{Status: git.Modified, Path: "content/posts/hello.md"},
+			{Status: git.Added, Path: "content/about.md"},
+			{Status: git.Modified, Path: "layouts/_default/single.html"},
+			{Status: git.Modified, Path: "themes/t/layouts/posts/list.html"},
+			{Status: git.Modified, Path: "layouts/_default/term.html"},
+			{Status: git.Modified, Path: "i18n/de.toml"},
+			{Status: git.Modified, Path: "static/logo.png"},
+		})
+
+	bySource := map[string][]string{}
+	for _, attribution := range attributions {
+		for _, page := range attribution.pages {
+			bySource[page] = attribution.sources
+		}
+	}
+	c.Check(bySource, qt.DeepEquals, map[string][]string{
+		"index.html":                {},
+		"2020/hello/index.html":     {"content/posts/hello.md", "layouts/_default/single.html"},
+		"posts/index.html":          {"themes/t/layouts/posts/list.html"},
+		"tags/go/index.html":        {"layouts/_default/term.html"},
+		"de/tags/go/index.html":     {"layouts/_default/term.html", "i18n/de.toml"},
+		"about/index.html":          {"content/about.md", "layouts/_default/single.html"},
+		"elsewhere/page/index.html": {"layouts/_default/single.html"},
+	})
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/git"
+)
+
+// sizeBudget is the most bytes that the output files matching glob (or every
+// file, if it's empty) should have, from --size-budget.
+type sizeBudget struct {
+	glob  string
+	limit int
+}
+
+// Units for sizes, the same as humanBytes uses.
+var sizeUnits = map[string]int{
+	"":   1,
+	"b":  1,
+	"kb": 1024,
+	"mb": 1024 * 1024,
+	"gb": 1024 * 1024 * 1024,
+}
+
+// parseSizeBudget parses a budget like '500KB' or '*.js: 100KB'.
+func parseSizeBudget(spec string) (sizeBudget, error) {
+	budget := sizeBudget{}
+	size := spec
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		budget.glob = strings.TrimSpace(spec[:i])
+		size = spec[i+1:]
+		if budget.glob == "" {
+			return sizeBudget{}, fmt.Errorf("Size budgets look like '<size>' or '<glob>: <size>', but got '%s'", spec)
+		}
+		if _, err := path.Match(budget.glob, ""); err != nil {
+			return sizeBudget{}, fmt.Errorf("The size budget glob '%s' isn't valid: %v", budget.glob, err)
+		}
+	}
+	size = strings.ToLower(strings.TrimSpace(size))
+	number := strings.TrimRight(size, "abcdefghijklmnopqrstuvwxyz ")
+	unit, ok := sizeUnits[strings.TrimSpace(size[len(number):])]
+	value, err := strconv.ParseFloat(number, 64)
+	if !ok || err != nil || value <= 0 {
+		return sizeBudget{}, fmt.Errorf("Size budgets need a size like '500KB' or '2MB', but got '%s'", spec)
+	}
+	budget.limit = int(value * float64(unit))
+	return budget, nil
+}
+
+// budgetFor returns the budget for the output file at relPath: the last of
+// budgets that matches it, like in .gitattributes. ok is false if there isn't
+// one.
+func budgetFor(budgets []sizeBudget, relPath string) (budget sizeBudget, ok bool) {
+	for _, b := range budgets {
+		if b.glob == "" || globMatches(b.glob, relPath) {
+			budget, ok = b, true
+		}
+	}
+	return budget, ok
+}
+
+// findOverBudgetFiles returns the files among changes which are bigger than
+// their budget in revision. Files which were already over budget in base
+// only count if they got bigger.
+func findOverBudgetFiles(outputRepo git.Repository, site attributionSite, base, revision BuiltRevision, changes []git.FileChange, budgets []sizeBudget) ([]checkResult, error) {
+	paths := []string{}
+	for _, change := range changes {
+		if _, ok := budgetFor(budgets, change.Path); ok && change.Status != git.Deleted {
+			paths = append(paths, change.Path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	sizes, err := outputRepo.FileSizes(revision.Raw, paths)
+	if err != nil {
+		return nil, err
+	}
+	baseSizes, err := outputRepo.FileSizes(base.Raw, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []checkResult{}
+	for _, p := range paths {
+		budget, _ := budgetFor(budgets, p)
+		size, ok := sizes[p]
+		if !ok || size <= budget.limit {
+			continue
+		}
+		message := fmt.Sprintf("%s is %s, over its budget of %s", p, humanBytes(size), humanBytes(budget.limit))
+		if baseSize, ok := baseSizes[p]; ok {
+			if baseSize >= size && baseSize > budget.limit {
+				continue
+			}
+			message += fmt.Sprintf(" (it was %s)", humanBytes(baseSize))
+		}
+		results = append(results, checkResult{
+			check:      checkSizeBudget,
+			message:    message,
+			outputPath: p,
+			sourcePath: site.contentFiles[p],
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/mocks"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestParseSizeBudget(t *testing.T) {
+	c := qt.New(t)
+	for spec, expected := range map[string]sizeBudget{
+		"2048":           {limit: 2048},
+		"500 B":          {limit: 500},
+		"100KB":          {limit: 100 * 1024},
+		"1.5mb":          {limit: 1024 * 1024 * 3 / 2},
+		"*.js: 100KB":    {glob: "*.js", limit: 100 * 1024},
+		"/img/*.png:1GB": {glob: "/img/*.png", limit: 1024 * 1024 * 1024},
+	} {
+		budget, err := parseSizeBudget(spec)
+		c.Assert(err, qt.IsNil, qt.Commentf(spec))
+		c.Check(budget, qt.Equals, expected, qt.Commentf(spec))
+	}
+	for _, spec := range []string{"", "lots", "10 TB", "-1KB", ": 10KB", "[: 10KB"} {
+		_, err := parseSizeBudget(spec)
+		c.Check(err, qt.Not(qt.IsNil), qt.Commentf(spec))
+	}
+}
+
+func TestBudgetFor(t *testing.T) {
+	c := qt.New(t)
+	budgets := []sizeBudget{{limit: 1000}, {glob: "*.js", limit: 100}}
+	budget, ok := budgetFor(budgets, "js/app.js")
+	c.Check(ok, qt.Equals, true)
+	c.Check(budget.limit, qt.Equals, 100)
+	budget, ok = budgetFor(budgets, "index.html")
+	c.Check(ok, qt.Equals, true)
+	c.Check(budget.limit, qt.Equals, 1000)
+	_, ok = budgetFor(budgets[1:], "index.html")
+	c.Check(ok, qt.Equals, false)
+}
+
+func TestFindOverBudgetFiles(t *testing.T) {
+	c := qt.New(t)
+	repo := new(mocks.Repository)
+	repo.On("FileSizes", git.Hash("rev"), []string{"app.js", "big.js", "grew.js", "ok.js"}).Return(map[string]int{
+		"app.js": 2048, "big.js": 4096, "grew.js": 5000, "ok.js": 10,
+	}, nil)
+	repo.On("FileSizes", git.Hash("base"), []string{"app.js", "big.js", "grew.js", "ok.js"}).Return(map[string]int{
+		"big.js": 5000, "grew.js": 4096,
+	}, nil)
+	changes := []git.FileChange{
+		{Path: "app.js", Status: git.Added},
+		{Path: "big.js", Status: git.Modified},
+		{Path: "gone.js", Status: git.Deleted},
+		{Path: "grew.js", Status: git.Modified},
+		{Path: "index.html", Status: git.Modified},
+		{Path: "ok.js", Status: git.Modified},
+	}
+	base := BuiltRevision{Raw: "base"}
+	revision := BuiltRevision{Raw: "rev"}
+	budgets := []sizeBudget{{glob: "*.js", limit: 1024}}
+
+	results, err := findOverBudgetFiles(repo, newAttributionSite(base, revision, nil), base, revision, changes, budgets)
+	c.Assert(err, qt.IsNil)
+	// big.js was already over budget, and shrank.
+	c.Assert(results, qt.HasLen, 2)
+	c.Check(results[0].check, qt.Equals, checkSizeBudget)
+	c.Check(results[0].outputPath, qt.Equals, "app.js")
+	c.Check(results[0].message, qt.Equals, "app.js is 2.0 KB, over its budget of 1.0 KB")
+	c.Check(results[1].message, qt.Equals, "grew.js is 4.9 KB, over its budget of 1.0 KB (it was 4.0 KB)")
+
+	results, err = findOverBudgetFiles(repo, newAttributionSite(base, revision, nil), base, revision, changes, nil)
+	c.Assert(err, qt.IsNil)
+	c.Check(results, qt.HasLen, 0)
+}
@@ -0,0 +1,371 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/git"
+)
+
+// The checks that grouse can run over each revision, for reports in formats
+// which CI systems understand.
+const (
+	checkPageRemovals  = "page-removals"
+	checkBrokenLinks   = "broken-links"
+	checkBuildWarnings = "build-warnings"
+	checkSizeBudget    = "size-budget"
+)
+
+// siteCheck describes one of the checks.
+type siteCheck struct {
+	name        string
+	description string
+	// Whether what it finds is definitely a problem, rather than something to
+	// look at.
+	isError bool
+}
+
+var siteChecks = []siteCheck{
+	{checkPageRemovals, "Pages which disappeared from the output, although their content wasn't deleted", false},
+	{checkBrokenLinks, "Links to pages or files which aren't in the output", true},
+	{checkBuildWarnings, "Warnings from Hugo which the base revision's build didn't have", false},
+	{checkSizeBudget, "Output files which are bigger than their --size-budget", true},
+}
+
+// checkResult is a problem that one of the checks found.
+type checkResult struct {
+	check   string
+	message string
+	// The file in the output that it's about, if there is one.
+	outputPath string
+	// The source file that probably caused it, relative to the Hugo site, and
+	// the line in it, if they're known.
+	sourcePath string
+	sourceLine int
+}
+
+// revisionChecks is what the checks found in a revision.
+type revisionChecks struct {
+	revision BuiltRevision
+	results  []checkResult
+}
+
+// runChecks runs every check over each of revisions, compared to base. The
+// changes in revisions are the ones to report on.
+func runChecks(outputRepo git.Repository, base BuiltRevision, revisions []revisionChanges, budgets []sizeBudget) ([]revisionChecks, error) {
+	all := []revisionChecks{}
+	for _, revision := range revisions {
+		site := newAttributionSite(base, revision.revision, nil)
+		results := findPageRemovals(site, base, revision.revision, revision.changes)
+		brokenLinks, err := findBrokenLinks(outputRepo, site, base, revision.revision)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, brokenLinks...)
+		results = append(results, findNewBuildWarnings(base, revision.revision)...)
+		overBudget, err := findOverBudgetFiles(outputRepo, site, base, revision.revision, revision.changes, budgets)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, overBudget...)
+		all = append(all, revisionChecks{revision: revision.revision, results: results})
+	}
+	return all, nil
+}
+
+// findPageRemovals returns the pages which were removed in revision, unless
+// their content file was deleted too. If it isn't known which content
+// file each page comes from, or which source files changed, every removed page
+// counts.
+func findPageRemovals(site attributionSite, base, revision BuiltRevision, changes []git.FileChange) []checkResult {
+	deleted := map[string]bool{}
+	for _, change := range revision.SourceChanges {
+		if change.Status == git.Deleted {
+			deleted[change.Path] = true
+		}
+	}
+	known := base.Content != nil && revision.SourceChanges != nil
+
+	results := []checkResult{}
+	for _, change := range changes {
+		if change.Status != git.Deleted || path.Ext(change.Path) != ".html" {
+			continue
+		}
+		contentFile := site.contentFiles[change.Path]
+		switch {
+		case !known:
+			results = append(results, checkResult{
+				check:      checkPageRemovals,
+				message:    fmt.Sprintf("%s was removed", change.Path),
+				outputPath: change.Path,
+				sourcePath: contentFile,
+			})
+		case contentFile != "" && !deleted[contentFile]:
+			results = append(results, checkResult{
+				check:      checkPageRemovals,
+				message:    fmt.Sprintf("%s was removed, although its content file %s wasn't", change.Path, contentFile),
+				outputPath: change.Path,
+				sourcePath: contentFile,
+			})
+		}
+	}
+	return results
+}
+
+// linkAttribute matches the href and src attributes in HTML.
+var linkAttribute = regexp.MustCompile(`(?i)\s(?:href|src)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+// findLinks returns the links in an HTML page.
+func findLinks(page []byte) []string {
+	links := []string{}
+	for _, match := range linkAttribute.FindAllSubmatch(page, -1) {
+		links = append(links, string(bytes.Join(match[1:], nil)))
+	}
+	return links
+}
+
+// siteLocation is where the site is served from, for telling which links
+// point into it.
+type siteLocation struct {
+	host string
+	// The path of the site's baseURL, without slashes at either end.
+	basePath string
+}
+
+func siteLocationOf(config hugoConfig) siteLocation {
+	var baseURL string
+	json.Unmarshal([]byte(config["baseurl"]), &baseURL)
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return siteLocation{}
+	}
+	return siteLocation{host: strings.ToLower(u.Host), basePath: strings.Trim(u.Path, "/")}
+}
+
+// linkTarget returns the path in the output that link, on the page at
+// pagePath, points to. It ends with a slash if the link is to a directory, and
+// it's "" for the root of the site. It returns false for links which point
+// outside the site, or can't be checked, e.g. because they're only a fragment.
+func (s siteLocation) linkTarget(link string, pagePath string) (string, bool) {
+	link = html.UnescapeString(strings.TrimSpace(link))
+	u, err := url.Parse(link)
+	if err != nil || u.Path == "" || u.Opaque != "" {
+		return "", false
+	}
+	if u.Scheme != "" || u.Host != "" {
+		isWeb := u.Scheme == "" || u.Scheme == "http" || u.Scheme == "https"
+		if !isWeb || s.host == "" || strings.ToLower(u.Host) != s.host {
+			return "", false
+		}
+	}
+
+	var p string
+	if strings.HasPrefix(u.Path, "/") {
+		p = strings.Trim(path.Clean(u.Path), "/")
+		if s.basePath != "" {
+			if p != s.basePath && !strings.HasPrefix(p, s.basePath+"/") {
+				// Somewhere else on the same server.
+				return "", false
+			}
+			p = strings.TrimPrefix(strings.TrimPrefix(p, s.basePath), "/")
+		}
+	} else {
+		p = path.Join(path.Dir(pagePath), u.Path)
+		if p == ".." || strings.HasPrefix(p, "../") {
+			return "", false
+		}
+		if p == "." {
+			p = ""
+		}
+	}
+	if p != "" && strings.HasSuffix(u.Path, "/") {
+		p += "/"
+	}
+	return p, true
+}
+
+// linkExists reports whether target (as returned by linkTarget) is in files.
+func linkExists(files map[string]bool, target string) bool {
+	if target == "" || strings.HasSuffix(target, "/") {
+		return files[target+"index.html"]
+	}
+	return files[target] || files[target+"/index.html"]
+}
+
+// brokenLinksIn reads the given pages from the output at commit, and returns
+// the links on each of them that point to files which aren't in files.
+func brokenLinksIn(outputRepo git.Repository, commit git.Hash, pages []string, files map[string]bool, location siteLocation) (map[string][]string, error) {
+	broken := map[string][]string{}
+	err := outputRepo.ReadFiles(commit, pages, func(page string, content []byte) error {
+		seen := map[string]bool{}
+		for _, link := range findLinks(content) {
+			target, ok := location.linkTarget(link, page)
+			if !ok {
+				continue
+			}
+			if !linkExists(files, target) && !seen[target] {
+				seen[target] = true
+				broken[page] = append(broken[page], target)
+			}
+		}
+		return nil
+	})
+	return broken, err
+}
+
+// findBrokenLinks returns the links in the output of revision that point to
+// files which aren't in it, apart from ones which were broken in base
+// already.
+func findBrokenLinks(outputRepo git.Repository, site attributionSite, base, revision BuiltRevision) ([]checkResult, error) {
+	files, pages, err := outputPages(outputRepo, revision.Raw)
+	if err != nil {
+		return nil, err
+	}
+	broken, err := brokenLinksIn(outputRepo, revision.Raw, pages, files, siteLocationOf(revision.Config))
+	if err != nil || len(broken) == 0 {
+		return nil, err
+	}
+
+	// Only the pages with broken links need checking in base.
+	withBroken := []string{}
+	for page := range broken {
+		withBroken = append(withBroken, page)
+	}
+	sort.Strings(withBroken)
+	baseFiles, _, err := outputPages(outputRepo, base.Raw)
+	if err != nil {
+		return nil, err
+	}
+	brokenInBase, err := brokenLinksIn(outputRepo, base.Raw, withBroken, baseFiles, siteLocationOf(base.Config))
+	if err != nil {
+		return nil, err
+	}
+
+	results := []checkResult{}
+	for _, page := range withBroken {
+		wasBroken := map[string]bool{}
+		for _, target := range brokenInBase[page] {
+			wasBroken[target] = true
+		}
+		for _, target := range broken[page] {
+			if wasBroken[target] {
+				continue
+			}
+			results = append(results, checkResult{
+				check:      checkBrokenLinks,
+				message:    fmt.Sprintf("%s links to /%s, which isn't in the output", page, target),
+				outputPath: page,
+				sourcePath: site.contentFiles[page],
+			})
+		}
+	}
+	return results, nil
+}
+
+// outputPages returns every file in the output at commit, and the HTML pages
+// among them.
+func outputPages(outputRepo git.Repository, commit git.Hash) (map[string]bool, []string, error) {
+	list, err := outputRepo.ListFiles(commit, ".")
+	if err != nil {
+		return nil, nil, err
+	}
+	files := map[string]bool{}
+	pages := []string{}
+	for _, file := range list {
+		files[file] = true
+		if path.Ext(file) == ".html" {
+			pages = append(pages, file)
+		}
+	}
+	return files, pages, nil
+}
+
+// hugoWarning matches the warnings that Hugo prints, with or without the
+// timestamp that older versions put before them.
+var hugoWarning = regexp.MustCompile(`^WARN\s+(?:\d{4}/\d\d/\d\d \d\d:\d\d:\d\d\s+)?(.*)$`)
+
+// ansiEscape matches the escape sequences that colour terminal output.
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// warningRecorder passes Hugo's output through to another writer, and
+// keeps the warnings in it.
+type warningRecorder struct {
+	w        io.Writer
+	hugoDir  string
+	partial  []byte
+	warnings []string
+}
+
+func newWarningRecorder(w io.Writer, hugoDir string) *warningRecorder {
+	return &warningRecorder{w: w, hugoDir: hugoDir}
+}
+
+func (r *warningRecorder) Write(p []byte) (int, error) {
+	r.partial = append(r.partial, p...)
+	for {
+		end := bytes.IndexByte(r.partial, '\n')
+		if end < 0 {
+			break
+		}
+		r.record(string(r.partial[:end]))
+		r.partial = r.partial[end+1:]
+	}
+	return r.w.Write(p)
+}
+
+// Close records the last line, if it didn't end with a newline.
+func (r *warningRecorder) Close() error {
+	if len(r.partial) > 0 {
+		r.record(string(r.partial))
+		r.partial = nil
+	}
+	return nil
+}
+
+func (r *warningRecorder) record(line string) {
+	line = strings.TrimSpace(ansiEscape.ReplaceAllString(line, ""))
+	match := hugoWarning.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	// The site gets built in a different directory each time, so paths in
+	// warnings only compare equal if they're relative.
+	warning := strings.Replace(match[1], strings.TrimSuffix(r.hugoDir, "/")+"/", "", -1)
+	r.warnings = append(r.warnings, warning)
+}
+
+// warningLocation matches the path of a source file in a warning, and the
+// line in it, if there is one.
+var warningLocation = regexp.MustCompile(`\b((?:archetypes|assets|config|content|data|i18n|layouts|static|themes)/[^\s:"']+|(?:config|hugo)\.(?:toml|yaml|yml|json))(?::(\d+))?`)
+
+// findNewBuildWarnings returns the warnings from building revision which
+// building base didn't have.
+func findNewBuildWarnings(base, revision BuiltRevision) []checkResult {
+	inBase := map[string]int{}
+	for _, warning := range base.BuildWarnings {
+		inBase[warning]++
+	}
+	results := []checkResult{}
+	for _, warning := range revision.BuildWarnings {
+		if inBase[warning] > 0 {
+			inBase[warning]--
+			continue
+		}
+		result := checkResult{check: checkBuildWarnings, message: warning}
+		if match := warningLocation.FindStringSubmatch(warning); match != nil {
+			result.sourcePath = match[1]
+			result.sourceLine, _ = strconv.Atoi(match[2])
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,203 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/mocks"
+	qt "github.com/frankban/quicktest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLinkTarget(t *testing.T) {
+	c := qt.New(t)
+	site := siteLocation{host: "example.com", basePath: "docs"}
+	for _, test := range []struct {
+		link   string
+		target string
+		ok     bool
+	}{
+		{"/docs/", "", true},
+		{"/docs/posts/", "posts/", true},
+		{"/docs/posts/hello/?x=1#top", "posts/hello/", true},
+		{"https://example.com/docs/logo.png", "logo.png", true},
+		{"//EXAMPLE.com/docs/about", "about", true},
+		{"../other/", "posts/other/", true},
+		{"style.css", "posts/hello/style.css", true},
+		{"/docs/a%20b/", "a b/", true},
+		{"/elsewhere/", "", false},
+		{"https://example.org/docs/", "", false},
+		{"mailto:someone@example.com", "", false},
+		{"#top", "", false},
+		{"../../../up", "", false},
+	} {
+		target, ok := site.linkTarget(test.link, "posts/hello/index.html")
+		c.Check(ok, qt.Equals, test.ok, qt.Commentf("link %s", test.link))
+		c.Check(target, qt.Equals, test.target, qt.Commentf("link %s", test.link))
+	}
+}
+
+func TestFindLinks(t *testing.T) {
+	c := qt.New(t)
+	page := []byte(`<a href="/one/">1</a><img SRC='two.png'><link href=/three.css><a data-href="/no/">`)
+	c.Assert(findLinks(page), qt.DeepEquals, []string{"/one/", "two.png", "/three.css"})
+}
+
+func TestLinkExists(t *testing.T) {
+	c := qt.New(t)
+	files := map[string]bool{"index.html": true, "posts/index.html": true, "logo.png": true}
+	c.Check(linkExists(files, ""), qt.Equals, true)
+	c.Check(linkExists(files, "posts/"), qt.Equals, true)
+	c.Check(linkExists(files, "posts"), qt.Equals, true)
+	c.Check(linkExists(files, "logo.png"), qt.Equals, true)
+	c.Check(linkExists(files, "about/"), qt.Equals, false)
+	c.Check(linkExists(files, "logo.png/"), qt.Equals, false)
+}
+
+func TestFindPageRemovals(t *testing.T) {
+	c := qt.New(t)
+	base := BuiltRevision{Content: contentInventory{
+		"content/kept.md":    {permalink: "https://example.com/kept/"},
+		"content/deleted.md": {permalink: "https://example.com/deleted/"},
+	}}
+	revision := BuiltRevision{SourceChanges: []git.FileChange{{Status: git.Deleted, Path: "content/deleted.md"}}}
+	site := newAttributionSite(base, revision, nil)
+	changes := []git.FileChange{
+		{Status: git.Deleted, Path: "kept/index.html"},
+		{Status: git.Deleted, Path: "deleted/index.html"},
+		{Status: git.Deleted, Path: "kept/index.xml"},
+		{Status: git.Modified, Path: "index.html"},
+	}
+
+	results := findPageRemovals(site, base, revision, changes)
+	c.Assert(results, qt.HasLen, 1)
+	c.Check(results[0].outputPath, qt.Equals, "kept/index.html")
+	c.Check(results[0].sourcePath, qt.Equals, "content/kept.md")
+
+	// Without knowing which source files changed, every removed page counts.
+	revision.SourceChanges = nil
+	c.Check(findPageRemovals(site, base, revision, changes), qt.HasLen, 2)
+}
+
+func TestFindBrokenLinks(t *testing.T) {
+	c := qt.New(t)
+	repo := new(mocks.Repository)
+	repo.On("ListFiles", git.Hash("base"), ".").Return([]string{"index.html", "old/index.html"}, nil)
+	repo.On("ListFiles", git.Hash("rev"), ".").Return([]string{"index.html", "new/index.html"}, nil)
+	pages := map[git.Hash]map[string]string{
+		"base": {"index.html": `<a href="/old/">old</a> <a href="/gone/">gone</a>`},
+		"rev":  {"index.html": `<a href="/old/">old</a> <a href="/gone/">gone</a> <a href="/new/">new</a>`},
+	}
+	repo.On("ReadFiles", mock.Anything, mock.Anything, mock.Anything).Return(func(commit git.Hash, paths []string, read func(string, []byte) error) error {
+		for _, p := range paths {
+			if content, ok := pages[commit][p]; ok {
+				if err := read(p, []byte(content)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	config := hugoConfig{"baseurl": `"https://example.com/"`}
+	base := BuiltRevision{Raw: "base", Config: config}
+	revision := BuiltRevision{Raw: "rev", Config: config}
+	results, err := findBrokenLinks(repo, newAttributionSite(base, revision, nil), base, revision)
+	c.Assert(err, qt.IsNil)
+	// /gone/ was broken already.
+	c.Assert(results, qt.HasLen, 1)
+	c.Check(results[0].check, qt.Equals, checkBrokenLinks)
+	c.Check(results[0].outputPath, qt.Equals, "index.html")
+	c.Check(results[0].message, qt.Equals, "index.html links to /old/, which isn't in the output")
+}
+
+func TestWarningRecorder(t *testing.T) {
+	c := qt.New(t)
+	var passed bytes.Buffer
+	recorder := newWarningRecorder(&passed, "/tmp/build/web")
+	recorder.Write([]byte("Building sites … \x1b[33mWARN\x1b[0m 2020/01/02 03:04:05 found no layout for \"page\"\n"))
+	recorder.Write([]byte("WARN /tmp/build/web/content/post.md:3:1: "))
+	recorder.Write([]byte("shortcode not closed\nTotal in 5 ms\nWARN no newline"))
+	recorder.Close()
+	c.Check(passed.String(), qt.Contains, "Total in 5 ms")
+	c.Assert(recorder.warnings, qt.DeepEquals, []string{
+		"content/post.md:3:1: shortcode not closed",
+		"no newline",
+	})
+}
+
+func TestFindNewBuildWarnings(t *testing.T) {
+	c := qt.New(t)
+	base := BuiltRevision{BuildWarnings: []string{"same", "twice"}}
+	revision := BuiltRevision{BuildWarnings: []string{"same", "twice", "twice", `"content/post.md:3:1": bad shortcode`}}
+	results := findNewBuildWarnings(base, revision)
+	c.Assert(results, qt.HasLen, 2)
+	c.Check(results[0].message, qt.Equals, "twice")
+	c.Check(results[0].sourcePath, qt.Equals, "")
+	c.Check(results[1].sourcePath, qt.Equals, "content/post.md")
+	c.Check(results[1].sourceLine, qt.Equals, 3)
+}
+
+func exampleChecks() (BuiltRevision, []revisionChecks) {
+	base := BuiltRevision{Name: "main"}
+	revision := BuiltRevision{Name: "feature", SiteDir: "web/"}
+	return base, []revisionChecks{{revision: revision, results: []checkResult{
+		{check: checkBrokenLinks, message: "index.html links to /old/", outputPath: "index.html", sourcePath: "content/_index.md"},
+		{check: checkBuildWarnings, message: "content/post.md:3: bad shortcode", sourcePath: "content/post.md", sourceLine: 3},
+	}}}
+}
+
+func TestJUnitReport(t *testing.T) {
+	c := qt.New(t)
+	base, checks := exampleChecks()
+	var buf bytes.Buffer
+	c.Assert(writeJUnitReport(&buf, base, checks), qt.IsNil)
+
+	var report junitTestSuites
+	c.Assert(xml.Unmarshal(buf.Bytes(), &report), qt.IsNil)
+	c.Check(report.Tests, qt.Equals, 4)
+	c.Check(report.Failures, qt.Equals, 2)
+	c.Assert(report.Suites, qt.HasLen, 1)
+	suite := report.Suites[0]
+	c.Check(suite.Name, qt.Equals, "feature compared to main")
+	c.Assert(suite.Cases, qt.HasLen, 4)
+	c.Check(suite.Cases[0].ClassName, qt.Equals, "grouse.page-removals")
+	c.Check(suite.Cases[0].Failure, qt.IsNil)
+	c.Check(suite.Cases[1].Name, qt.Equals, "index.html")
+	c.Check(suite.Cases[1].File, qt.Equals, "web/content/_index.md")
+	c.Check(suite.Cases[1].Failure.Type, qt.Equals, checkBrokenLinks)
+	c.Check(suite.Cases[2].Name, qt.Equals, "content/post.md:3: bad shortcode")
+	c.Check(suite.Cases[2].Line, qt.Equals, 3)
+	c.Check(suite.Cases[3].ClassName, qt.Equals, "grouse.size-budget")
+	c.Check(suite.Cases[3].Failure, qt.IsNil)
+}
+
+func TestSARIFReport(t *testing.T) {
+	c := qt.New(t)
+	base, checks := exampleChecks()
+	var buf bytes.Buffer
+	c.Assert(writeSARIFReport(&buf, base, checks), qt.IsNil)
+
+	var log sarifLog
+	c.Assert(json.Unmarshal(buf.Bytes(), &log), qt.IsNil)
+	c.Check(log.Version, qt.Equals, "2.1.0")
+	c.Assert(log.Runs, qt.HasLen, 1)
+	run := log.Runs[0]
+	c.Check(run.Tool.Driver.Rules, qt.HasLen, len(siteChecks))
+	c.Assert(run.Results, qt.HasLen, 2)
+
+	link := run.Results[0]
+	c.Check(link.Level, qt.Equals, "error")
+	c.Check(link.Locations[0].PhysicalLocation.ArtifactLocation.URI, qt.Equals, "web/content/_index.md")
+	c.Assert(link.RelatedLocations, qt.HasLen, 1)
+	c.Check(link.RelatedLocations[0].PhysicalLocation.ArtifactLocation, qt.Equals,
+		sarifArtifactLocation{URI: "index.html", URIBaseID: sarifOutputBase})
+
+	warning := run.Results[1]
+	c.Check(warning.Level, qt.Equals, "warning")
+	c.Check(warning.Locations[0].PhysicalLocation.Region.StartLine, qt.Equals, 3)
+	c.Check(warning.RelatedLocations, qt.HasLen, 0)
+}
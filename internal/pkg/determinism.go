@@ -0,0 +1,273 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// RunCheckDeterminismCommand builds the same revision several times, and
+// reports the output files which differ between builds. Those are the ones
+// that'll show up as phantom changes in every comparison.
+func RunCheckDeterminismCommand(cmd *cobra.Command) {
+	userArgs, err := parseCheckDeterminismArgs(cmd.Flags())
+	if err != nil {
+		out.Outln("Error:", err)
+		cmd.Usage()
+		os.Exit(1)
+	}
+	out.Reinit(userArgs.debug)
+
+	runToCompletion(*userArgs, func(ctx context.Context) error {
+		return runCheckDeterminism(ctx, git.NewGit(ctx), *userArgs)
+	})
+}
+
+func runCheckDeterminism(ctx context.Context, git_ git.Git, userArgs cmdArgs) error {
+	opts := userArgs.buildOptions()
+	opts.Commits = nil
+	for i := 0; i < userArgs.builds; i++ {
+		opts.Commits = append(opts.Commits, userArgs.commits[0])
+	}
+	// Otherwise, anything that Hugo caches in the source directory (e.g.
+	// processed images in resources/_gen) would make the later builds look
+	// more stable than they are.
+	opts.FreshSource = true
+
+	build, err := BuildRevisions(ctx, git_, opts)
+	if err != nil {
+		return err
+	}
+	defer build.Close()
+
+	build.Base.Description = fmt.Sprintf("%s (build 1)", build.Base)
+	for i := range build.Revisions {
+		build.Revisions[i].Description = fmt.Sprintf("%s (build %d)", build.Revisions[i], i+2)
+	}
+
+	unstable, err := findUnstableFiles(build.OutputRepo, build.Base, build.Revisions)
+	if err != nil {
+		return err
+	}
+	if len(unstable) == 0 {
+		out.Outf("All %d builds of %s were identical.\n", userArgs.builds, userArgs.commits[0])
+		return nil
+	}
+	printUnstableFiles(unstable)
+
+	if userArgs.writeRules != "" {
+		if err := writeRulesFile(userArgs.writeRules, unstable, userArgs.commits[0]); err != nil {
+			return errors.WithMessagef(err, "Couldn't write rules to %s", userArgs.writeRules)
+		}
+		out.Outf("Wrote rules which hide these differences to %s; use them with --ignore-rules.\n", userArgs.writeRules)
+	}
+
+	if len(build.Revisions) == 1 {
+		err = compareTwoRevisions(ctx, build.OutputRepo, build.Base, build.Revisions[0], userArgs)
+	} else if err = exportOutputs(ctx, build.OutputRepo.RootDir(), build.Base, nil, userArgs); err == nil {
+		// Unlike compareSeveralRevisions, there's no summary; it'd only
+		// repeat the list of unstable files.
+		err = showEachRevision(ctx, build.OutputRepo, build.Base, build.Revisions, userArgs)
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%d output files differ between builds of %s", len(unstable), userArgs.commits[0])
+}
+
+// unstableFile is an output file which differs between builds of the same
+// revision.
+type unstableFile struct {
+	path string
+	// Regexps matching the lines of the file which vary, if it was possible
+	// to narrow it down to that.
+	patterns []string
+	// Set if the whole file should be treated as varying, e.g. because it
+	// only exists in some builds, or it's binary.
+	wholeFile bool
+	// Why the whole file varies, for the report.
+	reason string
+}
+
+// findUnstableFiles compares every one of rebuilds to base, and returns the
+// output files that differ, sorted by path.
+func findUnstableFiles(outputRepo git.Repository, base BuiltRevision, rebuilds []BuiltRevision) ([]unstableFile, error) {
+	byPath := map[string]*unstableFile{}
+	for _, rebuild := range rebuilds {
+		changes, err := outputRepo.ChangedFiles(base.Output, rebuild.Output)
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range changes {
+			file, ok := byPath[change.Path]
+			if !ok {
+				file = &unstableFile{path: change.Path}
+				byPath[change.Path] = file
+			}
+			if file.wholeFile {
+				continue
+			}
+			switch change.Status {
+			case git.Added, git.Deleted:
+				file.wholeFile, file.reason = true, "only exists in some builds"
+				continue
+			case git.TypeChanged:
+				file.wholeFile, file.reason = true, "changes type"
+				continue
+			}
+			lineChanges, err := outputRepo.ChangedLines(base.Output, rebuild.Output, change.Path)
+			if err == git.ErrBinaryFile {
+				file.wholeFile, file.reason = true, "binary"
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			for _, lineChange := range lineChanges {
+				patterns, ok := varyingLinePatterns(lineChange)
+				if !ok {
+					file.wholeFile, file.reason = true, "lines added, removed or completely rewritten"
+					break
+				}
+				file.patterns = appendNew(file.patterns, patterns...)
+			}
+		}
+	}
+
+	unstable := []unstableFile{}
+	for _, file := range byPath {
+		unstable = append(unstable, *file)
+	}
+	sort.Slice(unstable, func(i, j int) bool {
+		return unstable[i].path < unstable[j].path
+	})
+	return unstable, nil
+}
+
+// varyingLinePatterns returns a regexp for each of the lines in change, which
+// matches both versions of it, but not much else. It gives up if lines got
+// added or removed, or if there isn't enough in common between the two
+// versions of a line for a regexp to be meaningful.
+func varyingLinePatterns(change git.LineChange) ([]string, bool) {
+	if len(change.Removed) != len(change.Added) {
+		return nil, false
+	}
+	patterns := []string{}
+	for i := range change.Removed {
+		pattern, ok := varyingLinePattern(change.Removed[i], change.Added[i])
+		if !ok {
+			return nil, false
+		}
+		patterns = appendNew(patterns, pattern)
+	}
+	return patterns, true
+}
+
+// The minimum number of characters (apart from whitespace) that two versions
+// of a line need to have in common to be masked, rather than treating the
+// whole file as varying.
+const minCommonChars = 4
+
+// varyingLinePattern returns a regexp which matches the common start and end
+// of a and b, and anything in between. The varying part gets widened to whole
+// words, so that e.g. a time of 10:01 and 10:02 masks the whole "01", rather
+// than only the "1".
+func varyingLinePattern(a, b string) (string, bool) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for prefix > 0 && isWordByte(a[prefix-1]) {
+		prefix--
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for suffix > 0 && isWordByte(a[len(a)-suffix]) {
+		suffix--
+	}
+
+	start, end := a[:prefix], a[len(a)-suffix:]
+	if len(strings.TrimSpace(start))+len(strings.TrimSpace(end)) < minCommonChars {
+		return "", false
+	}
+	return "^" + regexp.QuoteMeta(start) + ".*" + regexp.QuoteMeta(end) + "$", true
+}
+
+// isWordByte reports whether c is part of a word: a letter, digit or
+// underscore, or part of a non-ASCII character.
+func isWordByte(c byte) bool {
+	return c >= utf8.RuneSelf || c == '_' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// appendNew appends the values which aren't already in list.
+func appendNew(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+func printUnstableFiles(unstable []unstableFile) {
+	out.Outf("%d output files differ between builds:\n", len(unstable))
+	for _, file := range unstable {
+		if file.wholeFile {
+			out.Outf("  %s: the whole file (%s)\n", file.path, file.reason)
+		} else {
+			out.Outf("  %s: lines matching %s\n", file.path, strings.Join(file.patterns, ", "))
+		}
+	}
+}
+
+// rulesFor returns rules which hide the differences in unstable: the lines
+// that vary get masked, and files which vary as a whole get ignored.
+func rulesFor(unstable []unstableFile) *ignoreRules {
+	rules := &ignoreRules{}
+	for _, file := range unstable {
+		glob := escapeGlob(file.path)
+		if file.wholeFile {
+			rules.ignored = append(rules.ignored, glob)
+			continue
+		}
+		for _, pattern := range file.patterns {
+			rules.masks = append(rules.masks, lineMask{glob: glob, pattern: regexp.MustCompile(pattern)})
+		}
+	}
+	return rules
+}
+
+func writeRulesFile(rulesPath string, unstable []unstableFile, ref string) error {
+	f, err := os.Create(rulesPath)
+	if err != nil {
+		return err
+	}
+	comment := fmt.Sprintf(
+		"Written by `grouse check-determinism %s` on %s.\nUse with `grouse --ignore-rules %s`.",
+		ref, time.Now().Format("2006-01-02"), rulesPath)
+	if err := rulesFor(unstable).writeTo(f, comment); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
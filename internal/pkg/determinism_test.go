@@ -0,0 +1,45 @@
+package pkg
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/capnfabs/grouse/internal/git"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestVaryingLinePattern(t *testing.T) {
+	c := qt.New(t)
+	testCases := []struct {
+		a, b    string
+		pattern string
+	}{
+		{"<p>Built at 10:01</p>", "<p>Built at 10:02</p>", `^<p>Built at 10:.*</p>$`},
+		{`<a href="/posts/one/">One</a>`, `<a href="/posts/two/">Two</a>`, `^<a href="/posts/.*</a>$`},
+		{`  "buildTime": 1600000000,`, `  "buildTime": 1600000123,`, `^  "buildTime": .*,$`},
+	}
+	for _, tc := range testCases {
+		pattern, ok := varyingLinePattern(tc.a, tc.b)
+		c.Assert(ok, qt.Equals, true, qt.Commentf("%q vs %q", tc.a, tc.b))
+		c.Check(pattern, qt.Equals, tc.pattern)
+		c.Check(regexp.MustCompile(pattern).MatchString(tc.a), qt.Equals, true)
+		c.Check(regexp.MustCompile(pattern).MatchString(tc.b), qt.Equals, true)
+	}
+
+	// Not enough in common to be worth masking.
+	_, ok := varyingLinePattern("<li>apple</li>", "<b>pear</b>")
+	c.Check(ok, qt.Equals, false)
+}
+
+func TestVaryingLinePatternsNeedMatchingLines(t *testing.T) {
+	c := qt.New(t)
+	_, ok := varyingLinePatterns(git.LineChange{Removed: []string{"<p>one</p>"}})
+	c.Check(ok, qt.Equals, false)
+
+	patterns, ok := varyingLinePatterns(git.LineChange{
+		Removed: []string{"<p>Built at 1</p>", "<p>Built at 2</p>"},
+		Added:   []string{"<p>Built at 3</p>", "<p>Built at 4</p>"},
+	})
+	c.Check(ok, qt.Equals, true)
+	c.Check(patterns, qt.DeepEquals, []string{`^<p>Built at .*</p>$`})
+}
@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"context"
+	"os"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// RunDirsCommand compares two sites which have already been built, e.g. by CI,
+// without needing the source, a git repo or Hugo.
+func RunDirsCommand(cmd *cobra.Command) {
+	userArgs, err := parseDirsArgs(cmd.Flags())
+	if err != nil {
+		out.Outln("Error:", err)
+		cmd.Usage()
+		os.Exit(1)
+	}
+	out.Reinit(userArgs.debug)
+
+	runToCompletion(*userArgs, func(ctx context.Context) error {
+		return runDirs(ctx, git.NewGit(ctx), *userArgs)
+	})
+}
+
+func runDirs(ctx context.Context, git_ git.Git, userArgs cmdArgs) error {
+	out.Outf("Computing diff between %s and %s\n", userArgs.sides[0], userArgs.sides[1])
+
+	build, err := newBuild(ctx, git_, userArgs.keepWorktree)
+	if err != nil {
+		return err
+	}
+	defer build.Close()
+
+	filters := newOutputFilters(userArgs.filters, userArgs.ignoreRules, build.ScratchDir, os.Stderr)
+	imported := []BuiltRevision{}
+	for _, side := range userArgs.sides {
+		out.Outf("Importing %s…\n", side)
+		revision, err := withImportEvents(ctx, side, func() (BuiltRevision, error) {
+			return importDirectoryOrArchive(ctx, build.OutputRepo, side, build.ScratchDir, filters)
+		})
+		if err != nil {
+			return errors.WithMessagef(err, "Couldn't import %s", side)
+		}
+		imported = append(imported, revision)
+	}
+	build.Base = imported[0]
+	build.Revisions = imported[1:]
+
+	return compareTwoRevisions(ctx, build.OutputRepo, build.Base, build.Revisions[0], userArgs)
+}
@@ -0,0 +1,353 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime/debug"
+	"time"
+
+	"github.com/capnfabs/grouse/internal/events"
+	"github.com/capnfabs/grouse/internal/exec"
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+	"github.com/pkg/errors"
+)
+
+// BuildOptions says which revisions to build, and how.
+type BuildOptions struct {
+	// A directory inside the Hugo site; Hugo runs in the same place relative
+	// to the root of the repo for every revision.
+	RepoDir string
+	// The commits to build. The first is the base that the others get
+	// compared to, unless AgainstDir is set.
+	Commits []string
+	// Extra arguments for hugo.
+	BuildArgs []string
+	// One of auto, worktree, clone or export; see sourceMode.
+	SourceMode string
+	// Only check out the Hugo root and SparsePaths (relative to the root of
+	// the repo).
+	Sparse      bool
+	SparsePaths []string
+	// If set, the contents of this directory are the base, and every commit
+	// gets compared to it.
+	AgainstDir string
+	// Shell commands to run before each build in the Hugo site's directory,
+	// and after each build in the output directory. See hookEnv for the
+	// environment variables that they get.
+	PreBuildHook  string
+	PostBuildHook string
+	// Filters to run over each output tree before diffing it; see
+	// OutputFilter.
+	Filters []OutputFilter
+	// Differences to hide, e.g. ones found by check-determinism; see
+	// ignoreRules.
+	IgnoreRules *ignoreRules
+	// Check out every revision into its own, new source directory, rather
+	// than reusing one. It's slower, but nothing from one build (e.g. Hugo's
+	// resources cache) can leak into the next.
+	FreshSource bool
+	// Read Hugo's configuration for each revision, into BuiltRevision.Config.
+	ReadConfig bool
+	// List the content of each revision, into BuiltRevision.Content.
+	ReadContent bool
+	// Work out which source files changed since the base revision, into
+	// BuiltRevision.SourceChanges.
+	SourceChanges bool
+	// Keep the scratch directory around after Close, for debugging.
+	KeepScratchDir bool
+	// Where hugo's output goes.
+	BuildOutput io.Writer
+}
+
+// Build is the output of every revision, committed to a repository in a
+// scratch directory. Close it when you're done with it.
+type Build struct {
+	OutputRepo git.WriteableRepository
+	Base       BuiltRevision
+	Revisions  []BuiltRevision
+	ScratchDir string
+
+	keepScratchDir bool
+	log            *out.Logger
+	srcWorktrees   []git.WorktreeRepository
+	// What's needed to build the user's working copy too; see
+	// buildWorkingCopy.
+	repo          git.Repository
+	settings      buildSettings
+	sourceChanges bool
+}
+
+// Close removes the scratch directory, unless the options said to keep it.
+func (b *Build) Close() error {
+	if b.keepScratchDir {
+		// Keep everything so you can inspect it later.
+		b.log.Outf("Keeping intermediary files in %s\n", b.ScratchDir)
+		return nil
+	}
+	var err error
+	for _, srcWorktree := range b.srcWorktrees {
+		if removeErr := srcWorktree.Remove(); err == nil {
+			err = removeErr
+		}
+	}
+	if removeErr := os.RemoveAll(b.ScratchDir); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// newBuild creates a scratch directory with an empty output repo inside it,
+// for revisions to be committed to.
+func newBuild(ctx context.Context, git_ git.Git, keepScratchDir bool) (*Build, error) {
+	scratchDir, err := newScratchDir()
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't create a scratch directory")
+	}
+	build := &Build{ScratchDir: scratchDir, keepScratchDir: keepScratchDir, log: out.FromContext(ctx)}
+
+	outputDir := path.Join(scratchDir, "output")
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		build.Close()
+		return nil, err
+	}
+	outputRepo, err := git_.NewRepository(outputDir)
+	if err != nil {
+		build.Close()
+		return nil, errors.WithMessage(err, "Couldn't create the output repository")
+	}
+	build.OutputRepo = outputRepo
+	return build, nil
+}
+
+// BuildRevisions builds (or imports) every revision in opts and commits the
+// output of each one to a fresh output repo. If something goes wrong that
+// grouse didn't expect, it returns an error instead of panicking, because
+// programs that embed grouse (see grouse.Compare) build through here too.
+func BuildRevisions(ctx context.Context, git_ git.Git, opts BuildOptions) (build *Build, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			out.FromContext(ctx).Debugf("Panic: %v\n%s", r, debug.Stack())
+			build = nil
+			err = fmt.Errorf("Internal error: %v", r)
+		}
+	}()
+	return buildRevisions(ctx, git_, opts)
+}
+
+func buildRevisions(ctx context.Context, git_ git.Git, opts BuildOptions) (build *Build, err error) {
+	log := out.FromContext(ctx)
+	mode, err := parseSourceMode(opts.SourceMode)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BuildOutput == nil {
+		opts.BuildOutput = os.Stderr
+	}
+
+	repo, err := git_.OpenRepository(opts.RepoDir)
+
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Couldn't load the git repo in %s", opts.RepoDir)
+	}
+
+	relativeRoot, err := git_.GetRelativeLocation(opts.RepoDir)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Couldn't find %s in the git repo", opts.RepoDir)
+	}
+
+	log.Debugf("Got repo location %#v and relative path %#v\n", repo.RootDir(), relativeRoot)
+
+	refs := []git.ResolvedUserRef{}
+
+	for _, commit := range opts.Commits {
+		ref, err := repo.ResolveCommit(commit)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "Couldn't resolve '%s' as git commit", commit)
+		}
+		events.Emit(ctx, events.Event{Type: events.RefResolved, Ref: commit, Commit: string(ref.Commit().Hash())})
+		refs = append(refs, ref)
+	}
+
+	// check-determinism builds one revision over and over, each from a fresh
+	// source directory. Asking for the same revision twice otherwise (e.g.
+	// `grouse HEAD HEAD`) is still an ordinary comparison.
+	repeated := opts.FreshSource && len(refs) > 1 && sameCommit(refs)
+	if repeated {
+		log.Outf("Building revision %s %d times\n", refs[0], len(refs))
+	} else if opts.AgainstDir != "" && len(refs) == 1 {
+		log.Outf("Comparing revision %s against the contents of %s\n", refs[0], opts.AgainstDir)
+	} else if opts.AgainstDir != "" {
+		log.Outf("Comparing %d revisions against the contents of %s\n", len(refs), opts.AgainstDir)
+	} else if len(refs) == 1 {
+		// There's nothing to compare it to yet; see buildWorkingCopy.
+	} else if len(refs) == 2 {
+		log.Outf("Computing diff between revisions %s and %s\n", refs[0], refs[1])
+	} else {
+		log.Outf("Comparing %d revisions against %s\n", len(refs)-1, refs[0])
+	}
+
+	build, err = newBuild(ctx, git_, opts.KeepScratchDir)
+	if err != nil {
+		return nil, err
+	}
+	build.repo = repo
+	build.sourceChanges = opts.SourceChanges
+	scratchDir := build.ScratchDir
+	outputRepo := build.OutputRepo
+	// Callers only get the Build if everything worked, so clean up here
+	// otherwise.
+	defer func() {
+		if r := recover(); r != nil {
+			build.Close()
+			panic(r)
+		}
+		if err != nil && build != nil {
+			build.Close()
+			build = nil
+		}
+	}()
+
+	autoMode := mode == sourceModeAuto
+	mode = chooseSourceMode(ctx, git_, repo, refs, mode)
+	prepareSource := func(dir string) (git.WorktreeRepository, error) {
+		for {
+			prepareEvent := events.Event{Type: events.SourcePrepareStarted, SourceMode: string(mode)}
+			events.Emit(ctx, prepareEvent)
+			start := time.Now()
+			dst := path.Join(scratchDir, dir)
+			srcWorktree, err := materializeSource(ctx, repo, mode, dst, sparsePaths(ctx, opts, relativeRoot))
+			prepareEvent.Type = events.SourcePrepareFinished
+			events.Emit(ctx, prepareEvent.Finish(start, err))
+			if err := interrupted(ctx, err); err != nil {
+				return nil, err
+			}
+			if err == nil {
+				build.srcWorktrees = append(build.srcWorktrees, srcWorktree)
+				return srcWorktree, nil
+			}
+			next, ok := fallbackSourceMode(mode)
+			if !autoMode || !ok {
+				return nil, errors.WithMessagef(err, "Couldn't prepare a directory for the source using source mode %s", mode)
+			}
+			log.Outf("Couldn't prepare a directory for the source using source mode %s (%v); trying %s instead.\n", mode, err, next)
+			if err := os.RemoveAll(dst); err != nil {
+				return nil, err
+			}
+			mode = next
+		}
+	}
+	srcWorktree, err := prepareSource("src")
+	if err != nil {
+		return build, err
+	}
+
+	filters := newOutputFilters(opts.Filters, opts.IgnoreRules, scratchDir, opts.BuildOutput)
+
+	var againstDir *BuiltRevision
+	if opts.AgainstDir != "" {
+		log.Outf("Importing %s…\n", opts.AgainstDir)
+		imported, err := withImportEvents(ctx, opts.AgainstDir, func() (BuiltRevision, error) {
+			return importDirectory(ctx, outputRepo, opts.AgainstDir, opts.AgainstDir, filters)
+		})
+		if err != nil {
+			return build, errors.WithMessagef(err, "Couldn't import %s", opts.AgainstDir)
+		}
+		againstDir = &imported
+	}
+
+	built := []BuiltRevision{}
+
+	settings := buildSettings{
+		hugoRelativeRoot: relativeRoot,
+		buildArgs:        opts.BuildArgs,
+		hooks:            buildHooks{preBuild: opts.PreBuildHook, postBuild: opts.PostBuildHook},
+		filters:          filters,
+		readConfig:       opts.ReadConfig,
+		readContent:      opts.ReadContent,
+		output:           opts.BuildOutput,
+	}
+	build.settings = settings
+	for i, ref := range refs {
+		// Hooks see the base as side "a", and everything compared to it as
+		// side "b".
+		side := "b"
+		if i == 0 && againstDir == nil {
+			side = "a"
+		}
+
+		if opts.FreshSource && i > 0 {
+			srcWorktree, err = prepareSource(fmt.Sprintf("src-%d", i))
+			if err != nil {
+				return build, err
+			}
+		}
+
+		// Make sure the output directory is empty
+		if err := outputRepo.ClearSourceControlledFilesFromWorktree(); err != nil {
+			return build, err
+		}
+
+		if repeated {
+			log.Outf("Building revision %s (%d of %d)…\n", ref, i+1, len(refs))
+		} else {
+			log.Outf("Building revision %s…\n", ref)
+		}
+		revision, err := processSourceAtCommit(ctx, srcWorktree, ref, side, settings, outputRepo)
+
+		if err := interrupted(ctx, err); err != nil {
+			return build, err
+		}
+		switch err.(type) {
+		case *exec.ExitError:
+			err := errors.Wrapf(err, "Building at commit %s failed", ref)
+			return build, err
+		case *hookError, *filterError:
+			return build, errors.WithMessagef(err, "Building at commit %s failed", ref)
+		case *git.MissingLFSObjectsError:
+			return build, errors.WithMessagef(err, "Couldn't check out %s", ref)
+		case error:
+			return build, errors.WithMessagef(err, "Couldn't build %s", ref)
+		}
+		revision.SourceDir = srcWorktree.RootDir()
+		if mode == sourceModeExport {
+			// Exported source doesn't have any git metadata to diff with.
+			revision.SourceDir = repo.RootDir()
+		}
+		revision.SiteDir = relativeRoot
+		built = append(built, revision)
+	}
+
+	if againstDir != nil {
+		build.Base = *againstDir
+		build.Revisions = built
+	} else {
+		build.Base = built[0]
+		build.Revisions = built[1:]
+	}
+
+	if opts.SourceChanges && build.Base.Source != git.NilHash {
+		for i, revision := range build.Revisions {
+			changes, err := sourceChangesBetween(ctx, git_, repo, relativeRoot, build.Base.Source, revision.Source)
+			if err != nil {
+				log.Outf("Couldn't work out which source files changed between %s and %s: %v\n", build.Base, revision, err)
+				continue
+			}
+			build.Revisions[i].SourceChanges = changes
+		}
+	}
+	return build, nil
+}
+
+// sameCommit reports whether every ref points at the same commit.
+func sameCommit(refs []git.ResolvedUserRef) bool {
+	for _, ref := range refs[1:] {
+		if ref.Commit().Hash() != refs[0].Commit().Hash() {
+			return false
+		}
+	}
+	return true
+}
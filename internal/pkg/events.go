@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/events"
+)
+
+// openEventsDestination parses the value of --events-to, which is "stdout",
+// "stderr", "fd:N" for an inherited file descriptor, or a file path.
+func openEventsDestination(dest string) (io.WriteCloser, error) {
+	switch {
+	case dest == "stderr":
+		return nopCloser{os.Stderr}, nil
+	case dest == "stdout":
+		return nopCloser{os.Stdout}, nil
+	case strings.HasPrefix(dest, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(dest, "fd:"))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("Invalid file descriptor in --events-to=%s", dest)
+		}
+		return os.NewFile(uintptr(fd), dest), nil
+	default:
+		return os.Create(dest)
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// initEvents sets up the event stream that the user asked for, if any. It
+// returns a copy of ctx that sends events there, and a function to call when
+// grouse is done with it.
+func initEvents(ctx context.Context, userArgs cmdArgs) (context.Context, func(), error) {
+	if userArgs.eventsFormat == "" {
+		return ctx, func() {}, nil
+	}
+	w, err := openEventsDestination(userArgs.eventsTo)
+	if err != nil {
+		return nil, nil, err
+	}
+	return events.WithSink(ctx, events.NewSink(w)), func() { w.Close() }, nil
+}
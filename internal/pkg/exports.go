@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+
+	"github.com/capnfabs/grouse/internal/exec"
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
+)
+
+// exportOutputs saves whatever the user asked for with --export-a,
+// --export-b and --export-patch. revision is nil if there's more than one
+// revision being compared to the base, in which case only the base can be
+// exported. Exports are of the output as it was built, before any filters.
+func exportOutputs(ctx context.Context, repoDir string, base BuiltRevision, revision *BuiltRevision, userArgs cmdArgs) error {
+	if userArgs.exportA != "" {
+		if err := exportArchive(ctx, repoDir, base.Raw, userArgs.exportA); err != nil {
+			return errors.WithMessagef(err, "Couldn't export %s to %s", base.Name, userArgs.exportA)
+		}
+		out.Outf("Saved the output of %s to %s\n", base, userArgs.exportA)
+	}
+	if revision == nil {
+		return nil
+	}
+	if userArgs.exportB != "" {
+		if err := exportArchive(ctx, repoDir, revision.Raw, userArgs.exportB); err != nil {
+			return errors.WithMessagef(err, "Couldn't export %s to %s", revision.Name, userArgs.exportB)
+		}
+		out.Outf("Saved the output of %s to %s\n", revision, userArgs.exportB)
+	}
+	if userArgs.exportPatch != "" {
+		if err := exportPatch(ctx, repoDir, base.Raw, revision.Raw, userArgs.exportPatch); err != nil {
+			return errors.WithMessagef(err, "Couldn't save the diff to %s", userArgs.exportPatch)
+		}
+		out.Outf("Saved the diff to %s\n", userArgs.exportPatch)
+	}
+	return nil
+}
+
+// exportArchive writes the tree for commit to an archive at dst; the format
+// comes from the extension of dst.
+func exportArchive(ctx context.Context, repoDir string, commit git.Hash, dst string) error {
+	format := archiveFormatOf(dst)
+	gitFormat := "tar"
+	if format == formatZip {
+		gitFormat = "zip"
+	}
+	return writeGitOutput(ctx, repoDir, dst, format == formatTarGz, "archive", "--format="+gitFormat, string(commit))
+}
+
+// exportPatch writes the diff between the two commits to dst, in a form that
+// `git apply` understands, including binary files.
+func exportPatch(ctx context.Context, repoDir string, from, to git.Hash, dst string) error {
+	return writeGitOutput(ctx, repoDir, dst, false, "diff", "--binary", string(from), string(to))
+}
+
+// writeGitOutput runs a git command in repoDir and writes its output to dst,
+// optionally gzipped.
+func writeGitOutput(ctx context.Context, repoDir string, dst string, gzipped bool, args ...string) (err error) {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if gzipped {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+
+	cmd := exec.Command(ctx, "git", args...)
+	cmd.Dir = repoDir
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	out.Debugf("Running command %s > %s\n", shellquote.Join(cmd.Args...), dst)
+	if err := exec.Run(cmd); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/exec"
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+)
+
+// OutputFilter turns output files matching Glob into something that diffs
+// better, like gitattributes' textconv. Command gets run with the system
+// shell; it reads the original file on stdin and writes the replacement to
+// stdout.
+type OutputFilter struct {
+	Glob    string
+	Command string
+}
+
+// parseOutputFilter parses a filter from the command line, in the form
+// "<glob>: <command>", e.g. "*.pdf: pdftotext - -".
+func parseOutputFilter(spec string) (OutputFilter, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return OutputFilter{}, fmt.Errorf("Filters look like '<glob>: <command>', but got '%s'", spec)
+	}
+	filter := OutputFilter{Glob: strings.TrimSpace(parts[0]), Command: strings.TrimSpace(parts[1])}
+	if filter.Glob == "" || filter.Command == "" {
+		return OutputFilter{}, fmt.Errorf("Filters look like '<glob>: <command>', but got '%s'", spec)
+	}
+	if _, err := path.Match(filter.Glob, ""); err != nil {
+		return OutputFilter{}, fmt.Errorf("The filter glob '%s' isn't valid: %v", filter.Glob, err)
+	}
+	return filter, nil
+}
+
+// matches reports whether filter applies to the file at relPath (relative to
+// the root of the output, with forward slashes).
+func (f OutputFilter) matches(relPath string) bool {
+	return globMatches(f.Glob, relPath)
+}
+
+// globMatches reports whether glob matches the file at relPath (relative to
+// the root of the output, with forward slashes). Like in .gitattributes, a
+// glob without a slash matches the file's name in any directory.
+func globMatches(glob string, relPath string) bool {
+	name := relPath
+	if !strings.Contains(glob, "/") {
+		name = path.Base(relPath)
+	}
+	matched, _ := path.Match(strings.TrimPrefix(glob, "/"), name)
+	return matched
+}
+
+// filterError means that a filter command failed on a particular file.
+type filterError struct {
+	filter OutputFilter
+	path   string
+	err    error
+}
+
+func (e *filterError) Error() string {
+	return fmt.Sprintf("The filter for %s failed on %s: %v", e.filter.Glob, e.path, e.err)
+}
+
+// outputFilters runs filters over each output tree before it gets diffed.
+// Filter output is cached by the filter command and the content of the
+// input file, so files which are the same in several revisions only get
+// filtered once.
+type outputFilters struct {
+	rules    *ignoreRules
+	filters  []OutputFilter
+	cacheDir string
+	// Where the filters' stderr goes.
+	output io.Writer
+}
+
+// newOutputFilters returns nil if there aren't any filters or rules, which
+// is fine to call commitOutput on. rules get applied before filters.
+func newOutputFilters(filters []OutputFilter, rules *ignoreRules, scratchDir string, output io.Writer) *outputFilters {
+	if len(filters) == 0 && rules.empty() {
+		return nil
+	}
+	return &outputFilters{rules: rules, filters: filters, cacheDir: path.Join(scratchDir, "filter-cache"), output: output}
+}
+
+// commitOutput commits everything in the output repo's worktree, and then,
+// if there are any filters, commits the filtered version on top of it. raw
+// is the output as-is, and filtered is what should get diffed; they're the
+// same if there aren't any filters.
+func (f *outputFilters) commitOutput(ctx context.Context, outputRepo git.WriteableRepository, message string) (raw git.Hash, filtered git.Hash, err error) {
+	raw, err = outputRepo.CommitEverythingInWorktree(message)
+	if err != nil || f == nil {
+		return raw, raw, err
+	}
+	if err := f.apply(ctx, outputRepo.RootDir()); err != nil {
+		return git.NilHash, git.NilHash, err
+	}
+	filtered, err = outputRepo.CommitEverythingInWorktree(message + " (filtered)")
+	return raw, filtered, err
+}
+
+// apply removes or masks every file in dir that the ignore rules say to, and
+// then replaces every file that matches a filter with the filter's output.
+// The first filter that matches a file wins.
+func (f *outputFilters) apply(ctx context.Context, dir string) error {
+	if err := os.MkdirAll(f.cacheDir, os.ModePerm); err != nil {
+		return err
+	}
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == ".git" && info.IsDir() {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if f.rules.ignores(rel) {
+			return os.Remove(filePath)
+		}
+		if err := f.rules.mask(filePath, rel); err != nil {
+			return err
+		}
+		for _, filter := range f.filters {
+			if filter.matches(rel) {
+				return f.filterFile(ctx, filter, filePath, rel, info.Mode().Perm())
+			}
+		}
+		return nil
+	})
+}
+
+func (f *outputFilters) filterFile(ctx context.Context, filter OutputFilter, filePath string, rel string, mode os.FileMode) error {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	key := sha256.New()
+	io.WriteString(key, filter.Command)
+	key.Write([]byte{0})
+	key.Write(content)
+	cachePath := path.Join(f.cacheDir, hex.EncodeToString(key.Sum(nil)))
+
+	filtered, err := ioutil.ReadFile(cachePath)
+	if os.IsNotExist(err) {
+		out.FromContext(ctx).Debugf("Filtering %s with '%s'\n", rel, filter.Command)
+		var stdout bytes.Buffer
+		cmd := shellCommand(ctx, filter.Command)
+		cmd.Dir = filepath.Dir(filePath)
+		cmd.Stdin = bytes.NewReader(content)
+		cmd.Stdout = &stdout
+		cmd.Stderr = f.output
+		if err := exec.Run(cmd); err != nil {
+			return &filterError{filter: filter, path: rel, err: err}
+		}
+		filtered = stdout.Bytes()
+		err = ioutil.WriteFile(cachePath, filtered, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, filtered, mode)
+}
@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/capnfabs/grouse/internal/exec"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestParseOutputFilter(t *testing.T) {
+	c := qt.New(t)
+	filter, err := parseOutputFilter("*.pdf: pdftotext - -")
+	c.Assert(err, qt.IsNil)
+	c.Check(filter.Glob, qt.Equals, "*.pdf")
+	c.Check(filter.Command, qt.Equals, "pdftotext - -")
+
+	for _, spec := range []string{"*.pdf", "*.pdf:", ": cat", "[.pdf: cat"} {
+		_, err := parseOutputFilter(spec)
+		c.Check(err, qt.Not(qt.IsNil), qt.Commentf("spec %q", spec))
+	}
+}
+
+func TestOutputFilterMatches(t *testing.T) {
+	c := qt.New(t)
+	testCases := []struct {
+		glob    string
+		path    string
+		matches bool
+	}{
+		{"*.pdf", "report.pdf", true},
+		{"*.pdf", "docs/2020/report.pdf", true},
+		{"*.pdf", "report.pdf.html", false},
+		{"js/*.min.js", "js/vendor.min.js", true},
+		{"js/*.min.js", "other/js/vendor.min.js", false},
+		{"/calendar.ics", "calendar.ics", true},
+	}
+	for _, tc := range testCases {
+		c.Check(OutputFilter{Glob: tc.glob}.matches(tc.path), qt.Equals, tc.matches, qt.Commentf("%s on %s", tc.glob, tc.path))
+	}
+}
+
+func TestOutputFiltersCacheByContent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs a POSIX shell")
+	}
+	c := qt.New(t)
+	scratchDir, err := ioutil.TempDir("", "grouse-filters-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(scratchDir)
+
+	runs := 0
+	oldRun := exec.Run
+	defer func() { exec.Run = oldRun }()
+	exec.Run = func(cmd *exec.Cmd) error {
+		runs++
+		return oldRun(cmd)
+	}
+
+	filters := newOutputFilters([]OutputFilter{{Glob: "*.txt", Command: "tr a-z A-Z"}}, nil, scratchDir, ioutil.Discard)
+	for _, side := range []string{"a", "b"} {
+		dir := filepath.Join(scratchDir, side)
+		c.Assert(os.MkdirAll(filepath.Join(dir, "sub"), os.ModePerm), qt.IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, "sub", "same.txt"), []byte("unchanged"), 0644), qt.IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, "different.txt"), []byte("side "+side), 0644), qt.IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("html"), 0644), qt.IsNil)
+		c.Assert(filters.apply(context.Background(), dir), qt.IsNil)
+	}
+
+	// same.txt only gets filtered the first time.
+	c.Check(runs, qt.Equals, 3)
+	for path, expected := range map[string]string{
+		"a/sub/same.txt":  "UNCHANGED",
+		"b/sub/same.txt":  "UNCHANGED",
+		"a/different.txt": "SIDE A",
+		"b/different.txt": "SIDE B",
+		"b/index.html":    "html",
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(scratchDir, path))
+		c.Assert(err, qt.IsNil)
+		c.Check(string(content), qt.Equals, expected, qt.Commentf("%s", path))
+	}
+}
+
+func TestOutputFilterFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs a POSIX shell")
+	}
+	c := qt.New(t)
+	scratchDir, err := ioutil.TempDir("", "grouse-filters-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(scratchDir)
+
+	dir := filepath.Join(scratchDir, "output")
+	c.Assert(os.MkdirAll(dir, os.ModePerm), qt.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "broken.pdf"), []byte("%PDF"), 0644), qt.IsNil)
+
+	filters := newOutputFilters([]OutputFilter{{Glob: "*.pdf", Command: "exit 4"}}, nil, scratchDir, ioutil.Discard)
+	err = filters.apply(context.Background(), dir)
+	c.Check(err, qt.ErrorMatches, "The filter for \\*.pdf failed on broken.pdf: exit status 4")
+}
@@ -0,0 +1,402 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+)
+
+// outputFormat is one of Hugo's output formats, i.e. one of the kinds of file
+// it writes for each page.
+type outputFormat struct {
+	name     string
+	baseName string
+	// Where the files go, relative to the root of the site (or a language),
+	// if they don't go next to the HTML.
+	path   string
+	suffix string
+}
+
+// otherFormat is what files which aren't in any output format (e.g. images,
+// and everything else from static/) count as.
+const otherFormat = "other"
+
+// The output formats that Hugo always knows about. HTML comes before the
+// other formats with the same suffix, so that it wins when only the suffix
+// matches.
+var builtinOutputFormats = []outputFormat{
+	{name: "HTML", baseName: "index", suffix: "html"},
+	{name: "AMP", baseName: "index", path: "amp", suffix: "html"},
+	{name: "Calendar", baseName: "index", suffix: "ics"},
+	{name: "CSS", baseName: "styles", suffix: "css"},
+	{name: "CSV", baseName: "index", suffix: "csv"},
+	{name: "JSON", baseName: "index", suffix: "json"},
+	{name: "ROBOTS", baseName: "robots", suffix: "txt"},
+	{name: "RSS", baseName: "index", suffix: "xml"},
+	{name: "Sitemap", baseName: "sitemap", suffix: "xml"},
+	{name: "WebAppManifest", baseName: "manifest", suffix: "webmanifest"},
+}
+
+// The suffixes of Hugo's built-in media types, for output formats which are
+// configured with one of them.
+var mediaTypeSuffixes = map[string]string{
+	"application/javascript":    "js",
+	"application/json":          "json",
+	"application/manifest+json": "webmanifest",
+	"application/rss+xml":       "xml",
+	"application/xml":           "xml",
+	"text/calendar":             "ics",
+	"text/css":                  "css",
+	"text/csv":                  "csv",
+	"text/html":                 "html",
+	"text/markdown":             "md",
+	"text/plain":                "txt",
+	"text/xml":                  "xml",
+}
+
+// outputFormatsOf returns Hugo's built-in output formats, with the changes
+// and additions from `outputFormats` and `mediaTypes` in config.
+func outputFormatsOf(config hugoConfig) []outputFormat {
+	suffixes := map[string]string{}
+	for mediaType, suffix := range mediaTypeSuffixes {
+		suffixes[mediaType] = suffix
+	}
+	for key, value := range config {
+		if !strings.HasPrefix(key, "mediatypes.") {
+			continue
+		}
+		// Media types have dots in them, e.g. application/vnd.api+json, so
+		// the key can't just be split on them.
+		mediaType := strings.TrimPrefix(key, "mediatypes.")
+		var list []string
+		if strings.HasSuffix(mediaType, ".suffixes") && json.Unmarshal([]byte(value), &list) == nil && len(list) > 0 {
+			suffixes[strings.TrimSuffix(mediaType, ".suffixes")] = list[0]
+		} else if strings.HasSuffix(mediaType, ".suffix") {
+			var suffix string
+			if json.Unmarshal([]byte(value), &suffix) == nil {
+				suffixes[strings.TrimSuffix(mediaType, ".suffix")] = suffix
+			}
+		}
+	}
+
+	formats := append([]outputFormat{}, builtinOutputFormats...)
+	keys := []string{}
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts := strings.SplitN(key, ".", 3)
+		if len(parts) != 3 || parts[0] != "outputformats" {
+			continue
+		}
+		var value string
+		if json.Unmarshal([]byte(config[key]), &value) != nil {
+			continue
+		}
+		i := 0
+		for i < len(formats) && !strings.EqualFold(formats[i].name, parts[1]) {
+			i++
+		}
+		if i == len(formats) {
+			formats = append(formats, outputFormat{name: parts[1], baseName: "index"})
+		}
+		switch parts[2] {
+		case "basename":
+			formats[i].baseName = value
+		case "path":
+			formats[i].path = strings.Trim(value, "/")
+		case "mediatype":
+			if suffix, ok := suffixes[strings.ToLower(value)]; ok {
+				formats[i].suffix = suffix
+			}
+		case "suffix":
+			formats[i].suffix = value
+		}
+	}
+	return formats
+}
+
+// mergeOutputFormats returns the formats in a, and the ones in b which have a
+// different name.
+func mergeOutputFormats(a, b []outputFormat) []outputFormat {
+	merged := append([]outputFormat{}, a...)
+	for _, format := range b {
+		found := false
+		for _, existing := range a {
+			if strings.EqualFold(existing.name, format.name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, format)
+		}
+	}
+	return merged
+}
+
+// formatOf returns the name of the output format of the file at relPath, or
+// otherFormat. It goes by the file's name; pages with names that don't match
+// any format (e.g. with uglyURLs) count as HTML if they have its suffix.
+func formatOf(formats []outputFormat, relPath string) string {
+	dir, file := path.Split(relPath)
+	suffix := strings.TrimPrefix(path.Ext(file), ".")
+	baseName := strings.TrimSuffix(file, path.Ext(file))
+	dirs := strings.Split(dir, "/")
+
+	match := ""
+	for _, format := range formats {
+		if format.suffix != suffix || format.baseName != baseName {
+			continue
+		}
+		if format.path == "" {
+			if match == "" {
+				match = format.name
+			}
+			continue
+		}
+		// Formats with a path (e.g. amp/) are also under the language's
+		// directory, for multilingual sites.
+		if dirs[0] == format.path || (len(dirs) > 1 && dirs[1] == format.path) {
+			return format.name
+		}
+	}
+	if match != "" {
+		return match
+	}
+	for _, format := range formats {
+		if format.suffix == "html" && suffix == "html" && format.path == "" {
+			return format.name
+		}
+	}
+	return otherFormat
+}
+
+// formatNames returns the names of formats, and otherFormat.
+func formatNames(formats []outputFormat) []string {
+	names := []string{}
+	for _, format := range formats {
+		names = append(names, format.name)
+	}
+	return append(names, otherFormat)
+}
+
+// printFormatBreakdown shows how many output files changed in each output
+// format.
+func printFormatBreakdown(formats []outputFormat, changes []git.FileChange) {
+	if len(changes) == 0 {
+		return
+	}
+	byFormat := map[string]map[git.ChangeStatus]int{}
+	for _, change := range changes {
+		name := formatOf(formats, change.Path)
+		if byFormat[name] == nil {
+			byFormat[name] = map[git.ChangeStatus]int{}
+		}
+		byFormat[name][change.Status]++
+	}
+	out.Outln("Changes by output format:")
+	for _, name := range formatNames(formats) {
+		counts, ok := byFormat[name]
+		if !ok {
+			continue
+		}
+		out.Outf("  %s: %d files changed (%d added, %d deleted, %d modified)\n",
+			name, counts[git.Added]+counts[git.Deleted]+counts[git.Modified]+counts[git.TypeChanged],
+			counts[git.Added], counts[git.Deleted], counts[git.Modified]+counts[git.TypeChanged])
+	}
+}
+
+// formatPattern matches the files with names that match name (a glob),
+// either anywhere, or if dir is set, anywhere under that directory at the
+// root of the site or of a language.
+type formatPattern struct {
+	dir  string
+	name string
+}
+
+func (p formatPattern) matches(relPath string) bool {
+	if ok, _ := path.Match(p.name, path.Base(relPath)); !ok {
+		return false
+	}
+	if p.dir == "" {
+		return true
+	}
+	dirs := strings.Split(relPath, "/")
+	return (len(dirs) > 1 && dirs[0] == p.dir) || (len(dirs) > 2 && dirs[1] == p.dir)
+}
+
+// pathspecs returns git pathspecs that match the same files as p, with magic
+// (e.g. "glob" or "glob,exclude").
+func (p formatPattern) pathspecs(magic string) []string {
+	if p.dir == "" {
+		return []string{fmt.Sprintf(":(%s)**/%s", magic, p.name)}
+	}
+	dir := globLiteral(p.dir)
+	return []string{
+		fmt.Sprintf(":(%s)%s/**/%s", magic, dir, p.name),
+		fmt.Sprintf(":(%s)*/%s/**/%s", magic, dir, p.name),
+	}
+}
+
+// formatClaim says that the files which match pattern are in format, unless an
+// earlier claim matches them.
+type formatClaim struct {
+	format  string
+	pattern formatPattern
+}
+
+// formatClaims returns the claims that add up to formatOf: formats with a
+// path, then formats that go next to the HTML, then the fallback for other
+// HTML files, and finally otherFormat for everything else.
+func formatClaims(formats []outputFormat) []formatClaim {
+	claims := []formatClaim{}
+	seen := map[formatPattern]bool{}
+	add := func(format string, pattern formatPattern) {
+		if !seen[pattern] {
+			seen[pattern] = true
+			claims = append(claims, formatClaim{format, pattern})
+		}
+	}
+	fileName := func(format outputFormat) string {
+		if format.suffix == "" {
+			return globLiteral(format.baseName)
+		}
+		return globLiteral(format.baseName) + "." + globLiteral(format.suffix)
+	}
+	for _, format := range formats {
+		// formatOf only looks at one directory at a time.
+		if format.path != "" && !strings.Contains(format.path, "/") {
+			add(format.name, formatPattern{dir: format.path, name: fileName(format)})
+		}
+	}
+	for _, format := range formats {
+		if format.path == "" {
+			add(format.name, formatPattern{name: fileName(format)})
+		}
+	}
+	for _, format := range formats {
+		if format.suffix == "html" && format.path == "" {
+			add(format.name, formatPattern{name: "*.html"})
+			break
+		}
+	}
+	add(otherFormat, formatPattern{name: "*"})
+	return claims
+}
+
+// selectFormats returns the names of the selected output formats (compared
+// case-insensitively), or an error if one of them isn't one of formats.
+func selectFormats(formats []outputFormat, selected []string) (map[string]bool, error) {
+	names := formatNames(formats)
+	wanted := map[string]bool{}
+	for _, name := range selected {
+		found := false
+		for _, known := range names {
+			if strings.EqualFold(name, known) {
+				wanted[known] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown output format '%s'; this site has %s", name, strings.Join(names, ", "))
+		}
+	}
+	return wanted, nil
+}
+
+// pathsInFormats returns the paths of the changes which are in one of the
+// selected output formats, as for selectFormats.
+func pathsInFormats(formats []outputFormat, changes []git.FileChange, selected []string) ([]string, error) {
+	wanted, err := selectFormats(formats, selected)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, change := range changes {
+		if wanted[formatOf(formats, change.Path)] {
+			paths = append(paths, change.Path)
+		}
+	}
+	return paths, nil
+}
+
+// formatPathspecs returns git pathspecs for the files in the selected output
+// formats, as for selectFormats. If none of changes are in those formats,
+// there aren't any pathspecs.
+func formatPathspecs(formats []outputFormat, changes []git.FileChange, selected []string) ([]string, error) {
+	wanted, err := selectFormats(formats, selected)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each selected format gets a pathspec for its claims. Changed files that
+	// the formats which aren't selected claim first get excluded.
+	claims := formatClaims(formats)
+	last := 0
+	includes := []formatPattern{}
+	pathspecs := []string{}
+	for i, claim := range claims {
+		if wanted[claim.format] {
+			last = i
+			includes = append(includes, claim.pattern)
+			pathspecs = append(pathspecs, claim.pattern.pathspecs("glob")...)
+		}
+	}
+	excludes := []formatPattern{}
+	for _, claim := range claims[:last] {
+		if wanted[claim.format] {
+			continue
+		}
+		for _, change := range changes {
+			if claim.pattern.matches(change.Path) && matchesAny(includes, change.Path) {
+				excludes = append(excludes, claim.pattern)
+				pathspecs = append(pathspecs, claim.pattern.pathspecs("glob,exclude")...)
+				break
+			}
+		}
+	}
+
+	paths := []string{}
+	exact := true
+	for _, change := range changes {
+		inFormats := wanted[formatOf(formats, change.Path)]
+		if inFormats {
+			paths = append(paths, change.Path)
+		}
+		matched := matchesAny(includes, change.Path) && !matchesAny(excludes, change.Path)
+		if matched != inFormats {
+			exact = false
+		}
+	}
+	if len(paths) == 0 {
+		return []string{}, nil
+	}
+	if !exact {
+		// An exclusion applies to every pathspec, so e.g. AMP and other
+		// without HTML can't be told apart from HTML. Only these
+		// combinations list every path, which can be too long for a command
+		// line on big sites.
+		out.Debugln("Pathspecs for the selected output formats don't fit the changes; passing each path to git diff instead.")
+		pathspecs = []string{}
+		for _, p := range paths {
+			pathspecs = append(pathspecs, ":(literal)"+p)
+		}
+	}
+	return pathspecs, nil
+}
+
+func matchesAny(patterns []formatPattern, relPath string) bool {
+	for _, pattern := range patterns {
+		if pattern.matches(relPath) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/capnfabs/grouse/internal/exec"
+	"github.com/capnfabs/grouse/internal/git"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestOutputFormatsOf(t *testing.T) {
+	c := qt.New(t)
+	formats := outputFormatsOf(hugoConfig{
+		"outputformats.calendar.basename":                 `"events"`,
+		"outputformats.searchindex.basename":              `"search"`,
+		"outputformats.searchindex.mediatype":             `"application/vnd.search+json"`,
+		"outputformats.searchindex.isplaintext":           `true`,
+		"mediatypes.application/vnd.search+json.suffixes": `["sjson"]`,
+	})
+	c.Assert(formats, qt.HasLen, len(builtinOutputFormats)+1)
+	c.Check(formats[2], qt.Equals, outputFormat{name: "Calendar", baseName: "events", suffix: "ics"})
+	c.Check(formats[len(formats)-1], qt.Equals, outputFormat{name: "searchindex", baseName: "search", suffix: "sjson"})
+
+	formats = outputFormatsOf(nil)
+	c.Assert(formats, qt.HasLen, len(builtinOutputFormats))
+	for i, format := range formats {
+		c.Check(format, qt.Equals, builtinOutputFormats[i])
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	c := qt.New(t)
+	formats := append(outputFormatsOf(nil), outputFormat{name: "calendar", baseName: "calendar", suffix: "ics"})
+	for relPath, format := range map[string]string{
+		"index.html":                 "HTML",
+		"posts/hello/index.html":     "HTML",
+		"posts/hello.html":           "HTML",
+		"amp/posts/hello/index.html": "AMP",
+		"de/amp/index.html":          "AMP",
+		"index.xml":                  "RSS",
+		"posts/index.xml":            "RSS",
+		"sitemap.xml":                "Sitemap",
+		"index.json":                 "JSON",
+		"events/calendar.ics":        "calendar",
+		"robots.txt":                 "ROBOTS",
+		"images/cat.png":             otherFormat,
+		"css/main.css":               otherFormat,
+		"feed.xml":                   otherFormat,
+	} {
+		c.Check(formatOf(formats, relPath), qt.Equals, format, qt.Commentf("%s", relPath))
+	}
+}
+
+func TestPathsInFormats(t *testing.T) {
+	c := qt.New(t)
+	formats := outputFormatsOf(nil)
+	changes := []git.FileChange{
+		{Status: git.Modified, Path: "index.html"},
+		{Status: git.Added, Path: "index.json"},
+		{Status: git.Deleted, Path: "images/cat.png"},
+	}
+	paths, err := pathsInFormats(formats, changes, []string{"json", "Other"})
+	c.Assert(err, qt.IsNil)
+	c.Check(paths, qt.DeepEquals, []string{"index.json", "images/cat.png"})
+
+	paths, err = pathsInFormats(formats, changes, []string{"calendar"})
+	c.Assert(err, qt.IsNil)
+	c.Check(paths, qt.HasLen, 0)
+
+	_, err = pathsInFormats(formats, changes, []string{"jsno"})
+	c.Check(err, qt.ErrorMatches, `Unknown output format 'jsno'; this site has HTML, AMP, .*, other`)
+}
+
+func TestFormatPathspecs(t *testing.T) {
+	c := qt.New(t)
+	repoDir, err := ioutil.TempDir("", "grouse-formats-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(repoDir)
+
+	formats := append(outputFormatsOf(nil), outputFormat{name: "search", baseName: "search", suffix: "html"})
+	changes := []git.FileChange{}
+	for _, relPath := range []string{
+		"index.html", "posts/hello/index.html", "posts/hello.html", "amp/index.html",
+		"de/amp/posts/index.html", "de/search.html", "index.xml", "posts/index.xml",
+		"sitemap.xml", "robots.txt", "images/cat.png", "feed.xml", "weird [1]/index.html",
+	} {
+		filePath := filepath.Join(repoDir, filepath.FromSlash(relPath))
+		c.Assert(os.MkdirAll(filepath.Dir(filePath), os.ModePerm), qt.IsNil)
+		c.Assert(ioutil.WriteFile(filePath, []byte(relPath), 0644), qt.IsNil)
+		changes = append(changes, git.FileChange{Status: git.Added, Path: relPath})
+	}
+	ctx := context.Background()
+	c.Assert(exec.Exec(ctx, repoDir, "git", "init", "-q").Err, qt.IsNil)
+	c.Assert(exec.Exec(ctx, repoDir, "git", "add", ".").Err, qt.IsNil)
+
+	for _, test := range []struct {
+		selected []string
+		literal  bool
+	}{
+		{selected: []string{"HTML"}},
+		{selected: []string{"AMP"}},
+		{selected: []string{"RSS", "sitemap"}},
+		{selected: []string{"search"}},
+		{selected: []string{"other"}},
+		{selected: []string{"other", "HTML"}},
+		{selected: []string{"html", "amp"}},
+		{selected: []string{"calendar"}},
+		// Globs can't pick out these, so they get each path instead.
+		{selected: []string{"other", "AMP"}, literal: true},
+	} {
+		selected := test.selected
+		comment := qt.Commentf("%v", selected)
+		expected, err := pathsInFormats(formats, changes, selected)
+		c.Assert(err, qt.IsNil, comment)
+		pathspecs, err := formatPathspecs(formats, changes, selected)
+		c.Assert(err, qt.IsNil, comment)
+		if len(expected) == 0 {
+			c.Check(pathspecs, qt.HasLen, 0, comment)
+			continue
+		}
+		for _, pathspec := range pathspecs {
+			c.Check(strings.HasPrefix(pathspec, ":(literal)"), qt.Equals, test.literal, comment)
+		}
+		result := exec.Exec(ctx, repoDir, append([]string{"git", "-c", "core.quotePath=false", "ls-files", "--"}, pathspecs...)...)
+		c.Assert(result.Err, qt.IsNil, comment)
+		listed := strings.Split(result.StdOut, "\n")
+		sort.Strings(listed)
+		sort.Strings(expected)
+		c.Check(listed, qt.DeepEquals, expected, comment)
+	}
+
+	_, err = formatPathspecs(formats, changes, []string{"jsno"})
+	c.Check(err, qt.ErrorMatches, `Unknown output format 'jsno'.*`)
+}
@@ -1,12 +1,17 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/capnfabs/grouse/internal/events"
 	"github.com/capnfabs/grouse/internal/exec"
 	"github.com/capnfabs/grouse/internal/git"
 	"github.com/capnfabs/grouse/internal/out"
@@ -22,99 +27,238 @@ func check(err error) {
 }
 
 func RunRootCommand(cmd *cobra.Command) {
-	context, err := parseArgs(cmd.Flags())
+	userArgs, err := parseArgs(cmd.Flags())
 	if err != nil {
 		out.Outln("Error:", err)
 		cmd.Usage()
 		os.Exit(1)
 	}
-	out.Reinit(context.debug)
+	out.Reinit(userArgs.debug)
 
-	err = runMain(git.NewGit(), *context)
+	runToCompletion(*userArgs, func(ctx context.Context) error {
+		return runMain(ctx, git.NewGit(ctx), *userArgs)
+	})
+}
+
+// runToCompletion does everything that's common to the commands which compare
+// things: it sets up the event stream and Ctrl-C handling, runs run, and then
+// exits with an appropriate status if run fails.
+func runToCompletion(userArgs cmdArgs, run func(ctx context.Context) error) {
+	ctx, cancel := cancelOnSignal()
+	defer cancel()
+	ctx, closeEvents, err := initEvents(ctx, userArgs)
 	if err != nil {
 		out.Outln("Error:", err)
+		os.Exit(1)
+	}
+
+	start := time.Now()
+	err = run(ctx)
+	events.Emit(ctx, events.Event{Type: events.Finished}.Finish(start, err))
+	closeEvents()
+	if err != nil {
+		out.Outln("Error:", err)
+		if ctx.Err() != nil {
+			// The conventional exit code for 'terminated by Ctrl-C'.
+			os.Exit(130)
+		}
 		os.Exit(2)
 	}
 }
 
-func runMain(git_ git.Git, userArgs cmdArgs) error {
-	repo, err := git_.OpenRepository(userArgs.repoDir)
+// cancelOnSignal returns a context which is cancelled when the user hits Ctrl-C
+// or grouse is otherwise asked to terminate. Cancelling the context kills any
+// child processes (hugo, git) and lets deferred cleanup run, which wouldn't
+// happen if the signal killed grouse directly.
+func cancelOnSignal() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			out.Outf("Received %v, cleaning up…\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
 
-	if err != nil {
-		return errors.WithMessagef(err, "Couldn't load the git repo in %s", userArgs.repoDir)
+// interrupted wraps err to explain that it happened because the user cancelled
+// grouse, if that's the case. Otherwise, it returns nil.
+func interrupted(ctx context.Context, err error) error {
+	if ctx.Err() == nil {
+		return nil
 	}
+	return errors.WithMessage(ctx.Err(), "Interrupted")
+}
 
-	relativeRoot, err := git_.GetRelativeLocation(userArgs.repoDir)
-	// Shouldn't happen because we already verified the repo above?
-	check(err)
+func runMain(ctx context.Context, git_ git.Git, userArgs cmdArgs) error {
+	build, err := BuildRevisions(ctx, git_, userArgs.buildOptions())
+	if err != nil {
+		return err
+	}
+	defer build.Close()
 
-	out.Debugf("Got repo location %#v and relative path %#v\n", repo.RootDir(), relativeRoot)
+	if len(build.Revisions) == 1 {
+		return compareTwoRevisions(ctx, build.OutputRepo, build.Base, build.Revisions[0], userArgs)
+	}
+	return compareSeveralRevisions(ctx, build.OutputRepo, build.Base, build.Revisions, userArgs)
+}
 
-	refs := []git.ResolvedUserRef{}
+func compareTwoRevisions(ctx context.Context, outputRepo git.Repository, base, revision BuiltRevision, userArgs cmdArgs) error {
+	if err := exportOutputs(ctx, outputRepo.RootDir(), base, &revision, userArgs); err != nil {
+		return err
+	}
 
-	for _, commit := range userArgs.commits {
-		ref, err := repo.ResolveCommit(commit)
+	if userArgs.imageReportDir != "" {
+		err := reportImageChanges(outputRepo, base.Raw, revision.Raw, userArgs.imageReportDir)
 		if err != nil {
-			return errors.WithMessagef(err, "Couldn't resolve '%s' as git commit", commit)
+			return errors.Wrap(err, "Couldn't write the image report")
 		}
-		refs = append(refs, ref)
 	}
 
-	out.Outf("Computing diff between revisions %s and %s\n", refs[0], refs[1])
+	scope, err := printReports(outputRepo, base, revision, userArgs)
+	if err != nil {
+		return err
+	}
+	if userArgs.reportFormat.replacesDiff() {
+		return writeReport(outputRepo, base, []BuiltRevision{revision}, userArgs)
+	}
+
+	// Do the actual diff
+	out.Outln("Diffing…")
+	return diffRevisions(ctx, outputRepo.RootDir(), base, revision, scope, userArgs)
+}
 
-	scratchDir, err := ioutil.TempDir("", "grouse-diff")
-	// If this fails, we're unable to do anything with temp storage, so just
-	// panic.
-	check(err)
+// diffScope narrows down and orders the diff, to match the reports.
+type diffScope struct {
+	// Extra options for git diff.
+	args []string
+	// If not nil, only the files that match these pathspecs get diffed.
+	pathspecs []string
+}
 
-	srcWorktree, err := repo.RecursiveSharedCloneTo(path.Join(scratchDir, "src"))
-	check(err)
-	if !userArgs.keepWorktree {
-		// If keepWorktree is set, keep the worktree so you can inspect it
-		// later.
-		defer srcWorktree.Remove()
+// printReports shows everything about how revision differs from base, apart
+// from the diff itself.
+func printReports(outputRepo git.Repository, base, revision BuiltRevision, userArgs cmdArgs) (diffScope, error) {
+	printConfigChanges(base, revision)
+	printContentChanges(base, revision)
+	if userArgs.taxonomyDiff {
+		printTaxonomyChanges(outputRepo, base, revision)
 	}
 
-	// Init the Output Repo
-	outputDir := path.Join(scratchDir, "output")
-	err = os.MkdirAll(outputDir, os.ModePerm)
-	check(err)
+	scope := diffScope{}
+	var languages []siteLanguage
+	if userArgs.languageBreakdown {
+		var err error
+		if languages, err = printLanguageBreakdown(outputRepo, base, revision); err != nil {
+			return scope, err
+		}
+		args, err := languageDiffArgs(outputRepo.RootDir(), languages)
+		if err != nil {
+			return scope, err
+		}
+		scope.args = args
+	}
+	if userArgs.formatBreakdown || len(userArgs.formats) > 0 {
+		formats := mergeOutputFormats(outputFormatsOf(revision.Config), outputFormatsOf(base.Config))
+		changes, err := outputRepo.ChangedFiles(base.Output, revision.Output)
+		if err != nil {
+			return scope, err
+		}
+		if userArgs.formatBreakdown {
+			printFormatBreakdown(formats, changes)
+		}
+		if len(userArgs.formats) > 0 {
+			if scope.pathspecs, err = formatPathspecs(formats, changes, userArgs.formats); err != nil {
+				return scope, err
+			}
+		}
+	}
+	return scope, printAttribution(outputRepo, base, revision, languages)
+}
 
-	outputRepo, err := git_.NewRepository(outputDir)
-	// Not the user's fault and nothing we can do; panicking is ok.
-	check(err)
+// compareSeveralRevisions summarizes how each revision differs from the base,
+// and then shows each of those diffs in turn.
+func compareSeveralRevisions(ctx context.Context, outputRepo git.Repository, base BuiltRevision, revisions []BuiltRevision, userArgs cmdArgs) error {
+	if err := exportOutputs(ctx, outputRepo.RootDir(), base, nil, userArgs); err != nil {
+		return err
+	}
 
-	outputHashes := []git.Hash{}
+	changes, err := changesAgainstBase(outputRepo, base, revisions)
+	if err != nil {
+		return err
+	}
+	printRevisionSummary(base, changes)
 
-	for _, ref := range refs {
-		// Make sure the output directory is empty
-		err = outputRepo.ClearSourceControlledFilesFromWorktree()
-		check(err)
+	return showEachRevision(ctx, outputRepo, base, revisions, userArgs)
+}
 
-		out.Outf("Building revision %s…\n", ref)
-		hash, err := processSourceAtCommit(
-			srcWorktree, ref.Commit(), relativeRoot, userArgs.buildArgs, outputRepo)
+// showEachRevision shows the image report (if there is one) and the diff for
+// each of revisions against base, one after the other.
+func showEachRevision(ctx context.Context, outputRepo git.Repository, base BuiltRevision, revisions []BuiltRevision, userArgs cmdArgs) error {
+	if userArgs.reportFormat.replacesDiff() {
+		// The report covers every revision at once.
+		return writeReport(outputRepo, base, revisions, userArgs)
+	}
+	for i, revision := range revisions {
+		if userArgs.imageReportDir != "" {
+			out.Outf("Images in %s:\n", revision)
+			err := reportImageChanges(outputRepo, base.Raw, revision.Raw, revisionReportDir(userArgs.imageReportDir, i, revision))
+			if err != nil {
+				return errors.Wrap(err, "Couldn't write the image report")
+			}
+		}
 
-		switch err.(type) {
-		case *exec.ExitError:
-			err := errors.Wrapf(err, "Building at commit %s failed", ref)
+		scope, err := printReports(outputRepo, base, revision, userArgs)
+		if err != nil {
+			return err
+		}
+		out.Outf("Diffing %s against %s (%d of %d)…\n", revision, base, i+1, len(revisions))
+		if err := diffRevisions(ctx, outputRepo.RootDir(), base, revision, scope, userArgs); err != nil {
 			return err
-		case error:
-			panic(err)
 		}
-		outputHashes = append(outputHashes, hash)
 	}
+	return nil
+}
 
-	// Do the actual diff
-	out.Outln("Diffing…")
-	err = runDiff(outputDir, userArgs.noPager, userArgs.diffCommand, userArgs.diffArgs, outputHashes[0], outputHashes[1])
+// diffRevisions shows the diff between the outputs of base and revision,
+// narrowed down by scope.
+func diffRevisions(ctx context.Context, repoDir string, base, revision BuiltRevision, scope diffScope, userArgs cmdArgs) error {
+	if scope.pathspecs != nil && len(scope.pathspecs) == 0 {
+		out.FromContext(ctx).Outf("Nothing changed in the selected output formats (%s).\n", strings.Join(userArgs.formats, ", "))
+		return nil
+	}
+	event := events.Event{Type: events.DiffStarted, Ref: revision.Name, OutputCommit: string(revision.Output)}
+	events.Emit(ctx, event)
+	start := time.Now()
+	// The user's args go last, so that they can override ours.
+	diffArgs := append(append([]string{}, scope.args...), userArgs.diffArgs...)
+	var err error
+	if userArgs.sourceDiff && base.SourceDir != "" && revision.SourceDir != "" {
+		err = runSourceAndOutputDiff(repoDir, base, revision, diffArgs, scope.pathspecs, userArgs)
+	} else {
+		err = runDiff(repoDir, userArgs.noPager, userArgs.diffCommand, diffArgs, scope.pathspecs, base.Output, revision.Output)
+	}
+	err = diffFailed(err, userArgs.diffCommand)
+	event.Type = events.DiffFinished
+	events.Emit(ctx, event.Finish(start, err))
+	return err
+}
+
+// diffFailed converts the error from runDiff into something to show to the
+// user, or nil if it wasn't really an error.
+func diffFailed(err error, diffCommand string) error {
 	switch e := err.(type) {
 	case *exec.ExitError:
 		if strings.Contains(e.Error(), "signal: broken pipe") {
 			// It's not an error; but the user exited 'less' or whatever
 		} else {
 			err := errors.Wrapf(
-				err, "Running git %s failed", userArgs.diffCommand)
+				err, "Running git %s failed", diffCommand)
 			return err
 		}
 	case error:
@@ -123,43 +267,163 @@ func runMain(git_ git.Git, userArgs cmdArgs) error {
 	return nil
 }
 
+// buildSettings are the things about building a revision which are the same
+// for every revision.
+type buildSettings struct {
+	hugoRelativeRoot string
+	buildArgs        []string
+	hooks            buildHooks
+	filters          *outputFilters
+	// Whether to read Hugo's configuration / list its content for each
+	// revision.
+	readConfig  bool
+	readContent bool
+	// Where hugo's output goes.
+	output io.Writer
+}
+
 func processSourceAtCommit(
-	srcWorktree git.WorktreeRepository, ref git.ResolvedCommit, hugoRelativeRoot string, buildArgs []string, outputRepo git.WriteableRepository) (git.Hash, error) {
-	out.Debugf("Checking out %s…\n", ref)
-	err := srcWorktree.Checkout(ref)
+	ctx context.Context, srcWorktree git.WorktreeRepository, ref git.ResolvedUserRef, side string, settings buildSettings, outputRepo git.WriteableRepository) (BuiltRevision, error) {
+	log := out.FromContext(ctx)
+	commit := ref.Commit()
+	event := events.Event{Ref: ref.UserRef(), Commit: string(commit.Hash())}
+
+	log.Debugf("Checking out %s…\n", commit)
+	event.Type = events.CheckoutStarted
+	events.Emit(ctx, event)
+	start := time.Now()
+	err := srcWorktree.Checkout(commit)
+	event.Type = events.CheckoutFinished
+	events.Emit(ctx, event.Finish(start, err))
+	if err != nil {
+		return BuiltRevision{}, err
+	}
+	log.Debugln("…done checking out.")
+
+	hugoDir := path.Join(srcWorktree.RootDir(), settings.hugoRelativeRoot)
+	env := hookEnv{
+		ref:       ref.UserRef(),
+		commit:    string(commit.Hash()),
+		side:      side,
+		sourceDir: srcWorktree.RootDir(),
+		hugoDir:   hugoDir,
+		outputDir: outputRepo.RootDir(),
+	}
+	commitMessage := fmt.Sprintf("Website content, built from %s", commit)
+	revision, err := buildSource(ctx, fmt.Sprint(ref), event, env, commitMessage, settings, outputRepo)
 	if err != nil {
-		return git.NilHash, err
+		return BuiltRevision{}, err
 	}
-	out.Debugln("…done checking out.")
+	revision.Name = ref.UserRef()
+	revision.Description = fmt.Sprint(ref)
+	revision.Source = commit.Hash()
+	return revision, nil
+}
 
-	if err = runHugo(path.Join(srcWorktree.RootDir(), hugoRelativeRoot), outputRepo.RootDir(), buildArgs); err != nil {
-		return git.NilHash, err
+// buildSource runs the hooks and Hugo over the source in env.sourceDir, and
+// commits the output. description is what to call the source in messages to
+// the user, and event is the basis for the events about the build. The
+// revision it returns doesn't say which revision it is yet.
+func buildSource(
+	ctx context.Context, description string, event events.Event, env hookEnv, commitMessage string, settings buildSettings, outputRepo git.WriteableRepository) (BuiltRevision, error) {
+	log := out.FromContext(ctx)
+	hugoDir := env.hugoDir
+	if err := runHook(ctx, preBuildHook, settings.hooks.preBuild, hugoDir, env, settings.output); err != nil {
+		return BuiltRevision{}, err
 	}
 
-	commitMessage := fmt.Sprintf("Website content, built from %s", ref)
-	return outputRepo.CommitEverythingInWorktree(commitMessage)
+	queryArgs := hugoQueryArgs(settings.buildArgs)
+	var config hugoConfig
+	var err error
+	if settings.readConfig {
+		config, err = readHugoConfig(ctx, hugoDir, env.sourceDir, queryArgs)
+		if ctx.Err() != nil {
+			return BuiltRevision{}, ctx.Err()
+		} else if err != nil {
+			log.Outf("Couldn't read Hugo's configuration for %s, so it won't be compared: %v\n", description, err)
+		}
+	}
+	var content contentInventory
+	if settings.readContent {
+		content, err = readContentInventory(ctx, hugoDir, queryArgs)
+		if ctx.Err() != nil {
+			return BuiltRevision{}, ctx.Err()
+		} else if err != nil {
+			log.Outf("Couldn't list the content for %s, so it won't be compared: %v\n", description, err)
+		}
+	}
+
+	event.Type = events.BuildStarted
+	events.Emit(ctx, event)
+	start := time.Now()
+	warnings := newWarningRecorder(settings.output, hugoDir)
+	err = runHugo(ctx, hugoDir, outputRepo.RootDir(), settings.buildArgs, warnings)
+	warnings.Close()
+	finished := event
+	finished.Type = events.BuildFinished
+	finished = finished.Finish(start, err)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		status := exitErr.ExitCode()
+		finished.ExitStatus = &status
+	} else if err == nil {
+		status := 0
+		finished.ExitStatus = &status
+	}
+	events.Emit(ctx, finished)
+	if err != nil {
+		return BuiltRevision{}, err
+	}
+
+	if err := runHook(ctx, postBuildHook, settings.hooks.postBuild, outputRepo.RootDir(), env, settings.output); err != nil {
+		return BuiltRevision{}, err
+	}
+
+	raw, output, err := settings.filters.commitOutput(ctx, outputRepo, commitMessage)
+	if err != nil {
+		return BuiltRevision{}, err
+	}
+	event.Type = events.OutputCommitted
+	event.OutputCommit = string(output)
+	events.Emit(ctx, event)
+	return BuiltRevision{
+		Raw:           raw,
+		Output:        output,
+		Config:        config,
+		Content:       content,
+		BuildWarnings: warnings.warnings,
+	}, nil
 }
 
-func runHugo(hugoRootDir string, outputDir string, userArgs []string) error {
+func runHugo(ctx context.Context, hugoRootDir string, outputDir string, userArgs []string, output io.Writer) error {
 	// Put the 'destination' last. Repeated 'destination' flags only uses the
 	// last one.
 	// Note that we do it with the "--destination=/foo/" instead of "--destination foo"
 	// -- there was a reason for this but it's been lost to time.
 	allArgs := append(userArgs, "--destination="+shellquote.Join(outputDir))
-	cmd := exec.Command("hugo", allArgs...)
-	out.Debugf("Running command\n> %s\n(from directory %s)\n", shellquote.Join(cmd.Args...), hugoRootDir)
+	cmd := exec.Command(ctx, "hugo", allArgs...)
+	out.FromContext(ctx).Debugf("Running command\n> %s\n(from directory %s)\n", shellquote.Join(cmd.Args...), hugoRootDir)
 	cmd.Dir = hugoRootDir
 
 	// TODO: if --debug is NOT specified, should hang on to these and then only
 	// print them if an error occurs?
 	// NOTE that this intentionally uses Stderr for both; see
 	// https://github.com/capnfabs/grouse/pull/12#issuecomment-643446418
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stderr
+	cmd.Stderr = output
+	cmd.Stdout = output
+	return exec.Run(cmd)
+}
+
+func runDiff(repoDir string, noPager bool, diffCommand string, userArgs []string, pathspecs []string, hash1, hash2 git.Hash) error {
+	cmd := gitDiffCommand(repoDir, noPager, diffCommand, userArgs, pathspecs, hash1, hash2)
+	// This gets surfaced to the user because they're allowed to pass in diff
+	// args, so it's probably (?) something they can fix?
 	return exec.Run(cmd)
 }
 
-func runDiff(repoDir string, noPager bool, diffCommand string, userArgs []string, hash1, hash2 git.Hash) error {
+// gitDiffCommand prepares to run git diff (or diffCommand) between two
+// commits in repoDir, optionally only for the files that match pathspecs,
+// showing the diff to the user.
+func gitDiffCommand(repoDir string, noPager bool, diffCommand string, userArgs []string, pathspecs []string, hash1, hash2 git.Hash) *exec.Cmd {
 	allArgs := []string{}
 	if noPager {
 		// This is an argument to the git command, not to the 'diff' subcommand,
@@ -169,14 +433,18 @@ func runDiff(repoDir string, noPager bool, diffCommand string, userArgs []string
 	allArgs = append(allArgs, diffCommand)
 	allArgs = append(allArgs, userArgs...)
 	allArgs = append(allArgs, string(hash1), string(hash2))
+	if len(pathspecs) > 0 {
+		allArgs = append(allArgs, "--")
+		allArgs = append(allArgs, pathspecs...)
+	}
 
-	cmd := exec.Command("git", allArgs...)
+	// This intentionally isn't cancellable: people hit Ctrl-C inside pagers
+	// all the time, and it shouldn't kill the diff they're looking at.
+	cmd := exec.Command(context.Background(), "git", allArgs...)
 	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 	cmd.Dir = repoDir
 	out.Debugf("Running command %s\n", shellquote.Join(cmd.Args...))
-	// This gets surfaced to the user because they're allowed to pass in diff
-	// args, so it's probably (?) something they can fix?
-	return exec.Run(cmd)
+	return cmd
 }
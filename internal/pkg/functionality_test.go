@@ -1,6 +1,8 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -39,7 +41,7 @@ func resolve(r *mocks.Repository, hash string, userRef string) *mocks.ResolvedUs
 
 	ref := new(mocks.ResolvedUserRef)
 	ref.On("Commit").Return(commit)
-	ref.On("UserRef", userRef)
+	ref.On("UserRef").Return(userRef)
 	return ref
 }
 
@@ -54,7 +56,7 @@ func mockReadRepo() *mocks.Repository {
 
 	r.On("RootDir").Return("/tmp/repo")
 	r.On("ResolveCommit", mock.Anything).Return(ref, nil)
-	r.On("RecursiveSharedCloneTo", mock.Anything).Return(wt, nil)
+	r.On("RecursiveSharedCloneTo", mock.Anything, mock.Anything).Return(wt, nil)
 	return r
 }
 
@@ -79,7 +81,7 @@ func installFixtures() (m, func()) {
 func installMockExec() (*mock.Mock, func()) {
 	mockExec := mock.Mock{}
 	old := exec.Exec
-	exec.Exec = func(workDir string, args ...string) exec.CmdResult {
+	exec.Exec = func(ctx context.Context, workDir string, args ...string) exec.CmdResult {
 		res := mockExec.Called(workDir, args)
 		return res.Get(0).(exec.CmdResult)
 	}
@@ -114,6 +116,7 @@ func TestPassthroughBuildArgs(t *testing.T) {
 	defer cleanup()
 
 	mockGit := new(mocks.Git)
+	mockGit.On("SupportsDetachedWorktrees").Return(false)
 	mockGit.On("NewRepository", mock.Anything).Return(mockWriteRepo(), nil)
 	mockGit.On("OpenRepository", mock.Anything).Return(mockReadRepo(), nil)
 	mockGit.On("GetRelativeLocation", mock.Anything).Return("potato/tomato", nil)
@@ -128,7 +131,7 @@ func TestPassthroughBuildArgs(t *testing.T) {
 		noPager:      false,
 		keepWorktree: false,
 	}
-	runMain(mockGit, args)
+	runMain(context.Background(), mockGit, args)
 
 	cmds := findCmdsMatchingArgs(runnerMocks.Run.Calls, "hugo")
 	for _, cmd := range cmds {
@@ -152,6 +155,7 @@ func TestChecksOutCorrectSrcShas(t *testing.T) {
 	defer cleanup()
 
 	mockGit := new(mocks.Git)
+	mockGit.On("SupportsDetachedWorktrees").Return(false)
 	mockReadRepo := new(mocks.Repository)
 	mockReadRepo.On("RootDir").Return("/tmp/repo")
 	mockReadRepo.On("ResolveCommit", "origin/YOLO").Return(resolve(mockReadRepo, "111de18a818abd90ebdf1e5628820cd10d4e3efe", "origin/YOLO"), nil)
@@ -161,7 +165,7 @@ func TestChecksOutCorrectSrcShas(t *testing.T) {
 	wt.On("RootDir").Return("/tmp/worktree")
 	wt.On("Remove").Return(nil)
 	wt.On("Checkout", mock.Anything).Return(nil)
-	mockReadRepo.On("RecursiveSharedCloneTo", mock.Anything).Return(wt, nil)
+	mockReadRepo.On("RecursiveSharedCloneTo", mock.Anything, mock.Anything).Return(wt, nil)
 
 	mockGit.On("NewRepository", mock.Anything).Return(mockWriteRepo(), nil)
 	mockGit.On("OpenRepository", mock.Anything).Return(mockReadRepo, nil)
@@ -177,13 +181,78 @@ func TestChecksOutCorrectSrcShas(t *testing.T) {
 		debug:        false,
 		keepWorktree: false,
 	}
-	runMain(mockGit, args)
+	runMain(context.Background(), mockGit, args)
 
 	wt.AssertCalled(t, "Checkout", matchHash("111de18a818abd90ebdf1e5628820cd10d4e3efe"))
 	wt.AssertCalled(t, "Checkout", matchHash("301e857edf2f032ff58cd812fca526c5bae64569"))
 	wt.AssertNumberOfCalls(t, "Checkout", 2)
 }
 
+func TestRunsBuildHooks(t *testing.T) {
+	runnerMocks, cleanup := installFixtures()
+	defer cleanup()
+
+	mockGit := new(mocks.Git)
+	mockGit.On("SupportsDetachedWorktrees").Return(false)
+	mockGit.On("NewRepository", mock.Anything).Return(mockWriteRepo(), nil)
+	mockGit.On("OpenRepository", mock.Anything).Return(mockReadRepo(), nil)
+	mockGit.On("GetRelativeLocation", mock.Anything).Return("potato/tomato", nil)
+
+	args := cmdArgs{
+		diffCommand:   "diff",
+		commits:       []string{"HEAD^", "HEAD"},
+		diffArgs:      []string{},
+		buildArgs:     []string{},
+		preBuildHook:  "npm ci",
+		postBuildHook: "rm -r tmp",
+	}
+	runMain(context.Background(), mockGit, args)
+
+	preBuild := findCmdsMatchingArgs(runnerMocks.Run.Calls, "sh", "-c", "npm ci")
+	postBuild := findCmdsMatchingArgs(runnerMocks.Run.Calls, "sh", "-c", "rm -r tmp")
+	assert.Equal(t, 2, len(preBuild))
+	assert.Equal(t, 2, len(postBuild))
+	for i, side := range []string{"a", "b"} {
+		assert.Equal(t, "/tmp/worktree/potato/tomato", preBuild[i].Dir)
+		assert.Equal(t, "/tmp/repo", postBuild[i].Dir)
+		for _, cmd := range []*exec.Cmd{preBuild[i], postBuild[i]} {
+			assert.Contains(t, cmd.Env, "GROUSE_SIDE="+side)
+			assert.Contains(t, cmd.Env, "GROUSE_REVISION=123123123123123123123")
+			assert.Contains(t, cmd.Env, "GROUSE_OUTPUT_DIR=/tmp/repo")
+		}
+	}
+}
+
+func TestFailingHookStopsTheBuild(t *testing.T) {
+	_, cleanup := installFixtures()
+	defer cleanup()
+	restoreRun := exec.Run
+	defer func() { exec.Run = restoreRun }()
+	exec.Run = func(cmd *exec.Cmd) error {
+		if cmd.Args[0] == "sh" {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+
+	mockGit := new(mocks.Git)
+	mockGit.On("SupportsDetachedWorktrees").Return(false)
+	mockGit.On("NewRepository", mock.Anything).Return(mockWriteRepo(), nil)
+	mockGit.On("OpenRepository", mock.Anything).Return(mockReadRepo(), nil)
+	mockGit.On("GetRelativeLocation", mock.Anything).Return("potato/tomato", nil)
+
+	args := cmdArgs{
+		diffCommand:  "diff",
+		commits:      []string{"HEAD^", "HEAD"},
+		diffArgs:     []string{},
+		buildArgs:    []string{},
+		preBuildHook: "false",
+	}
+	err := runMain(context.Background(), mockGit, args)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "The pre-build hook failed: exit status 1")
+}
+
 func TestDiffArgs(t *testing.T) {
 	for _, cmd := range []string{"diff", "difftool"} {
 		t.Run("command_"+cmd, func(t *testing.T) {
@@ -191,6 +260,7 @@ func TestDiffArgs(t *testing.T) {
 			defer cleanup()
 
 			mockGit := new(mocks.Git)
+			mockGit.On("SupportsDetachedWorktrees").Return(false)
 			mockGit.On("OpenRepository", mock.Anything).Return(mockReadRepo(), nil)
 			mockGit.On("GetRelativeLocation", mock.Anything).Return("potato/tomato", nil)
 			mockGit.On("NewRepository", mock.Anything).Return(mockWriteRepo(), nil)
@@ -205,7 +275,7 @@ func TestDiffArgs(t *testing.T) {
 				keepWorktree: false,
 				noPager:      false,
 			}
-			runMain(mockGit, args)
+			runMain(context.Background(), mockGit, args)
 			diffCmds := findCmdsMatchingArgs(runnerMocks.Run.Calls, "git", "diff")
 			assert.Equal(t, 1, len(diffCmds))
 			assert.Equal(t, []string{"git", "diff", "hello", "--from-the-other-siiiiiiiiiiide", string(WrittenCommitRefs[0]), string(WrittenCommitRefs[1])}, diffCmds[0].Args)
@@ -227,6 +297,7 @@ func TestNoPager(t *testing.T) {
 			defer cleanup()
 
 			mockGit := new(mocks.Git)
+			mockGit.On("SupportsDetachedWorktrees").Return(false)
 			mockGit.On("OpenRepository", mock.Anything).Return(mockReadRepo(), nil)
 			mockGit.On("GetRelativeLocation", mock.Anything).Return("potato/tomato", nil)
 			mockGit.On("NewRepository", mock.Anything).Return(mockWriteRepo(), nil)
@@ -240,7 +311,7 @@ func TestNoPager(t *testing.T) {
 				debug:        false,
 				keepWorktree: false,
 			}
-			runMain(mockGit, args)
+			runMain(context.Background(), mockGit, args)
 			gitCmds := findCmdsMatchingArgs(runnerMocks.Run.Calls, "git")
 			assert.Equal(t, 1, len(gitCmds))
 			actualArgSlice := gitCmds[0].Args[0:len(tc.expectedArgSlice)]
@@ -249,6 +320,74 @@ func TestNoPager(t *testing.T) {
 	}
 }
 
+func TestSourceDiff(t *testing.T) {
+	runnerMocks, cleanup := installFixtures()
+	defer cleanup()
+
+	mockGit := new(mocks.Git)
+	mockGit.On("SupportsDetachedWorktrees").Return(false)
+	mockGit.On("OpenRepository", mock.Anything).Return(mockReadRepo(), nil)
+	mockGit.On("GetRelativeLocation", mock.Anything).Return("potato/tomato", nil)
+	mockGit.On("NewRepository", mock.Anything).Return(mockWriteRepo(), nil)
+
+	args := cmdArgs{
+		repoDir:     "",
+		diffCommand: "diff",
+		commits:     []string{"HEAD^", "HEAD"},
+		diffArgs:    []string{"--stat"},
+		noPager:     true,
+		sourceDiff:  true,
+	}
+	runMain(context.Background(), mockGit, args)
+
+	gitCmds := findCmdsMatchingArgs(runnerMocks.Run.Calls, "git")
+	assert.Equal(t, 2, len(gitCmds))
+	// The source comes first, from the scratch checkout, and only for the
+	// Hugo site.
+	assert.Equal(t, "/tmp/worktree", gitCmds[0].Dir)
+	assert.Equal(t, []string{"git", "--no-pager", "diff", "--submodule=diff", "--stat", "123123123123123123123", "123123123123123123123", "--", ":(literal)potato/tomato"}, gitCmds[0].Args)
+	assert.Equal(t, "/tmp/repo", gitCmds[1].Dir)
+	assert.Equal(t, []string{"git", "--no-pager", "diff", "--stat", string(WrittenCommitRefs[0]), string(WrittenCommitRefs[1])}, gitCmds[1].Args)
+}
+
+func TestChooseSourceMode(t *testing.T) {
+	testCases := []struct {
+		label             string
+		requested         sourceMode
+		supportsWorktrees bool
+		usesSubmodules    bool
+		expected          sourceMode
+	}{
+		{"auto", sourceModeAuto, true, false, sourceModeWorktree},
+		{"auto_old_git", sourceModeAuto, false, false, sourceModeClone},
+		{"auto_submodules", sourceModeAuto, true, true, sourceModeClone},
+		{"explicit_export", sourceModeExport, true, true, sourceModeExport},
+		{"explicit_worktree", sourceModeWorktree, false, false, sourceModeWorktree},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.label, func(t *testing.T) {
+			mockGit := new(mocks.Git)
+			mockGit.On("SupportsDetachedWorktrees").Return(tc.supportsWorktrees)
+			repo := new(mocks.Repository)
+			repo.On("UsesSubmodules", mock.Anything).Return(tc.usesSubmodules)
+			refs := []git.ResolvedUserRef{resolve(repo, "111de18a818abd90ebdf1e5628820cd10d4e3efe", "HEAD")}
+
+			assert.Equal(t, tc.expected, chooseSourceMode(context.Background(), mockGit, repo, refs, tc.requested))
+		})
+	}
+}
+
+func TestFallbackSourceMode(t *testing.T) {
+	next, ok := fallbackSourceMode(sourceModeWorktree)
+	assert.True(t, ok)
+	assert.Equal(t, sourceModeClone, next)
+	next, ok = fallbackSourceMode(sourceModeClone)
+	assert.True(t, ok)
+	assert.Equal(t, sourceModeExport, next)
+	_, ok = fallbackSourceMode(sourceModeExport)
+	assert.False(t, ok)
+}
+
 func findCmdsMatchingArgs(calls []mock.Call, args ...string) []*exec.Cmd {
 	matches := []*exec.Cmd{}
 	for _, call := range calls {
@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/capnfabs/grouse/internal/events"
+	"github.com/capnfabs/grouse/internal/exec"
+	"github.com/capnfabs/grouse/internal/out"
+)
+
+const (
+	preBuildHook  = "pre-build"
+	postBuildHook = "post-build"
+)
+
+// buildHooks are shell commands that run around every build: pre-build in the
+// Hugo site's directory in the checked-out source, and post-build in the
+// output directory before the output gets committed.
+type buildHooks struct {
+	preBuild  string
+	postBuild string
+}
+
+// hookError means that a hook ran, but failed.
+type hookError struct {
+	hook string
+	err  error
+}
+
+func (e *hookError) Error() string {
+	return fmt.Sprintf("The %s hook failed: %v", e.hook, e.err)
+}
+
+// hookEnv describes the revision being built to hooks, via environment
+// variables.
+type hookEnv struct {
+	ref       string
+	commit    string
+	side      string
+	sourceDir string
+	hugoDir   string
+	outputDir string
+}
+
+func (h hookEnv) vars() []string {
+	return []string{
+		"GROUSE_REF=" + h.ref,
+		"GROUSE_REVISION=" + h.commit,
+		"GROUSE_SIDE=" + h.side,
+		"GROUSE_SOURCE_DIR=" + h.sourceDir,
+		"GROUSE_HUGO_DIR=" + h.hugoDir,
+		"GROUSE_OUTPUT_DIR=" + h.outputDir,
+	}
+}
+
+// runHook runs command with the system shell in dir, if command isn't empty.
+func runHook(ctx context.Context, hook string, command string, dir string, env hookEnv, output io.Writer) error {
+	if command == "" {
+		return nil
+	}
+	cmd := shellCommand(ctx, command)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env.vars()...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	out.FromContext(ctx).Debugf("Running %s hook\n> %s\n(from directory %s)\n", hook, command, dir)
+
+	event := events.Event{Type: events.HookStarted, Hook: hook, Ref: env.ref, Commit: env.commit}
+	events.Emit(ctx, event)
+	start := time.Now()
+	err := exec.Run(cmd)
+	event.Type = events.HookFinished
+	events.Emit(ctx, event.Finish(start, err))
+	if err != nil {
+		return &hookError{hook: hook, err: err}
+	}
+	return nil
+}
+
+// shellCommand prepares to run command with the system shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command(ctx, "cmd", "/C", command)
+	}
+	return exec.Command(ctx, "sh", "-c", command)
+}
@@ -0,0 +1,222 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/exec"
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+	"github.com/kballard/go-shellquote"
+	au "github.com/logrusorgru/aurora"
+)
+
+// hugoConfig is Hugo's fully-resolved configuration for a revision, as
+// printed by `hugo config`, flattened so that nested keys are joined with
+// dots, e.g. "params.mainsections". Values are JSON, so that e.g. the string
+// "1" and the number 1 are different.
+type hugoConfig map[string]string
+
+// sourceDirPlaceholder replaces the path of the checked-out source in config
+// values, so that the configs of revisions built in different directories
+// can be compared.
+const sourceDirPlaceholder = "<source>"
+
+// readHugoConfig runs `hugo config` in hugoDir, with the arguments from
+// hugoQueryArgs. sourceDir is the root of the checked-out source.
+func readHugoConfig(ctx context.Context, hugoDir string, sourceDir string, queryArgs []string) (hugoConfig, error) {
+	// Newer versions of Hugo can print JSON, which keeps all the structure;
+	// older ones only print one `key = value` line per top-level key.
+	output, err := runHugoCommand(ctx, hugoDir, append([]string{"config", "--format", "json"}, queryArgs...))
+	var config hugoConfig
+	if err == nil {
+		config, err = parseJSONConfig(output)
+	} else if ctx.Err() == nil {
+		out.FromContext(ctx).Debugln("`hugo config --format json` failed, trying the old format:", err)
+		output, err = runHugoCommand(ctx, hugoDir, append([]string{"config"}, queryArgs...))
+		if err == nil {
+			config, err = parseLegacyConfig(output)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range config {
+		config[key] = strings.Replace(value, sourceDir, sourceDirPlaceholder, -1)
+	}
+	return config, nil
+}
+
+// hugoOutputFlags are the flags which only change where and how the build
+// writes its output, not what Hugo makes of the site, and whether they take a
+// value. `hugo config` and `hugo list` get all the other build arguments.
+var hugoOutputFlags = map[string]bool{
+	"destination":          true,
+	"d":                    true,
+	"cleanDestinationDir":  false,
+	"forceSyncStatic":      false,
+	"gc":                   false,
+	"minify":               false,
+	"noChmod":              false,
+	"noTimes":              false,
+	"renderToMemory":       false,
+	"renderSegments":       true,
+	"templateMetrics":      false,
+	"templateMetricsHints": false,
+	"printI18nWarnings":    false,
+	"printMemoryUsage":     false,
+	"printPathWarnings":    false,
+	"printUnusedTemplates": false,
+	"i18n-warnings":        false,
+	"path-warnings":        false,
+	"watch":                false,
+	"w":                    false,
+	"poll":                 true,
+}
+
+// hugoQueryArgs returns buildArgs without the flags that only matter to the
+// build's output (e.g. --destination or --minify), for `hugo config` and
+// `hugo list`, so that they describe the same site as the build.
+func hugoQueryArgs(buildArgs []string) []string {
+	args := []string{}
+	for i := 0; i < len(buildArgs); i++ {
+		parts := strings.SplitN(strings.TrimLeft(buildArgs[i], "-"), "=", 2)
+		takesValue, outputOnly := hugoOutputFlags[parts[0]]
+		if !strings.HasPrefix(buildArgs[i], "-") || !outputOnly {
+			args = append(args, buildArgs[i])
+			continue
+		}
+		if takesValue && len(parts) == 1 {
+			// The value is the next argument.
+			i++
+		}
+	}
+	return args
+}
+
+// runHugoCommand runs hugo with args in hugoDir, and returns what it prints
+// to stdout.
+func runHugoCommand(ctx context.Context, hugoDir string, args []string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(ctx, "hugo", args...)
+	out.FromContext(ctx).Debugf("Running command\n> %s\n(from directory %s)\n", shellquote.Join(cmd.Args...), hugoDir)
+	cmd.Dir = hugoDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := exec.Run(cmd); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%v: %s", err, message)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func parseJSONConfig(output []byte) (hugoConfig, error) {
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	decoder.UseNumber()
+	var tree map[string]interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, fmt.Errorf("Couldn't parse the output of hugo config: %v", err)
+	}
+	config := hugoConfig{}
+	flattenConfig(config, "", tree)
+	return config, nil
+}
+
+func flattenConfig(config hugoConfig, prefix string, tree map[string]interface{}) {
+	for key, value := range tree {
+		key = prefix + strings.ToLower(key)
+		if subtree, ok := value.(map[string]interface{}); ok && len(subtree) > 0 {
+			flattenConfig(config, key+".", subtree)
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		// It was JSON to begin with.
+		check(err)
+		config[key] = string(encoded)
+	}
+}
+
+// parseLegacyConfig parses the `key = value` lines that older versions of
+// Hugo print. Nested values get printed like Go maps, so they aren't
+// flattened any further.
+func parseLegacyConfig(output []byte) (hugoConfig, error) {
+	config := hugoConfig{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " = ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		config[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(config) == 0 {
+		return nil, fmt.Errorf("Couldn't find any settings in the output of hugo config")
+	}
+	return config, nil
+}
+
+// configChange is a setting which is different between two configs.
+type configChange struct {
+	status git.ChangeStatus
+	key    string
+	// Empty if the key was added or deleted, respectively.
+	from string
+	to   string
+}
+
+// diffConfigs returns the settings which differ between from and to, sorted
+// by key.
+func diffConfigs(from, to hugoConfig) []configChange {
+	changes := []configChange{}
+	for key, value := range from {
+		if newValue, ok := to[key]; !ok {
+			changes = append(changes, configChange{status: git.Deleted, key: key, from: value})
+		} else if newValue != value {
+			changes = append(changes, configChange{status: git.Modified, key: key, from: value, to: newValue})
+		}
+	}
+	for key, value := range to {
+		if _, ok := from[key]; !ok {
+			changes = append(changes, configChange{status: git.Added, key: key, to: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].key < changes[j].key
+	})
+	return changes
+}
+
+// printConfigChanges shows how Hugo's configuration differs between base and
+// revision, if it was read for both of them.
+func printConfigChanges(base, revision BuiltRevision) {
+	if base.Config == nil || revision.Config == nil {
+		return
+	}
+	changes := diffConfigs(base.Config, revision.Config)
+	if len(changes) == 0 {
+		out.Outln("Hugo's configuration is the same.")
+		return
+	}
+	out.Outf("Hugo's configuration changed (%d settings):\n", len(changes))
+	for _, change := range changes {
+		switch change.status {
+		case git.Added:
+			out.Outf("  %s %s = %s\n", au.Green("+"), change.key, change.to)
+		case git.Deleted:
+			out.Outf("  %s %s = %s\n", au.Red("-"), change.key, change.from)
+		default:
+			out.Outf("  %s %s: %s → %s\n", au.Yellow("~"), change.key, change.from, change.to)
+		}
+	}
+}
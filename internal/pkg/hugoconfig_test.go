@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/capnfabs/grouse/internal/exec"
+	"github.com/capnfabs/grouse/internal/git"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestParseJSONConfig(t *testing.T) {
+	c := qt.New(t)
+	config, err := parseJSONConfig([]byte(`{
+		"baseURL": "https://example.org/",
+		"paginate": 10,
+		"params": {"mainSections": ["posts"], "author": {"name": "Fabian"}},
+		"taxonomies": {}
+	}`))
+	c.Assert(err, qt.IsNil)
+	c.Check(config, qt.DeepEquals, hugoConfig{
+		"baseurl":             `"https://example.org/"`,
+		"paginate":            `10`,
+		"params.mainsections": `["posts"]`,
+		"params.author.name":  `"Fabian"`,
+		"taxonomies":          `{}`,
+	})
+}
+
+func TestParseLegacyConfig(t *testing.T) {
+	c := qt.New(t)
+	config, err := parseLegacyConfig([]byte("baseurl = https://example.org/\nparams = map[mainsections:[posts]]\n"))
+	c.Assert(err, qt.IsNil)
+	c.Check(config, qt.DeepEquals, hugoConfig{
+		"baseurl": "https://example.org/",
+		"params":  "map[mainsections:[posts]]",
+	})
+
+	_, err = parseLegacyConfig([]byte("Error: unknown command\n"))
+	c.Check(err, qt.Not(qt.IsNil))
+}
+
+func TestReadHugoConfigFallsBackToLegacyFormat(t *testing.T) {
+	c := qt.New(t)
+	oldRun := exec.Run
+	defer func() { exec.Run = oldRun }()
+	calls := [][]string{}
+	exec.Run = func(cmd *exec.Cmd) error {
+		calls = append(calls, cmd.Args)
+		if len(cmd.Args) > 2 && cmd.Args[2] == "--format" {
+			return errors.New("exit status 255")
+		}
+		cmd.Stdout.Write([]byte("workingdir = /tmp/src/site\ntitle = Hi\n"))
+		return nil
+	}
+
+	config, err := readHugoConfig(context.Background(), "/tmp/src/site", "/tmp/src", []string{"--environment", "staging"})
+	c.Assert(err, qt.IsNil)
+	c.Check(calls, qt.DeepEquals, [][]string{
+		{"hugo", "config", "--format", "json", "--environment", "staging"},
+		{"hugo", "config", "--environment", "staging"},
+	})
+	c.Check(config, qt.DeepEquals, hugoConfig{"workingdir": "<source>/site", "title": "Hi"})
+}
+
+func TestHugoQueryArgs(t *testing.T) {
+	c := qt.New(t)
+	c.Check(hugoQueryArgs(nil), qt.DeepEquals, []string{})
+	c.Check(hugoQueryArgs([]string{
+		"--minify", "-d", "public", "--environment", "staging", "--baseURL=https://example.com/",
+		"-t", "ananke", "--config=a.toml,b.toml", "-D", "--ignoreVendor", "-s", "site",
+	}), qt.DeepEquals, []string{
+		"--environment", "staging", "--baseURL=https://example.com/",
+		"-t", "ananke", "--config=a.toml,b.toml", "-D", "--ignoreVendor", "-s", "site",
+	})
+	c.Check(hugoQueryArgs([]string{"--contentDir", "docs", "--destination=out", "--gc", "--themesDir", "../themes"}), qt.DeepEquals,
+		[]string{"--contentDir", "docs", "--themesDir", "../themes"})
+}
+
+func TestDiffConfigs(t *testing.T) {
+	c := qt.New(t)
+	changes := diffConfigs(
+		hugoConfig{"title": `"Old"`, "params.mainsections": `["posts"]`, "paginate": "10"},
+		hugoConfig{"title": `"Old"`, "params.mainsections": `["posts","notes"]`, "theme": `"ananke"`},
+	)
+	c.Assert(changes, qt.HasLen, 3)
+	c.Check(changes[0], qt.Equals, configChange{status: git.Deleted, key: "paginate", from: "10"})
+	c.Check(changes[1], qt.Equals, configChange{status: git.Modified, key: "params.mainsections", from: `["posts"]`, to: `["posts","notes"]`})
+	c.Check(changes[2], qt.Equals, configChange{status: git.Added, key: "theme", to: `"ananke"`})
+}
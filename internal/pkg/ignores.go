@@ -0,0 +1,211 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maskedLine replaces lines which match a mask rule.
+const maskedLine = "[masked by grouse]"
+
+// ignoreRules hide differences between outputs that aren't interesting, e.g.
+// the ones that `grouse check-determinism` finds. They get read from a file
+// with one rule per line:
+//
+//	# A comment
+//	ignore <glob>
+//	mask <glob> <regexp>
+//
+// ignore leaves files matching glob out of the comparison entirely. mask
+// replaces every line matching regexp in files matching glob with
+// maskedLine. Globs work like the ones for --filter.
+type ignoreRules struct {
+	ignored []string
+	masks   []lineMask
+}
+
+type lineMask struct {
+	glob    string
+	pattern *regexp.Regexp
+}
+
+func (r *ignoreRules) empty() bool {
+	return r == nil || (len(r.ignored) == 0 && len(r.masks) == 0)
+}
+
+// readIgnoreRules reads rules from the file at rulesPath.
+func readIgnoreRules(rulesPath string) (*ignoreRules, error) {
+	f, err := os.Open(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseIgnoreRules(f)
+}
+
+func parseIgnoreRules(r io.Reader) (*ignoreRules, error) {
+	rules := &ignoreRules{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitRule(line)
+		switch {
+		case fields[0] == "ignore" && len(fields) == 2:
+			if _, err := path.Match(fields[1], ""); err != nil {
+				return nil, fmt.Errorf("Line %d: the glob '%s' isn't valid: %v", lineNumber, fields[1], err)
+			}
+			rules.ignored = append(rules.ignored, fields[1])
+		case fields[0] == "mask" && len(fields) == 3:
+			if _, err := path.Match(fields[1], ""); err != nil {
+				return nil, fmt.Errorf("Line %d: the glob '%s' isn't valid: %v", lineNumber, fields[1], err)
+			}
+			pattern, err := regexp.Compile(fields[2])
+			if err != nil {
+				return nil, errors.WithMessagef(err, "Line %d", lineNumber)
+			}
+			rules.masks = append(rules.masks, lineMask{glob: fields[1], pattern: pattern})
+		default:
+			return nil, fmt.Errorf("Line %d: expected 'ignore <glob>' or 'mask <glob> <regexp>', but got '%s'", lineNumber, line)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// splitRule splits a rule into its kind, its glob, and whatever's left (the
+// regexp, for masks). Spaces in globs need to be escaped with a backslash.
+func splitRule(line string) []string {
+	fields := []string{}
+	for len(fields) < 2 && line != "" {
+		end := 0
+		for end < len(line) && line[end] != ' ' {
+			if line[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end > len(line) {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = strings.TrimLeft(line[end:], " ")
+	}
+	if line != "" {
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// escapeGlob returns a glob which matches exactly the file at relPath.
+func escapeGlob(relPath string) string {
+	// Anchor it to the root of the output, or it'd match files with the same
+	// name in every directory.
+	return "/" + globLiteral(relPath)
+}
+
+// globLiteral returns a glob, or part of one, which only matches s itself.
+func globLiteral(s string) string {
+	var buf strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\ `, r) {
+			buf.WriteRune('\\')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// ignores reports whether the file at relPath should be left out of the
+// comparison.
+func (r *ignoreRules) ignores(relPath string) bool {
+	if r == nil {
+		return false
+	}
+	for _, glob := range r.ignored {
+		if globMatches(glob, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// mask rewrites the file at filePath (which is relPath relative to the root
+// of the output), if any of the mask rules match it.
+func (r *ignoreRules) mask(filePath string, relPath string) error {
+	if r == nil {
+		return nil
+	}
+	patterns := []*regexp.Regexp{}
+	for _, mask := range r.masks {
+		if globMatches(mask.glob, relPath) {
+			patterns = append(patterns, mask.pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	changed := false
+	for i, line := range lines {
+		text := bytes.TrimSuffix(line, []byte("\n"))
+		for _, pattern := range patterns {
+			if pattern.Match(text) {
+				lines[i] = append([]byte(maskedLine), line[len(text):]...)
+				changed = true
+				break
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	// Hugo copies static files with their permissions, so this one might be
+	// read-only or executable. It keeps them after it's been masked.
+	mode := info.Mode().Perm()
+	if err := os.Chmod(filePath, mode|0200); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filePath, bytes.Join(lines, nil), mode); err != nil {
+		return err
+	}
+	return os.Chmod(filePath, mode)
+}
+
+// writeTo writes the rules in the format that parseIgnoreRules reads, after
+// the given comment.
+func (r *ignoreRules) writeTo(w io.Writer, comment string) error {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(comment, "\n") {
+		fmt.Fprintf(&buf, "# %s\n", line)
+	}
+	for _, glob := range r.ignored {
+		fmt.Fprintf(&buf, "ignore %s\n", glob)
+	}
+	for _, mask := range r.masks {
+		fmt.Fprintf(&buf, "mask %s %s\n", mask.glob, mask.pattern)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestParseIgnoreRules(t *testing.T) {
+	c := qt.New(t)
+	rules, err := parseIgnoreRules(strings.NewReader(`
+# Generated
+ignore *.txt
+ignore /docs/with\ space.html
+mask /index.html ^<p>Built at .*</p>$
+`))
+	c.Assert(err, qt.IsNil)
+	c.Check(rules.ignores("random-123.txt"), qt.Equals, true)
+	c.Check(rules.ignores("a/b/random-123.txt"), qt.Equals, true)
+	c.Check(rules.ignores("docs/with space.html"), qt.Equals, true)
+	c.Check(rules.ignores("index.html"), qt.Equals, false)
+	c.Assert(rules.masks, qt.HasLen, 1)
+	c.Check(rules.masks[0].glob, qt.Equals, "/index.html")
+	c.Check(rules.masks[0].pattern.String(), qt.Equals, "^<p>Built at .*</p>$")
+
+	for _, bad := range []string{"ignore", "mask *.html", "hide *.html", "mask *.html (", "ignore [x"} {
+		_, err := parseIgnoreRules(strings.NewReader(bad))
+		c.Check(err, qt.ErrorMatches, "Line 1.*", qt.Commentf("%q", bad))
+	}
+}
+
+func TestIgnoreRulesMask(t *testing.T) {
+	c := qt.New(t)
+	dir, err := ioutil.TempDir("", "grouse-ignores-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "index.html")
+	c.Assert(ioutil.WriteFile(filePath, []byte("<html>\n<p>Built at 10:01</p>\n</html>"), 0644), qt.IsNil)
+
+	rules, err := parseIgnoreRules(strings.NewReader("mask /index.html ^<p>Built at .*</p>$"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(rules.mask(filePath, "index.html"), qt.IsNil)
+	content, err := ioutil.ReadFile(filePath)
+	c.Assert(err, qt.IsNil)
+	c.Check(string(content), qt.Equals, "<html>\n"+maskedLine+"\n</html>")
+}
+
+func TestIgnoreRulesMaskKeepsMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs POSIX file permissions")
+	}
+	c := qt.New(t)
+	dir, err := ioutil.TempDir("", "grouse-ignores-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "build.sh")
+	c.Assert(ioutil.WriteFile(filePath, []byte("#!/bin/sh\n# Built at 10:01\n"), 0555), qt.IsNil)
+
+	rules, err := parseIgnoreRules(strings.NewReader("mask *.sh ^# Built at .*$"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(rules.mask(filePath, "build.sh"), qt.IsNil)
+	content, err := ioutil.ReadFile(filePath)
+	c.Assert(err, qt.IsNil)
+	c.Check(string(content), qt.Equals, "#!/bin/sh\n"+maskedLine+"\n")
+	info, err := os.Stat(filePath)
+	c.Assert(err, qt.IsNil)
+	c.Check(info.Mode().Perm(), qt.Equals, os.FileMode(0555))
+}
+
+func TestRulesForUnstableFilesRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	unstable := []unstableFile{
+		{path: "random [1].txt", wholeFile: true},
+		{path: "index.html", patterns: []string{`^<p>Built at .*</p>$`}},
+	}
+	var buf bytes.Buffer
+	c.Assert(rulesFor(unstable).writeTo(&buf, "Some\ncomment"), qt.IsNil)
+	c.Check(buf.String(), qt.Equals, `# Some
+# comment
+ignore /random\ \[1\].txt
+mask /index.html ^<p>Built at .*</p>$
+`)
+
+	rules, err := parseIgnoreRules(&buf)
+	c.Assert(err, qt.IsNil)
+	c.Check(rules.ignores("random [1].txt"), qt.Equals, true)
+	c.Check(rules.ignores("other/random [1].txt"), qt.Equals, false)
+	c.Check(rules.masks[0].glob, qt.Equals, "/index.html")
+}
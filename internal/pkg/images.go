@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/imgdiff"
+	"github.com/capnfabs/grouse/internal/out"
+	au "github.com/logrusorgru/aurora"
+)
+
+// reportImageChanges compares every image that was modified between the two
+// output commits, prints a summary of the changes, and writes visual diffs
+// into reportDir.
+func reportImageChanges(outputRepo git.Repository, from, to git.Hash, reportDir string) error {
+	changes, err := outputRepo.ChangedFiles(from, to)
+	if err != nil {
+		return err
+	}
+
+	lines := []string{}
+	for _, change := range changes {
+		if change.Status != git.Modified || !imgdiff.IsImagePath(change.Path) {
+			continue
+		}
+		line, err := compareImage(outputRepo, from, to, change.Path, reportDir)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		out.Outln("No changed images.")
+		return nil
+	}
+	out.Outln("Image changes:")
+	for _, line := range lines {
+		out.Outf("  %s\n", line)
+	}
+	return nil
+}
+
+func compareImage(outputRepo git.Repository, from, to git.Hash, imagePath string, reportDir string) (string, error) {
+	before, err := outputRepo.ReadFile(from, imagePath)
+	if err != nil {
+		return "", err
+	}
+	after, err := outputRepo.ReadFile(to, imagePath)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := imgdiff.Compare(before, after)
+	if err != nil {
+		// Not the end of the world; it's probably just a file with the wrong
+		// extension.
+		return fmt.Sprintf("%s: couldn't decode (%v)", imagePath, err), nil
+	}
+
+	if result.PixelIdentical {
+		return fmt.Sprintf("%s: %s (%s → %s)",
+			imagePath, au.Green("byte-different but pixel-identical"),
+			humanBytes(result.A.Size), humanBytes(result.B.Size)), nil
+	}
+
+	if result.Diff == nil {
+		// One of them is empty, so there's nothing to draw.
+		return fmt.Sprintf("%s: %s → %s", imagePath, describeImage(result.A), describeImage(result.B)), nil
+	}
+
+	diffPath := filepath.Join(reportDir, filepath.FromSlash(imagePath)+".diff.png")
+	if err := writePNG(diffPath, result); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s: %s → %s; difference score %.4f (%.1f%% of pixels changed); visual diff at %s",
+		imagePath, describeImage(result.A), describeImage(result.B),
+		result.Score, result.ChangedFraction*100, diffPath), nil
+}
+
+func writePNG(dst string, result *imgdiff.Comparison) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, result.Diff)
+}
+
+func describeImage(info imgdiff.Info) string {
+	return fmt.Sprintf("%d×%d %s, %s", info.Width, info.Height, strings.ToUpper(info.Format), humanBytes(info.Size))
+}
+
+func humanBytes(n int) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
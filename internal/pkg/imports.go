@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/capnfabs/grouse/internal/events"
+	"github.com/capnfabs/grouse/internal/files"
+	"github.com/capnfabs/grouse/internal/git"
+	au "github.com/logrusorgru/aurora"
+)
+
+// importDirectory commits a copy of dir to the output repo, as if it was the
+// output of a build. name is what to call it in messages; usually it's dir.
+func importDirectory(ctx context.Context, outputRepo git.WriteableRepository, dir string, name string, filters *outputFilters) (BuiltRevision, error) {
+	if err := outputRepo.ClearSourceControlledFilesFromWorktree(); err != nil {
+		return BuiltRevision{}, err
+	}
+	if err := copyTree(dir, outputRepo.RootDir()); err != nil {
+		return BuiltRevision{}, err
+	}
+	raw, filtered, err := filters.commitOutput(ctx, outputRepo, fmt.Sprintf("Website content, imported from %s", name))
+	if err != nil {
+		return BuiltRevision{}, err
+	}
+	return BuiltRevision{Name: name, Description: au.Blue(name).String(), Output: filtered, Raw: raw}, nil
+}
+
+// withImportEvents runs doImport, which imports name into the output repo,
+// and emits events for the event stream around it.
+func withImportEvents(ctx context.Context, name string, doImport func() (BuiltRevision, error)) (BuiltRevision, error) {
+	event := events.Event{Type: events.ImportStarted, Ref: name}
+	events.Emit(ctx, event)
+	start := time.Now()
+	imported, err := doImport()
+	event.Type = events.ImportFinished
+	events.Emit(ctx, event.Finish(start, err))
+	if err == nil {
+		events.Emit(ctx, events.Event{Type: events.OutputCommitted, Ref: name, OutputCommit: string(imported.Output)})
+	}
+	return imported, err
+}
+
+// copyTree copies everything in src into dst, except for git metadata, which
+// would confuse the output repo.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == ".git" {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, os.ModePerm)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return files.Copy(filePath, target, info.Mode().Perm())
+		default:
+			// Sockets, devices etc. can't be committed anyway.
+			return nil
+		}
+	})
+}
+
+// importDirectoryOrArchive is like importDirectory, but also accepts any of
+// the archives that extractArchive can read. Archives get extracted inside
+// scratchDir first, and then only what's inside archiveContentRoot gets
+// imported.
+func importDirectoryOrArchive(ctx context.Context, outputRepo git.WriteableRepository, source string, scratchDir string, filters *outputFilters) (BuiltRevision, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return BuiltRevision{}, err
+	}
+	if info.IsDir() {
+		return importDirectory(ctx, outputRepo, source, source, filters)
+	}
+	extractDir, err := ioutil.TempDir(scratchDir, "extracted")
+	if err != nil {
+		return BuiltRevision{}, err
+	}
+	defer os.RemoveAll(extractDir)
+	contentDir, err := extractArchive(source, extractDir)
+	if err != nil {
+		return BuiltRevision{}, err
+	}
+	return importDirectory(ctx, outputRepo, contentDir, source, filters)
+}
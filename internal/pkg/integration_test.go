@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -214,7 +215,7 @@ func runTest(t *testing.T, tc TestCase) {
 	fmt.Println("Test input directory is", inputDir)
 
 	stdout, stderr, err := captureOutput(func() error {
-		return runMain(git.NewGit(), buildContext(&tc, inputDir))
+		return runMain(context.Background(), git.NewGit(context.Background()), buildContext(&tc, inputDir))
 	})
 	if err != nil {
 		fmt.Println(string(stdout))
@@ -0,0 +1,224 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+	au "github.com/logrusorgru/aurora"
+)
+
+// Publish statuses, as decided by Hugo at build time.
+const (
+	statusPublished = "published"
+	statusDraft     = "draft"
+	statusFuture    = "future"
+	statusExpired   = "expired"
+)
+
+// contentEntry is what Hugo knows about a content file, from `hugo list`.
+type contentEntry struct {
+	title       string
+	status      string
+	date        string
+	publishDate string
+	expiryDate  string
+	permalink   string
+	section     string
+}
+
+// contentInventory is every content file in a revision, keyed by its path
+// relative to the Hugo site, e.g. "content/posts/hello.md".
+type contentInventory map[string]contentEntry
+
+// readContentInventory runs `hugo list all` in hugoDir, with the arguments
+// from hugoQueryArgs, to find out about each content file.
+func readContentInventory(ctx context.Context, hugoDir string, queryArgs []string) (contentInventory, error) {
+	output, err := runHugoCommand(ctx, hugoDir, append([]string{"list", "all"}, queryArgs...))
+	if err != nil {
+		return nil, err
+	}
+	return parseHugoList(output, time.Now())
+}
+
+// parseHugoList parses the CSV that `hugo list all` prints. The columns vary
+// between versions of Hugo, so they're found by name. The status of each file
+// comes from its draft, publishDate and expiryDate columns, the same way that
+// `hugo list drafts`, `future` and `expired` work it out, as of now.
+func parseHugoList(output []byte, now time.Time) (contentInventory, error) {
+	records, err := csv.NewReader(bytes.NewReader(output)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse the output of hugo list: %v", err)
+	}
+	if len(records) == 0 || len(records[0]) == 0 || records[0][0] != "path" {
+		return nil, fmt.Errorf("Unexpected output from hugo list: %q", output)
+	}
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	inventory := contentInventory{}
+	for _, record := range records[1:] {
+		p := filepathToSlash(field(record, "path"))
+		entry := contentEntry{
+			title:       field(record, "title"),
+			date:        field(record, "date"),
+			publishDate: field(record, "publishDate"),
+			expiryDate:  field(record, "expiryDate"),
+			permalink:   field(record, "permalink"),
+			section:     field(record, "section"),
+		}
+		entry.status = publishStatus(field(record, "draft") == "true", entry, now)
+		if entry.section == "" {
+			entry.section = sectionOf(p)
+		}
+		inventory[p] = entry
+	}
+	return inventory, nil
+}
+
+// publishStatus works out whether a content file gets published at now, or
+// why not, e.g. "draft, expired".
+func publishStatus(draft bool, entry contentEntry, now time.Time) string {
+	statuses := []string{}
+	if draft {
+		statuses = append(statuses, statusDraft)
+	}
+	publishDate := entry.publishDate
+	if publishDate == "" {
+		publishDate = entry.date
+	}
+	if t, ok := parseContentDate(publishDate); ok && t.After(now) {
+		statuses = append(statuses, statusFuture)
+	}
+	if t, ok := parseContentDate(entry.expiryDate); ok && !t.After(now) {
+		statuses = append(statuses, statusExpired)
+	}
+	if len(statuses) == 0 {
+		return statusPublished
+	}
+	return strings.Join(statuses, ", ")
+}
+
+// parseContentDate parses a date from `hugo list`. Hugo prints unset dates as
+// the zero time, which doesn't count.
+func parseContentDate(value string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil || t.IsZero() {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// sectionOf guesses the section of the content file at p from its path, for
+// versions of Hugo which don't include it in `hugo list`.
+func sectionOf(p string) string {
+	parts := strings.Split(p, "/")
+	if len(parts) < 3 {
+		// Directly in the content directory.
+		return ""
+	}
+	return parts[1]
+}
+
+// Hugo prints paths with the OS's separator.
+func filepathToSlash(p string) string {
+	return path.Clean(strings.Replace(p, "\\", "/", -1))
+}
+
+// contentChange is a content file which was added, removed, or whose status,
+// dates, permalink or section changed.
+type contentChange struct {
+	status git.ChangeStatus
+	path   string
+	from   contentEntry
+	to     contentEntry
+}
+
+func diffInventories(from, to contentInventory) []contentChange {
+	changes := []contentChange{}
+	for p, entry := range from {
+		if newEntry, ok := to[p]; !ok {
+			changes = append(changes, contentChange{status: git.Deleted, path: p, from: entry})
+		} else if entry.status != newEntry.status || entry.date != newEntry.date ||
+			entry.publishDate != newEntry.publishDate || entry.expiryDate != newEntry.expiryDate ||
+			entry.permalink != newEntry.permalink || entry.section != newEntry.section {
+			changes = append(changes, contentChange{status: git.Modified, path: p, from: entry, to: newEntry})
+		}
+	}
+	for p, entry := range to {
+		if _, ok := from[p]; !ok {
+			changes = append(changes, contentChange{status: git.Added, path: p, to: entry})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].path < changes[j].path
+	})
+	return changes
+}
+
+// describe explains what changed about a modified content file, e.g.
+// "status published → expired (expiryDate 2020-01-01)".
+func (c contentChange) describe() string {
+	parts := []string{}
+	if c.from.status != c.to.status {
+		part := fmt.Sprintf("status %s → %s", c.from.status, c.to.status)
+		switch c.to.status {
+		case statusExpired:
+			part += fmt.Sprintf(" (expiryDate %s)", c.to.expiryDate)
+		case statusFuture:
+			part += fmt.Sprintf(" (publishDate %s)", c.to.publishDate)
+		}
+		parts = append(parts, part)
+	}
+	for _, field := range []struct{ name, from, to string }{
+		{"date", c.from.date, c.to.date},
+		{"publishDate", c.from.publishDate, c.to.publishDate},
+		{"expiryDate", c.from.expiryDate, c.to.expiryDate},
+		{"permalink", c.from.permalink, c.to.permalink},
+		{"section", c.from.section, c.to.section},
+	} {
+		if field.from != field.to {
+			parts = append(parts, fmt.Sprintf("%s %s → %s", field.name, field.from, field.to))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// printContentChanges shows which content files changed status etc. between
+// base and revision, if the inventory was read for both of them.
+func printContentChanges(base, revision BuiltRevision) {
+	if base.Content == nil || revision.Content == nil {
+		return
+	}
+	changes := diffInventories(base.Content, revision.Content)
+	if len(changes) == 0 {
+		out.Outln("No content was added or removed, or changed its status, dates, permalink or section.")
+		return
+	}
+	out.Outf("Content changes (%d files):\n", len(changes))
+	for _, change := range changes {
+		switch change.status {
+		case git.Added:
+			out.Outf("  %s %s (%s)\n", au.Green("+"), change.path, change.to.status)
+		case git.Deleted:
+			out.Outf("  %s %s (was %s)\n", au.Red("-"), change.path, change.from.status)
+		default:
+			out.Outf("  %s %s: %s\n", au.Yellow("~"), change.path, change.describe())
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/capnfabs/grouse/internal/exec"
+	"github.com/capnfabs/grouse/internal/git"
+	qt "github.com/frankban/quicktest"
+)
+
+const hugoListHeader = "path,slug,title,date,expiryDate,publishDate,draft,permalink\n"
+
+func TestParseHugoList(t *testing.T) {
+	c := qt.New(t)
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	inventory, err := parseHugoList([]byte(hugoListHeader+
+		"content/posts/hello.md,,Hello,2020-01-01T00:00:00Z,0001-01-01T00:00:00Z,2020-01-01T00:00:00Z,false,https://example.org/posts/hello/\n"+
+		"content\\about.md,,\"About, me\",2019-05-01T00:00:00Z,,,false,https://example.org/about/\n"), now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(inventory, qt.HasLen, 2)
+	c.Check(inventory["content/posts/hello.md"], qt.Equals, contentEntry{
+		title:       "Hello",
+		status:      statusPublished,
+		date:        "2020-01-01T00:00:00Z",
+		publishDate: "2020-01-01T00:00:00Z",
+		expiryDate:  "0001-01-01T00:00:00Z",
+		permalink:   "https://example.org/posts/hello/",
+		section:     "posts",
+	})
+	c.Check(inventory["content/about.md"], qt.Equals, contentEntry{
+		title:     "About, me",
+		status:    statusPublished,
+		date:      "2019-05-01T00:00:00Z",
+		permalink: "https://example.org/about/",
+	})
+
+	_, err = parseHugoList([]byte("Error: unknown command \"list\"\n"), now)
+	c.Check(err, qt.Not(qt.IsNil))
+}
+
+func TestPublishStatus(t *testing.T) {
+	c := qt.New(t)
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	c.Check(publishStatus(false, contentEntry{}, now), qt.Equals, statusPublished)
+	c.Check(publishStatus(false, contentEntry{
+		date:        "2020-01-01T00:00:00Z",
+		publishDate: "2020-01-01T00:00:00Z",
+		expiryDate:  "0001-01-01T00:00:00Z",
+	}, now), qt.Equals, statusPublished)
+	c.Check(publishStatus(true, contentEntry{}, now), qt.Equals, statusDraft)
+	c.Check(publishStatus(false, contentEntry{publishDate: "2021-01-01T00:00:00+02:00"}, now), qt.Equals, statusFuture)
+	// Without a publishDate, it's the date that counts.
+	c.Check(publishStatus(false, contentEntry{date: "2021-01-01T00:00:00Z"}, now), qt.Equals, statusFuture)
+	c.Check(publishStatus(false, contentEntry{date: "2021-01-01T00:00:00Z", publishDate: "2020-01-01T00:00:00Z"}, now), qt.Equals, statusPublished)
+	c.Check(publishStatus(true, contentEntry{expiryDate: "2020-05-31T00:00:00Z"}, now), qt.Equals, "draft, expired")
+}
+
+func TestReadContentInventory(t *testing.T) {
+	c := qt.New(t)
+	oldRun := exec.Run
+	defer func() { exec.Run = oldRun }()
+	calls := [][]string{}
+	exec.Run = func(cmd *exec.Cmd) error {
+		calls = append(calls, cmd.Args)
+		cmd.Stdout.Write([]byte(hugoListHeader +
+			"content/a.md,,A,,,,false,\n" +
+			"content/b.md,,B,,2000-01-01T00:00:00Z,,true,\n" +
+			"content/c.md,,C,2999-01-01T00:00:00Z,,2999-01-01T00:00:00Z,false,\n"))
+		return nil
+	}
+
+	inventory, err := readContentInventory(context.Background(), "/tmp/src", []string{"--environment", "staging"})
+	c.Assert(err, qt.IsNil)
+	c.Check(calls, qt.DeepEquals, [][]string{
+		{"hugo", "list", "all", "--environment", "staging"},
+	})
+	c.Check(inventory["content/a.md"].status, qt.Equals, statusPublished)
+	c.Check(inventory["content/b.md"].status, qt.Equals, "draft, expired")
+	c.Check(inventory["content/c.md"].status, qt.Equals, statusFuture)
+}
+
+func TestDiffInventories(t *testing.T) {
+	c := qt.New(t)
+	changes := diffInventories(
+		contentInventory{
+			"content/gone.md":    {status: statusPublished},
+			"content/post.md":    {status: statusPublished, expiryDate: "2020-01-01", permalink: "/post/"},
+			"content/same.md":    {status: statusDraft, title: "Old title"},
+			"content/posts/x.md": {status: statusPublished, permalink: "/posts/x/", section: "posts"},
+		},
+		contentInventory{
+			"content/new.md":     {status: statusFuture},
+			"content/post.md":    {status: statusExpired, expiryDate: "2020-01-01", permalink: "/post/"},
+			"content/same.md":    {status: statusDraft, title: "New title"},
+			"content/posts/x.md": {status: statusPublished, permalink: "/x/", section: "posts"},
+		},
+	)
+	c.Assert(changes, qt.HasLen, 4)
+	c.Check(changes[0].path, qt.Equals, "content/gone.md")
+	c.Check(changes[0].status, qt.Equals, git.Deleted)
+	c.Check(changes[1].path, qt.Equals, "content/new.md")
+	c.Check(changes[1].status, qt.Equals, git.Added)
+	c.Check(changes[2].path, qt.Equals, "content/post.md")
+	c.Check(changes[2].describe(), qt.Equals, "status published → expired (expiryDate 2020-01-01)")
+	c.Check(changes[3].path, qt.Equals, "content/posts/x.md")
+	c.Check(changes[3].describe(), qt.Equals, "permalink /posts/x/ → /x/")
+}
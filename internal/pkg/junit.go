@@ -0,0 +1,89 @@
+package pkg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Failure   *junitFailure `xml:"failure"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitReport writes what the checks found as JUnit XML: a test suite for
+// each revision, with a failed test case for each problem, and a passing one
+// for each check which didn't find anything.
+func writeJUnitReport(w io.Writer, base BuiltRevision, revisions []revisionChecks) error {
+	report := junitTestSuites{Name: "grouse"}
+	for _, revision := range revisions {
+		suite := junitTestSuite{Name: fmt.Sprintf("%s compared to %s", revision.revision.Name, base.Name)}
+		for _, check := range siteChecks {
+			found := false
+			for _, result := range revision.results {
+				if result.check != check.name {
+					continue
+				}
+				found = true
+				testCase := junitTestCase{
+					ClassName: "grouse." + check.name,
+					Name:      result.outputPath,
+					Failure:   &junitFailure{Message: result.message, Type: check.name, Text: result.message},
+				}
+				if testCase.Name == "" {
+					testCase.Name = result.message
+				}
+				if result.sourcePath != "" {
+					testCase.File = path.Join(revision.revision.SiteDir, result.sourcePath)
+					testCase.Line = result.sourceLine
+					testCase.Failure.Text += "\nSource: " + testCase.File
+				}
+				suite.Cases = append(suite.Cases, testCase)
+				suite.Failures++
+			}
+			if !found {
+				suite.Cases = append(suite.Cases, junitTestCase{ClassName: "grouse." + check.name, Name: check.description})
+			}
+		}
+		suite.Tests = len(suite.Cases)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Suites = append(report.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,265 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+)
+
+// siteLanguage is one of the languages of a multilingual site.
+type siteLanguage struct {
+	code string
+	// Where the language's pages are in the output, e.g. "de", or "" if
+	// they're at the root (which is usually the case for the default
+	// language).
+	dir string
+}
+
+// sitemapLocation matches the URLs in a sitemap index.
+var sitemapLocation = regexp.MustCompile(`<loc>\s*([^<\s]+)\s*</loc>`)
+
+// detectLanguages works out the languages of a site, and where each of them
+// is in the output. The languages come from Hugo's configuration if there is
+// one, and otherwise from the sitemap index that Hugo writes to the root of
+// multilingual sites (sitemap may be nil if there isn't one). files are all
+// the files in the output. It returns nil for sites with only one language.
+func detectLanguages(config hugoConfig, files []string, sitemap []byte) []siteLanguage {
+	codes := []string{}
+	for key := range config {
+		if strings.HasPrefix(key, "languages.") {
+			codes = appendNew(codes, strings.SplitN(key, ".", 3)[1])
+		}
+	}
+	if len(codes) == 0 && strings.Contains(string(sitemap), "<sitemapindex") {
+		for _, match := range sitemapLocation.FindAllStringSubmatch(string(sitemap), -1) {
+			u, err := url.Parse(match[1])
+			if err != nil {
+				continue
+			}
+			if code := path.Base(path.Dir(u.Path)); code != "/" && code != "." {
+				codes = appendNew(codes, strings.ToLower(code))
+			}
+		}
+	}
+	if len(codes) < 2 {
+		return nil
+	}
+
+	// Languages which have a directory of their own have a home page in it.
+	// Only looking for any file isn't enough: Hugo writes e.g. en/sitemap.xml
+	// even if the pages in English are at the root.
+	homePages := map[string]bool{}
+	for _, file := range files {
+		if dir, name := path.Split(file); name == "index.html" && strings.Count(dir, "/") == 1 {
+			homePages[strings.TrimSuffix(dir, "/")] = true
+		}
+	}
+	defaultCode := "en"
+	if err := json.Unmarshal([]byte(config["defaultcontentlanguage"]), &defaultCode); err == nil {
+		defaultCode = strings.ToLower(defaultCode)
+	} else {
+		// Without the config, the default language is the one which doesn't
+		// have a directory of its own.
+		for _, code := range codes {
+			if !homePages[code] {
+				defaultCode = code
+			}
+		}
+	}
+
+	sort.Slice(codes, func(i, j int) bool {
+		if (codes[i] == defaultCode) != (codes[j] == defaultCode) {
+			return codes[i] == defaultCode
+		}
+		return codes[i] < codes[j]
+	})
+	languages := []siteLanguage{}
+	for _, code := range codes {
+		dir := code
+		// With `defaultContentLanguageInSubdir`, or with a domain for each
+		// language, the default language gets a directory too.
+		if code == defaultCode && !homePages[code] {
+			dir = ""
+		}
+		languages = append(languages, siteLanguage{code: code, dir: dir})
+	}
+	return languages
+}
+
+// languageOf returns the code of the language that the output file at
+// relPath belongs to, or "" if it's not in any particular language.
+func languageOf(languages []siteLanguage, relPath string) string {
+	root := ""
+	for _, language := range languages {
+		if language.dir == "" {
+			root = language.code
+		} else if strings.HasPrefix(relPath, language.dir+"/") {
+			return language.code
+		}
+	}
+	return root
+}
+
+// outputLanguages detects the languages of the site in revision (or base, if
+// revision isn't multilingual). It also returns all the files in the outputs
+// of base and revision.
+func outputLanguages(outputRepo git.Repository, base, revision BuiltRevision) ([]siteLanguage, []string, []string, error) {
+	baseFiles, err := outputRepo.ListFiles(base.Raw, ".")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	revisionFiles, err := outputRepo.ListFiles(revision.Raw, ".")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, side := range []struct {
+		revision BuiltRevision
+		files    []string
+	}{{revision, revisionFiles}, {base, baseFiles}} {
+		// Not every site has a sitemap, so errors are fine.
+		sitemap, _ := outputRepo.ReadFile(side.revision.Raw, "sitemap.xml")
+		if languages := detectLanguages(side.revision.Config, side.files, sitemap); languages != nil {
+			return languages, baseFiles, revisionFiles, nil
+		}
+	}
+	return nil, baseFiles, revisionFiles, nil
+}
+
+// lostTranslation is a page in the default language which used to be
+// translated into the given languages, but isn't any more.
+type lostTranslation struct {
+	page      string
+	languages []string
+}
+
+// findLostTranslations looks for pages which exist in the default language
+// (the first one) in revisionFiles, but aren't translated into languages
+// that they were translated into in baseFiles. Translations are matched up
+// by their path, so pages with translated slugs can't be checked.
+func findLostTranslations(languages []siteLanguage, baseFiles, revisionFiles []string) []lostTranslation {
+	inBase := map[string]bool{}
+	for _, file := range baseFiles {
+		inBase[file] = true
+	}
+	inRevision := map[string]bool{}
+	for _, file := range revisionFiles {
+		inRevision[file] = true
+	}
+
+	defaultLanguage := languages[0]
+	lost := []lostTranslation{}
+	for _, file := range revisionFiles {
+		if path.Ext(file) != ".html" || languageOf(languages, file) != defaultLanguage.code {
+			continue
+		}
+		page := file
+		if defaultLanguage.dir != "" {
+			page = strings.TrimPrefix(file, defaultLanguage.dir+"/")
+		}
+		missing := []string{}
+		for _, language := range languages[1:] {
+			translation := path.Join(language.dir, page)
+			if inBase[translation] && !inRevision[translation] {
+				missing = append(missing, language.code)
+			}
+		}
+		if len(missing) > 0 {
+			lost = append(lost, lostTranslation{page: file, languages: missing})
+		}
+	}
+	sort.Slice(lost, func(i, j int) bool {
+		return lost[i].page < lost[j].page
+	})
+	return lost
+}
+
+// printLanguageBreakdown shows how many output files changed in each
+// language, and which pages lost translations. It returns the languages, or
+// nil if the site only has one.
+func printLanguageBreakdown(outputRepo git.Repository, base, revision BuiltRevision) ([]siteLanguage, error) {
+	languages, baseFiles, revisionFiles, err := outputLanguages(outputRepo, base, revision)
+	if err != nil {
+		out.Outf("Couldn't work out the languages of the site, so changes won't be broken down by language: %v\n", err)
+		return nil, nil
+	}
+	if languages == nil {
+		return nil, nil
+	}
+	changes, err := outputRepo.ChangedFiles(base.Output, revision.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	byLanguage := map[string]map[git.ChangeStatus]int{}
+	for _, change := range changes {
+		code := languageOf(languages, change.Path)
+		if byLanguage[code] == nil {
+			byLanguage[code] = map[git.ChangeStatus]int{}
+		}
+		byLanguage[code][change.Status]++
+	}
+	codes := []string{}
+	for _, language := range languages {
+		codes = append(codes, language.code)
+	}
+	if byLanguage[""] != nil {
+		codes = append(codes, "")
+	}
+
+	out.Outln("Changes by language:")
+	for _, code := range codes {
+		name := code
+		if code == "" {
+			name = "not in any language"
+		}
+		counts := byLanguage[code]
+		total := counts[git.Added] + counts[git.Deleted] + counts[git.Modified] + counts[git.TypeChanged]
+		if total == 0 {
+			out.Outf("  %s: no changes\n", name)
+			continue
+		}
+		out.Outf("  %s: %d files changed (%d added, %d deleted, %d modified)\n",
+			name, total, counts[git.Added], counts[git.Deleted], counts[git.Modified]+counts[git.TypeChanged])
+	}
+
+	lost := findLostTranslations(languages, baseFiles, revisionFiles)
+	if len(lost) > 0 {
+		out.Outf("Pages in %s which lost translations (%d):\n", languages[0].code, len(lost))
+		for _, page := range lost {
+			out.Outf("  %s: no longer in %s\n", page.page, strings.Join(page.languages, ", "))
+		}
+	}
+	return languages, nil
+}
+
+// languageDiffArgs returns arguments for git diff which group the diff (and
+// --stat etc.) by language: the languages with their own directory first, and
+// then everything else. They refer to a file which gets written to the output
+// repo's git directory.
+func languageDiffArgs(repoDir string, languages []siteLanguage) ([]string, error) {
+	if languages == nil {
+		return nil, nil
+	}
+	var order strings.Builder
+	for _, language := range languages {
+		if language.dir != "" {
+			fmt.Fprintln(&order, language.dir)
+		}
+	}
+	// Everything else (i.e. the language at the root, and files which aren't
+	// in any language) comes last, because it doesn't match.
+	orderFile := filepath.Join(repoDir, ".git", "grouse-language-order")
+	if err := ioutil.WriteFile(orderFile, []byte(order.String()), 0644); err != nil {
+		return nil, err
+	}
+	return []string{"-O" + orderFile}, nil
+}
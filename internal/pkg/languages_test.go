@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestDetectLanguagesFromConfig(t *testing.T) {
+	c := qt.New(t)
+	config := hugoConfig{
+		"defaultcontentlanguage":    `"en"`,
+		"languages.en.languagename": `"English"`,
+		"languages.de.languagename": `"Deutsch"`,
+		"languages.fr.contentdir":   `"content/fr"`,
+		"languages.fr.languagename": `"Français"`,
+		"params.description":        `"Hi"`,
+	}
+	files := []string{"index.html", "css/main.css", "de/index.html", "fr/index.html"}
+	c.Check(languageDirs(detectLanguages(config, files, nil)), qt.DeepEquals, []string{"en:", "de:de", "fr:fr"})
+
+	// With defaultContentLanguageInSubdir.
+	files = []string{"index.html", "en/index.html", "de/index.html", "fr/index.html"}
+	c.Check(detectLanguages(config, files, nil)[0], qt.Equals, siteLanguage{code: "en", dir: "en"})
+
+	c.Check(detectLanguages(hugoConfig{"languages.en.weight": "1"}, files, nil), qt.IsNil)
+	c.Check(detectLanguages(nil, files, nil), qt.IsNil)
+}
+
+func TestDetectLanguagesFromSitemap(t *testing.T) {
+	c := qt.New(t)
+	sitemap := []byte(`<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>https://example.org/de/sitemap.xml</loc></sitemap>
+	<sitemap><loc>https://example.org/nl/sitemap.xml</loc></sitemap>
+</sitemapindex>`)
+	// The default language is the one without a directory.
+	files := []string{"index.html", "sitemap.xml", "de/sitemap.xml", "nl/index.html", "nl/sitemap.xml"}
+	c.Check(languageDirs(detectLanguages(nil, files, sitemap)), qt.DeepEquals, []string{"de:", "nl:nl"})
+
+	c.Check(detectLanguages(nil, files, []byte("<urlset></urlset>")), qt.IsNil)
+}
+
+func TestLanguageOf(t *testing.T) {
+	c := qt.New(t)
+	languages := []siteLanguage{{code: "en", dir: ""}, {code: "de", dir: "de"}}
+	c.Check(languageOf(languages, "de/posts/index.html"), qt.Equals, "de")
+	c.Check(languageOf(languages, "posts/index.html"), qt.Equals, "en")
+	c.Check(languageOf(languages, "design/index.html"), qt.Equals, "en")
+
+	languages = []siteLanguage{{code: "en", dir: "en"}, {code: "de", dir: "de"}}
+	c.Check(languageOf(languages, "css/main.css"), qt.Equals, "")
+}
+
+func TestFindLostTranslations(t *testing.T) {
+	c := qt.New(t)
+	languages := []siteLanguage{{code: "en", dir: ""}, {code: "de", dir: "de"}, {code: "fr", dir: "fr"}}
+	lost := findLostTranslations(languages,
+		[]string{
+			"index.html", "about/index.html", "posts/a/index.html",
+			"de/index.html", "de/about/index.html", "de/posts/a/index.html",
+			"fr/index.html", "fr/about/index.html",
+		},
+		[]string{
+			"index.html", "about/index.html",
+			"de/index.html",
+			"fr/index.html",
+		})
+	c.Assert(lost, qt.HasLen, 1)
+	c.Check(lost[0].page, qt.Equals, "about/index.html")
+	c.Check(lost[0].languages, qt.DeepEquals, []string{"de", "fr"})
+}
+
+func TestLanguageDiffArgs(t *testing.T) {
+	c := qt.New(t)
+	repoDir, err := ioutil.TempDir("", "grouse-languages-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(repoDir)
+	c.Assert(os.Mkdir(filepath.Join(repoDir, ".git"), 0755), qt.IsNil)
+
+	args, err := languageDiffArgs(repoDir, nil)
+	c.Assert(err, qt.IsNil)
+	c.Check(args, qt.HasLen, 0)
+
+	args, err = languageDiffArgs(repoDir, []siteLanguage{{code: "en", dir: ""}, {code: "de", dir: "de"}, {code: "fr", dir: "fr"}})
+	c.Assert(err, qt.IsNil)
+	orderFile := filepath.Join(repoDir, ".git", "grouse-language-order")
+	c.Check(args, qt.DeepEquals, []string{"-O" + orderFile})
+	order, err := ioutil.ReadFile(orderFile)
+	c.Assert(err, qt.IsNil)
+	c.Check(string(order), qt.Equals, "de\nfr\n")
+}
+
+// languageDirs describes each language as "code:dir", for comparing.
+func languageDirs(languages []siteLanguage) []string {
+	described := []string{}
+	for _, language := range languages {
+		described = append(described, language.code+":"+language.dir)
+	}
+	return described
+}
@@ -0,0 +1,315 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/git"
+)
+
+// The most that a Markdown summary can be, in bytes. GitHub doesn't allow
+// comments longer than 65536 characters, and this leaves some room for
+// whatever else goes in the comment.
+const markdownSizeLimit = 60000
+
+// The most pages to list as added, removed or modified, the most sections to
+// show in the table, and the most lines of each file's diff to show, in a
+// Markdown summary.
+const (
+	markdownMaxPages     = 25
+	markdownMaxSections  = 50
+	markdownMaxDiffLines = 40
+)
+
+// markdownPages is the changed pages in one revision, by how they changed.
+type markdownPages map[git.ChangeStatus][]string
+
+// sectionCounts is how many pages changed in each way in a section.
+type sectionCounts map[git.ChangeStatus]int
+
+// writeMarkdownSummary writes a summary of how each revision differs from
+// base, suitable for a comment on a pull request: which pages changed (as
+// public URLs, if Hugo's baseURL is known), how many changed in each section,
+// and a collapsed diff for each file, as far as they fit.
+func writeMarkdownSummary(w io.Writer, outputRepo git.Repository, base BuiltRevision, revisions []revisionChanges) error {
+	summaries := []string{}
+	size := 0
+	withChanges := 0
+	for _, revision := range revisions {
+		// Each summary gets its share of the limit, so that there's always
+		// room for all of them.
+		summary := markdownRevisionSummary(outputRepo, base, revision, len(revisions) > 1, markdownSizeLimit/len(revisions))
+		summaries = append(summaries, summary)
+		size += len(summary)
+		if len(revision.changes) > 0 {
+			withChanges++
+		}
+	}
+
+	// The diffs get whatever room the summaries leave, shared between the
+	// revisions.
+	diffBudget := 0
+	if withChanges > 0 && size < markdownSizeLimit {
+		diffBudget = (markdownSizeLimit - size) / withChanges
+	}
+	var doc strings.Builder
+	for i, revision := range revisions {
+		doc.WriteString(summaries[i])
+		diffs, err := markdownDiffs(outputRepo, base, revision, diffBudget)
+		if err != nil {
+			return err
+		}
+		doc.WriteString(diffs)
+	}
+	_, err := io.WriteString(w, doc.String())
+	return err
+}
+
+// markdownRef describes revision in Markdown, e.g. "`main` (`1a2b3c4`)".
+func markdownRef(revision BuiltRevision) string {
+	if revision.Source == git.NilHash {
+		return "`" + revision.Name + "`"
+	}
+	short := string(revision.Source)
+	if len(short) > 7 {
+		short = short[:7]
+	}
+	return fmt.Sprintf("`%s` (`%s`)", revision.Name, short)
+}
+
+// markdownRevisionSummary summarizes how revision differs from base, in at
+// most budget bytes (apart from the headings). The section table and the
+// lists of pages get cut short if they don't fit.
+func markdownRevisionSummary(outputRepo git.Repository, base BuiltRevision, revision revisionChanges, several bool, budget int) string {
+	var doc strings.Builder
+	if several {
+		fmt.Fprintf(&doc, "### %s, compared to %s\n\n", markdownRef(revision.revision), markdownRef(base))
+	} else {
+		fmt.Fprintf(&doc, "### Changes from %s to %s\n\n", markdownRef(base), markdownRef(revision.revision))
+	}
+	if len(revision.changes) == 0 {
+		doc.WriteString("No output files changed.\n\n")
+		return doc.String()
+	}
+
+	// Not knowing the languages only makes the sections less accurate.
+	languages, _, _, _ := outputLanguages(outputRepo, base, revision.revision)
+	site := newAttributionSite(base, revision.revision, languages)
+	pages := markdownPages{}
+	bySection := map[string]sectionCounts{}
+	others := 0
+	for _, change := range revision.changes {
+		if path.Ext(change.Path) != ".html" {
+			others++
+			continue
+		}
+		status := change.Status
+		if status == git.TypeChanged {
+			status = git.Modified
+		}
+		pages[status] = append(pages[status], change.Path)
+		section := pageSection(site, base, revision.revision, change.Path)
+		if bySection[section] == nil {
+			bySection[section] = sectionCounts{}
+		}
+		bySection[section][status]++
+	}
+
+	total := len(pages[git.Added]) + len(pages[git.Deleted]) + len(pages[git.Modified])
+	fmt.Fprintf(&doc, "**%s changed**: %d added, %d removed, %d modified.",
+		countOf(total, "page", "pages"), len(pages[git.Added]), len(pages[git.Deleted]), len(pages[git.Modified]))
+	if others > 0 {
+		fmt.Fprintf(&doc, " %s changed too.", countOf(others, "other file", "other files"))
+	}
+	doc.WriteString("\n\n")
+	if total == 0 {
+		return doc.String()
+	}
+
+	sections := []string{}
+	for section := range bySection {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	rows := []string{}
+	for _, section := range sections {
+		counts := bySection[section]
+		rows = append(rows, fmt.Sprintf("| %s | %d | %d | %d |\n",
+			strings.Replace(section, "|", `\|`, -1), counts[git.Added], counts[git.Deleted], counts[git.Modified]))
+	}
+	writeLimitedList(&doc, "| Section | Added | Removed | Modified |\n| --- | ---: | ---: | ---: |\n", rows, markdownMaxSections, budget, func(count int) string {
+		return fmt.Sprintf("| …and %s | | | |\n", countOf(count, "more section", "more sections"))
+	})
+
+	for _, list := range []struct {
+		status  git.ChangeStatus
+		heading string
+		config  hugoConfig
+	}{
+		{git.Added, "Added pages", revision.revision.Config},
+		{git.Deleted, "Removed pages", base.Config},
+		{git.Modified, "Modified pages", revision.revision.Config},
+	} {
+		listed := pages[list.status]
+		if len(listed) == 0 {
+			continue
+		}
+		items := []string{}
+		for _, page := range listed {
+			items = append(items, fmt.Sprintf("- %s\n", markdownPageURL(list.config, page)))
+		}
+		writeLimitedList(&doc, fmt.Sprintf("**%s**\n\n", list.heading), items, markdownMaxPages, budget, func(count int) string {
+			return fmt.Sprintf("- …and %d more\n", count)
+		})
+	}
+	return doc.String()
+}
+
+// writeLimitedList writes heading and then up to maxItems of items, followed
+// by a blank line, to doc. Items which don't fit, either because there are
+// more than maxItems or because doc would get longer than limit, are summed
+// up by more instead. If not even the heading and that fit, nothing gets
+// written.
+func writeLimitedList(doc *strings.Builder, heading string, items []string, maxItems int, limit int, more func(count int) string) {
+	// Room for the blank line at the end.
+	limit--
+	if doc.Len()+len(heading)+len(more(len(items))) > limit {
+		return
+	}
+	doc.WriteString(heading)
+	for i, item := range items {
+		rest := len(items) - i
+		// There has to be room to say how many didn't fit after this one,
+		// unless it's the last one.
+		after := 0
+		if rest > 1 {
+			after = len(more(rest - 1))
+		}
+		if i == maxItems || doc.Len()+len(item)+after > limit {
+			doc.WriteString(more(rest))
+			break
+		}
+		doc.WriteString(item)
+	}
+	doc.WriteString("\n")
+}
+
+// pageSection returns the section that the page at relPath is in, according
+// to its content file if there is one, and otherwise going by its path. The
+// home page, and pages which aren't in a section, are in "/".
+func pageSection(site attributionSite, base, revision BuiltRevision, relPath string) string {
+	if contentFile, ok := site.contentFiles[relPath]; ok {
+		for _, content := range []contentInventory{revision.Content, base.Content} {
+			if entry, ok := content[contentFile]; ok {
+				if entry.section == "" {
+					return "/"
+				}
+				return entry.section
+			}
+		}
+	}
+	page := site.pageAt(relPath)
+	if page.kind == kindHome || page.section == "" {
+		return "/"
+	}
+	return page.section
+}
+
+// markdownPageURL returns where the page at relPath is on the site, if the
+// baseURL in config says, or otherwise its path from the root of the site.
+func markdownPageURL(config hugoConfig, relPath string) string {
+	p := strings.TrimSuffix(relPath, "index.html")
+	var baseURL string
+	json.Unmarshal([]byte(config["baseurl"]), &baseURL)
+	if strings.HasPrefix(baseURL, "http://") || strings.HasPrefix(baseURL, "https://") {
+		// GitHub links these by itself.
+		return strings.TrimSuffix(baseURL, "/") + "/" + p
+	}
+	return "`/" + p + "`"
+}
+
+func countOf(count int, singular, plural string) string {
+	if count == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", count, plural)
+}
+
+// markdownDiffs returns a collapsed diff for each file in revision, in as
+// many bytes as budget allows. Files that don't fit get left out, with a
+// notice saying so, if there's room for it.
+func markdownDiffs(outputRepo git.Repository, base BuiltRevision, revision revisionChanges, budget int) (string, error) {
+	notShown := func(count int) string {
+		return fmt.Sprintf("_The diffs of %s aren't shown, to keep this short enough for a comment._\n\n",
+			countOf(count, "more file", "more files"))
+	}
+	var doc strings.Builder
+	for i, change := range revision.changes {
+		rest := len(revision.changes) - i
+		// Stopping after this one has to leave room for the notice.
+		after := 0
+		if rest > 1 {
+			after = len(notShown(rest - 1))
+		}
+		if doc.Len()+after < budget {
+			diff, err := outputRepo.FileDiff(base.Output, revision.revision.Output, change.Path)
+			if err != nil {
+				return "", err
+			}
+			if block := markdownDiffBlock(change.Path, diff); doc.Len()+len(block)+after <= budget {
+				doc.WriteString(block)
+				continue
+			}
+		}
+		if notice := notShown(rest); doc.Len()+len(notice) <= budget {
+			doc.WriteString(notice)
+		}
+		break
+	}
+	return doc.String(), nil
+}
+
+// markdownDiffBlock returns the diff of the file at relPath in a collapsed
+// <details> block, without git's headers and cut down to
+// markdownMaxDiffLines.
+func markdownDiffBlock(relPath string, diff string) string {
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	added, removed := 0, 0
+	start := len(lines)
+	for i, line := range lines {
+		if start == len(lines) && (strings.HasPrefix(line, "@@") || strings.HasPrefix(line, "Binary files ")) {
+			start = i
+		}
+		if i > start && strings.HasPrefix(line, "+") {
+			added++
+		} else if i > start && strings.HasPrefix(line, "-") {
+			removed++
+		}
+	}
+	lines = lines[start:]
+	omitted := 0
+	if len(lines) > markdownMaxDiffLines {
+		omitted = len(lines) - markdownMaxDiffLines
+		lines = lines[:markdownMaxDiffLines]
+	}
+	body := strings.Join(lines, "\n")
+
+	// The fence has to be longer than any run of backticks in the diff.
+	fence := "```"
+	for strings.Contains(body, fence) {
+		fence += "`"
+	}
+	var block strings.Builder
+	fmt.Fprintf(&block, "<details><summary><code>%s</code> (+%d −%d)</summary>\n\n", html.EscapeString(relPath), added, removed)
+	fmt.Fprintf(&block, "%sdiff\n%s\n%s\n\n", fence, body, fence)
+	if omitted > 0 {
+		fmt.Fprintf(&block, "%s not shown.\n\n", countOf(omitted, "more line", "more lines"))
+	}
+	block.WriteString("</details>\n\n")
+	return block.String()
+}
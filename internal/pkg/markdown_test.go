@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/mocks"
+	qt "github.com/frankban/quicktest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMarkdownSummary(t *testing.T) {
+	c := qt.New(t)
+	repo := new(mocks.Repository)
+	repo.On("ListFiles", mock.Anything, ".").Return([]string{}, nil)
+	repo.On("ReadFile", mock.Anything, "sitemap.xml").Return(nil, errors.New("no sitemap"))
+	repo.On("FileDiff", git.Hash("a"), git.Hash("b"), "posts/hello/index.html").Return(
+		"diff --git a/posts/hello/index.html b/posts/hello/index.html\n--- a/posts/hello/index.html\n+++ b/posts/hello/index.html\n@@ -1 +1 @@\n-Hello\n+Hello, world\n", nil)
+	repo.On("FileDiff", git.Hash("a"), git.Hash("b"), mock.Anything).Return("", nil)
+
+	config := hugoConfig{"baseurl": `"https://example.com/"`}
+	base := BuiltRevision{Name: "main", Source: "0123456789abcdef", Output: "a", Raw: "a", Config: config}
+	revision := BuiltRevision{Name: "feature", Source: "fedcba9876543210", Output: "b", Raw: "b", Config: config}
+	var buf bytes.Buffer
+	err := writeMarkdownSummary(&buf, repo, base, []revisionChanges{{revision: revision, changes: []git.FileChange{
+		{Status: git.Modified, Path: "posts/hello/index.html"},
+		{Status: git.Added, Path: "posts/new/index.html"},
+		{Status: git.Deleted, Path: "about/index.html"},
+		{Status: git.Modified, Path: "index.xml"},
+	}}})
+	c.Assert(err, qt.IsNil)
+	summary := buf.String()
+	c.Check(summary, qt.Contains, "### Changes from `main` (`0123456`) to `feature` (`fedcba9`)\n")
+	c.Check(summary, qt.Contains, "**3 pages changed**: 1 added, 1 removed, 1 modified. 1 other file changed too.\n")
+	c.Check(summary, qt.Contains, "| about | 0 | 1 | 0 |\n| posts | 1 | 0 | 1 |\n")
+	c.Check(summary, qt.Contains, "**Removed pages**\n\n- https://example.com/about/\n")
+	c.Check(summary, qt.Contains, "<details><summary><code>posts/hello/index.html</code> (+1 −1)</summary>\n\n```diff\n@@ -1 +1 @@\n-Hello\n+Hello, world\n```\n")
+}
+
+func TestMarkdownSummaryStaysWithinLimit(t *testing.T) {
+	c := qt.New(t)
+	repo := new(mocks.Repository)
+	repo.On("ListFiles", mock.Anything, ".").Return([]string{}, nil)
+	repo.On("ReadFile", mock.Anything, "sitemap.xml").Return(nil, errors.New("no sitemap"))
+	repo.On("FileDiff", mock.Anything, mock.Anything, mock.Anything).Return(
+		"@@ -1 +1 @@\n-"+strings.Repeat("old ", 500)+"\n+"+strings.Repeat("new ", 500)+"\n", nil)
+
+	changes := []git.FileChange{}
+	for i := 0; i < 3000; i++ {
+		changes = append(changes, git.FileChange{Status: git.Added, Path: fmt.Sprintf("section-%d/page/index.html", i)})
+	}
+	base := BuiltRevision{Name: "main", Output: "a", Raw: "a"}
+	revisions := []revisionChanges{}
+	for _, name := range []string{"one", "two", "three"} {
+		revisions = append(revisions, revisionChanges{revision: BuiltRevision{Name: name, Output: "b", Raw: "b"}, changes: changes})
+	}
+	var buf bytes.Buffer
+	c.Assert(writeMarkdownSummary(&buf, repo, base, revisions), qt.IsNil)
+	summary := buf.String()
+	c.Check(len(summary) <= markdownSizeLimit, qt.Equals, true, qt.Commentf("%d bytes", len(summary)))
+	c.Check(summary, qt.Contains, "more sections | | | |\n")
+	c.Check(summary, qt.Contains, "- …and 2975 more\n")
+}
+
+func TestWriteLimitedList(t *testing.T) {
+	c := qt.New(t)
+	more := func(count int) string {
+		return fmt.Sprintf("%d more\n", count)
+	}
+	items := []string{"one\n", "two\n", "three\n"}
+	write := func(maxItems, limit int) string {
+		var doc strings.Builder
+		writeLimitedList(&doc, "list:\n", items, maxItems, limit, more)
+		return doc.String()
+	}
+	c.Check(write(3, 100), qt.Equals, "list:\none\ntwo\nthree\n\n")
+	c.Check(write(1, 100), qt.Equals, "list:\none\n2 more\n\n")
+	c.Check(write(3, 22), qt.Equals, "list:\none\ntwo\nthree\n\n")
+	// "two" fits, but then there isn't room to say that "three" didn't.
+	c.Check(write(3, 21), qt.Equals, "list:\none\n2 more\n\n")
+	c.Check(write(3, 5), qt.Equals, "")
+}
+
+func TestMarkdownDiffBlock(t *testing.T) {
+	c := qt.New(t)
+	lines := []string{"@@ -0,0 +1,100 @@"}
+	for i := 0; i < 100; i++ {
+		lines = append(lines, "+```")
+	}
+	block := markdownDiffBlock("a<b>.html", strings.Join(lines, "\n")+"\n")
+	c.Check(block, qt.Contains, "<code>a&lt;b&gt;.html</code> (+100 −0)")
+	// The fence can't be closed by the diff.
+	c.Check(block, qt.Contains, "````diff\n")
+	c.Check(block, qt.Contains, "61 more lines not shown.")
+
+	block = markdownDiffBlock("logo.png", "diff --git a/logo.png b/logo.png\nBinary files a/logo.png and b/logo.png differ\n")
+	c.Check(block, qt.Contains, "```diff\nBinary files a/logo.png and b/logo.png differ\n```")
+}
+
+func TestMarkdownPageURL(t *testing.T) {
+	c := qt.New(t)
+	c.Check(markdownPageURL(hugoConfig{"baseurl": `"https://example.com/blog/"`}, "posts/index.html"), qt.Equals, "https://example.com/blog/posts/")
+	c.Check(markdownPageURL(hugoConfig{"baseurl": `"/"`}, "index.html"), qt.Equals, "`/`")
+	c.Check(markdownPageURL(nil, "posts/hello.html"), qt.Equals, "`/posts/hello.html`")
+}
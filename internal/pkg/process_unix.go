@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package pkg
+
+import "syscall"
+
+func isProcessRunning(pid int) bool {
+	// Signal 0 doesn't do anything, but still checks whether the process
+	// exists. EPERM means that it exists, but belongs to someone else.
+	err := syscall.Kill(pid, syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,14 @@
+package pkg
+
+import "os"
+
+func isProcessRunning(pid int) bool {
+	// On Windows, FindProcess actually opens a handle to the process, so it
+	// fails if the process doesn't exist.
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
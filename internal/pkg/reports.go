@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+	"github.com/pkg/errors"
+)
+
+// reportFormat is how grouse shows the differences it finds: interactively, as
+// text and a diff, or as a file for some other tool to pick up.
+type reportFormat string
+
+const (
+	reportText     reportFormat = "text"
+	reportMarkdown reportFormat = "markdown"
+	reportJUnit    reportFormat = "junit"
+	reportSARIF    reportFormat = "sarif"
+)
+
+func parseReportFormat(format string) (reportFormat, error) {
+	switch f := reportFormat(format); f {
+	case "":
+		return reportText, nil
+	case reportText, reportMarkdown, reportJUnit, reportSARIF:
+		return f, nil
+	default:
+		return "", fmt.Errorf("Unknown format '%s'; expected text, markdown, junit or sarif", format)
+	}
+}
+
+// replacesDiff reports whether the format is written by writeReport, instead
+// of showing the diff.
+func (f reportFormat) replacesDiff() bool {
+	return f != "" && f != reportText
+}
+
+// writeReport writes the report in userArgs.reportFormat about how each of
+// revisions differs from base, to userArgs.reportTo or stdout. It's what
+// happens instead of the diff, for formats other than reportText.
+func writeReport(outputRepo git.Repository, base BuiltRevision, revisions []BuiltRevision, userArgs cmdArgs) (err error) {
+	reports := []revisionChanges{}
+	for _, revision := range revisions {
+		changes, err := reportedChanges(outputRepo, base, revision, userArgs.formats)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, revisionChanges{revision: revision, changes: changes})
+	}
+
+	var w io.Writer = os.Stdout
+	if userArgs.reportTo != "" {
+		f, err := os.Create(userArgs.reportTo)
+		if err != nil {
+			return errors.WithMessagef(err, "Couldn't write the report to %s", userArgs.reportTo)
+		}
+		defer func() {
+			if closeErr := f.Close(); err == nil && closeErr != nil {
+				err = errors.WithMessagef(closeErr, "Couldn't write the report to %s", userArgs.reportTo)
+			}
+			if err == nil {
+				out.Outf("Saved the report to %s\n", userArgs.reportTo)
+			}
+		}()
+		w = f
+	}
+
+	switch userArgs.reportFormat {
+	case reportMarkdown:
+		err = writeMarkdownSummary(w, outputRepo, base, reports)
+	case reportJUnit, reportSARIF:
+		var checks []revisionChecks
+		if checks, err = runChecks(outputRepo, base, reports, userArgs.sizeBudgets); err != nil {
+			break
+		}
+		if userArgs.reportFormat == reportJUnit {
+			err = writeJUnitReport(w, base, checks)
+		} else {
+			err = writeSARIFReport(w, base, checks)
+		}
+	default:
+		panic(fmt.Sprintf("Can't write a report in format %s", userArgs.reportFormat))
+	}
+	if err != nil && userArgs.reportTo != "" {
+		return errors.WithMessagef(err, "Couldn't write the report to %s", userArgs.reportTo)
+	}
+	return err
+}
+
+// reportedChanges returns the output files that changed between base and
+// revision, in the given output formats (or all of them, if there aren't
+// any).
+func reportedChanges(outputRepo git.Repository, base, revision BuiltRevision, formats []string) ([]git.FileChange, error) {
+	changes, err := outputRepo.ChangedFiles(base.Output, revision.Output)
+	if err != nil || len(formats) == 0 {
+		return changes, err
+	}
+	known := mergeOutputFormats(outputFormatsOf(revision.Config), outputFormatsOf(base.Config))
+	paths, err := pathsInFormats(known, changes, formats)
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{}
+	for _, p := range paths {
+		wanted[p] = true
+	}
+	selected := []git.FileChange{}
+	for _, change := range changes {
+		if wanted[change.Path] {
+			selected = append(selected, change)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+)
+
+// BuiltRevision is a revision whose output has been built (or imported) and
+// committed to the output repo.
+type BuiltRevision struct {
+	// A plain name for the revision, e.g. the ref that the user passed.
+	Name string
+	// What to call the revision in messages to the user.
+	Description string
+	// The commit in the user's repo that got built, or NilHash if the output
+	// was imported from a directory.
+	Source git.Hash
+	// The commit in the output repo that holds the output, after any filters
+	// have run over it. This is what gets diffed.
+	Output git.Hash
+	// The commit in the output repo that holds the output exactly as it was
+	// built. It's the same as Output if there aren't any filters.
+	Raw git.Hash
+	// Hugo's configuration for the revision, or nil if it wasn't read, e.g.
+	// because the output was imported.
+	Config hugoConfig
+	// The revision's content files, according to `hugo list`, or nil if it
+	// wasn't read.
+	Content contentInventory
+	// The source files that changed since the base revision, relative to the
+	// Hugo site, or nil if they weren't worked out (e.g. because either
+	// revision was imported).
+	SourceChanges []git.FileChange
+	// The warnings that Hugo printed while building the revision, with the
+	// paths in them made relative to the Hugo site.
+	BuildWarnings []string
+	// A git repo with the revision's source commit in it, and its submodules
+	// checked out as far as possible, for diffing the source; "" if the
+	// output was imported. SiteDir is where the Hugo site is, relative to its
+	// root.
+	SourceDir string
+	SiteDir   string
+}
+
+func (b BuiltRevision) String() string {
+	return b.Description
+}
+
+// revisionChanges is the set of output files that changed between the base
+// revision and another revision.
+type revisionChanges struct {
+	revision BuiltRevision
+	changes  []git.FileChange
+}
+
+// overlap is an output file that changed in more than one revision, compared
+// to the base revision.
+type overlap struct {
+	path string
+	// Indexes into the revisions passed to findOverlaps.
+	revisions []int
+}
+
+func changesAgainstBase(outputRepo git.Repository, base BuiltRevision, revisions []BuiltRevision) ([]revisionChanges, error) {
+	all := []revisionChanges{}
+	for _, revision := range revisions {
+		changes, err := outputRepo.ChangedFiles(base.Output, revision.Output)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, revisionChanges{revision: revision, changes: changes})
+	}
+	return all, nil
+}
+
+// findOverlaps returns the files that changed in more than one of the given
+// revisions, sorted by path. These are likely to conflict, or at least
+// interact, when the revisions get merged.
+func findOverlaps(revisions []revisionChanges) []overlap {
+	byPath := map[string][]int{}
+	for i, revision := range revisions {
+		for _, change := range revision.changes {
+			byPath[change.Path] = append(byPath[change.Path], i)
+		}
+	}
+	overlaps := []overlap{}
+	for p, indexes := range byPath {
+		if len(indexes) > 1 {
+			overlaps = append(overlaps, overlap{path: p, revisions: indexes})
+		}
+	}
+	sort.Slice(overlaps, func(i, j int) bool {
+		return overlaps[i].path < overlaps[j].path
+	})
+	return overlaps
+}
+
+func printRevisionSummary(base BuiltRevision, revisions []revisionChanges) {
+	out.Outf("Changes compared to %s:\n", base)
+	for _, revision := range revisions {
+		counts := map[git.ChangeStatus]int{}
+		for _, change := range revision.changes {
+			counts[change.Status]++
+		}
+		out.Outf("  %s: %d files changed (%d added, %d deleted, %d modified)\n",
+			revision.revision, len(revision.changes),
+			counts[git.Added], counts[git.Deleted], counts[git.Modified]+counts[git.TypeChanged])
+	}
+
+	overlaps := findOverlaps(revisions)
+	if len(overlaps) == 0 {
+		out.Outln("No output files were changed by more than one revision.")
+		return
+	}
+	out.Outln("Output files changed by more than one revision:")
+	for _, o := range overlaps {
+		refs := []string{}
+		for _, i := range o.revisions {
+			refs = append(refs, revisions[i].revision.Name)
+		}
+		out.Outf("  %s: %v\n", o.path, refs)
+	}
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// revisionReportDir returns a directory inside reportDir for the report about
+// the revision at the given (zero-based) position on the command line.
+// The position is included because ref names aren't unique once they've
+// been made safe for filenames.
+func revisionReportDir(reportDir string, position int, revision BuiltRevision) string {
+	name := strconv.Itoa(position+1) + "-" + unsafePathChars.ReplaceAllString(revision.Name, "_")
+	return filepath.Join(reportDir, name)
+}
@@ -0,0 +1,23 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/capnfabs/grouse/internal/git"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestFindOverlaps(t *testing.T) {
+	c := qt.New(t)
+	revisions := []revisionChanges{
+		{changes: []git.FileChange{{Status: git.Modified, Path: "index.html"}, {Status: git.Added, Path: "a/index.html"}}},
+		{changes: []git.FileChange{{Status: git.Modified, Path: "sitemap.xml"}}},
+		{changes: []git.FileChange{{Status: git.Deleted, Path: "index.html"}, {Status: git.Modified, Path: "sitemap.xml"}}},
+	}
+	overlaps := findOverlaps(revisions)
+	c.Assert(overlaps, qt.HasLen, 2)
+	c.Check(overlaps[0].path, qt.Equals, "index.html")
+	c.Check(overlaps[0].revisions, qt.DeepEquals, []int{0, 2})
+	c.Check(overlaps[1].path, qt.Equals, "sitemap.xml")
+	c.Check(overlaps[1].revisions, qt.DeepEquals, []int{1, 2})
+}
@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+)
+
+// Output files aren't in the repo, so their locations are relative to this,
+// rather than to the root of the repo.
+const sarifOutputBase = "OUTPUT"
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool               `json:"tool"`
+	AutomationDetails  sarifAutomationDetails  `json:"automationDetails"`
+	OriginalURIBaseIDs map[string]sarifURIBase `json:"originalUriBaseIds"`
+	Results            []sarifResult           `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifAutomationDetails struct {
+	ID string `json:"id"`
+}
+
+type sarifURIBase struct {
+	Description sarifMessage `json:"description"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// writeSARIFReport writes what the checks found as SARIF, with a run for each
+// revision. Each result points at the source file that probably caused it if
+// that's known, and otherwise at the file in the output; it points at both if
+// it can.
+func writeSARIFReport(w io.Writer, base BuiltRevision, revisions []revisionChecks) error {
+	rules := []sarifRule{}
+	levels := map[string]string{}
+	for _, check := range siteChecks {
+		rules = append(rules, sarifRule{ID: check.name, ShortDescription: sarifMessage{Text: check.description}})
+		levels[check.name] = "warning"
+		if check.isError {
+			levels[check.name] = "error"
+		}
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{},
+	}
+	for _, revision := range revisions {
+		run := sarifRun{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "grouse",
+				InformationURI: "https://github.com/capnfabs/grouse",
+				Rules:          rules,
+			}},
+			AutomationDetails: sarifAutomationDetails{ID: "grouse/" + revision.revision.Name + "/"},
+			OriginalURIBaseIDs: map[string]sarifURIBase{
+				sarifOutputBase: {Description: sarifMessage{Text: "The output of the Hugo site"}},
+			},
+			Results: []sarifResult{},
+		}
+		for _, result := range revision.results {
+			sarif := sarifResult{RuleID: result.check, Level: levels[result.check], Message: sarifMessage{Text: result.message}}
+			var output *sarifLocation
+			if result.outputPath != "" {
+				output = &sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: result.outputPath, URIBaseID: sarifOutputBase},
+				}}
+			}
+			if result.sourcePath != "" {
+				source := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: path.Join(revision.revision.SiteDir, result.sourcePath)},
+				}}
+				if result.sourceLine > 0 {
+					source.PhysicalLocation.Region = &sarifRegion{StartLine: result.sourceLine}
+				}
+				sarif.Locations = []sarifLocation{source}
+				if output != nil {
+					sarif.RelatedLocations = []sarifLocation{*output}
+				}
+			} else if output != nil {
+				sarif.Locations = []sarifLocation{*output}
+			} else {
+				sarif.Locations = []sarifLocation{}
+			}
+			run.Results = append(run.Results, sarif)
+		}
+		log.Runs = append(log.Runs, run)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
@@ -0,0 +1,113 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+	"github.com/spf13/cobra"
+)
+
+const (
+	scratchDirPrefix = "grouse-diff"
+	// Every scratch directory gets a file containing the PID of the grouse
+	// process that owns it, so that `grouse clean` can tell which ones are
+	// still in use.
+	pidFileName = "grouse.pid"
+)
+
+// newScratchDir creates a temporary directory for a single grouse run.
+func newScratchDir() (string, error) {
+	scratchDir, err := ioutil.TempDir("", scratchDirPrefix)
+	if err != nil {
+		return "", err
+	}
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	if err := ioutil.WriteFile(filepath.Join(scratchDir, pidFileName), pid, 0644); err != nil {
+		os.RemoveAll(scratchDir)
+		return "", err
+	}
+	return scratchDir, nil
+}
+
+// findStaleScratchDirs returns all the scratch directories in baseDir which
+// aren't owned by a running grouse process.
+func findStaleScratchDirs(baseDir string) ([]string, error) {
+	files, err := ioutil.ReadDir(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	stale := []string{}
+	for _, file := range files {
+		if !file.IsDir() || !strings.HasPrefix(file.Name(), scratchDirPrefix) {
+			continue
+		}
+		dir := filepath.Join(baseDir, file.Name())
+		if isScratchDirInUse(dir) {
+			out.Debugf("Skipping %s, it's still in use\n", dir)
+			continue
+		}
+		stale = append(stale, dir)
+	}
+	return stale, nil
+}
+
+func isScratchDirInUse(dir string) bool {
+	content, err := ioutil.ReadFile(filepath.Join(dir, pidFileName))
+	if err != nil {
+		// Either it's from an old version of grouse that didn't write PID
+		// files, or the run crashed before it got that far. Either way, it's
+		// not in use.
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return false
+	}
+	return pid == os.Getpid() || isProcessRunning(pid)
+}
+
+// RunCleanCommand removes scratch directories left over from earlier grouse
+// runs which crashed or were killed before they could clean up after
+// themselves.
+func RunCleanCommand(cmd *cobra.Command) {
+	debug, err := cmd.Flags().GetBool("debug")
+	check(err)
+	out.Reinit(debug)
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	check(err)
+
+	stale, err := findStaleScratchDirs(os.TempDir())
+	if err != nil {
+		out.Outln("Error:", err)
+		os.Exit(2)
+	}
+	if len(stale) == 0 {
+		out.Outln("Nothing to clean up.")
+	}
+	for _, dir := range stale {
+		if dryRun {
+			out.Outf("Would remove %s\n", dir)
+			continue
+		}
+		out.Outf("Removing %s\n", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			out.Outln("Error:", err)
+			os.Exit(2)
+		}
+	}
+
+	if !dryRun {
+		// If grouse used `git worktree` in the current repo, git still has
+		// metadata about worktrees in the directories we just removed.
+		// Failure just means we're not in a git repo, which is fine.
+		if err := git.PruneWorktrees(context.Background(), "."); err != nil {
+			out.Debugf("Didn't prune worktrees: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestFindStaleScratchDirs(t *testing.T) {
+	c := qt.New(t)
+	baseDir, err := ioutil.TempDir("", "grouse-scratch-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(baseDir)
+
+	makeDir := func(name string, pid string) string {
+		dir := filepath.Join(baseDir, name)
+		c.Assert(os.Mkdir(dir, os.ModePerm), qt.IsNil)
+		if pid != "" {
+			c.Assert(ioutil.WriteFile(filepath.Join(dir, pidFileName), []byte(pid), 0644), qt.IsNil)
+		}
+		return dir
+	}
+
+	// Process IDs are never this big, so this process can't be running.
+	deadPid := "2147483646"
+	inUse := makeDir(scratchDirPrefix+"111", strconv.Itoa(os.Getpid()))
+	crashed := makeDir(scratchDirPrefix+"222", deadPid)
+	noPidFile := makeDir(scratchDirPrefix+"333", "")
+	unrelated := makeDir("something-else", deadPid)
+
+	stale, err := findStaleScratchDirs(baseDir)
+	c.Assert(err, qt.IsNil)
+	c.Check(stale, qt.DeepEquals, []string{crashed, noPidFile})
+	c.Check(stale, qt.Not(qt.Contains), inUse)
+	c.Check(stale, qt.Not(qt.Contains), unrelated)
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/capnfabs/grouse/internal/exec"
+	"github.com/capnfabs/grouse/internal/out"
+	"github.com/pkg/errors"
+)
+
+// runSourceAndOutputDiff shows the diff between the source of the Hugo site
+// at base and revision, followed by the diff between their outputs (in
+// outputDir, with diffArgs and pathspecs as for runDiff). If git would page the
+// diff, both diffs go through the same pager, so that the cause and the
+// effect can be read together.
+func runSourceAndOutputDiff(outputDir string, base, revision BuiltRevision, diffArgs []string, pathspecs []string, userArgs cmdArgs) error {
+	sourceArgs := []string{}
+	if userArgs.diffCommand == "diff" {
+		// Show what changed inside submodules, rather than only the commits
+		// they moved between.
+		sourceArgs = append(sourceArgs, "--submodule=diff")
+	}
+	sourceArgs = append(sourceArgs, userArgs.diffArgs...)
+	var sitePaths []string
+	if revision.SiteDir != "" {
+		sitePaths = []string{":(literal)" + revision.SiteDir}
+	}
+
+	if userArgs.noPager || userArgs.diffCommand != "diff" || !isTerminal(os.Stdout) {
+		// Nothing would get paged, so each diff gets shown the usual way.
+		printSourceDiffHeader(os.Stdout, base, revision)
+		err := runDiff(revision.SourceDir, userArgs.noPager, userArgs.diffCommand, sourceArgs, sitePaths, base.Source, revision.Source)
+		if err != nil {
+			return err
+		}
+		printOutputDiffHeader(os.Stdout, base, revision)
+		return runDiff(outputDir, userArgs.noPager, userArgs.diffCommand, diffArgs, pathspecs, base.Output, revision.Output)
+	}
+
+	// git only colours its output by itself if it's going to a terminal, so
+	// it has to be told to when it's going to the pager instead. The user's
+	// args come last, so that they can still turn it off.
+	if colorDiffs(outputDir) {
+		sourceArgs = append([]string{"--color"}, sourceArgs...)
+		diffArgs = append([]string{"--color"}, diffArgs...)
+	}
+	pagerInput, diffOutput, err := os.Pipe()
+	if err != nil {
+		return errors.Wrap(err, "Couldn't connect the diffs to the pager")
+	}
+	// git starts its pager for shell aliases too, so this pipes both diffs
+	// into whatever pager git diff would have used, set up the same way (or
+	// straight to the terminal, if git wouldn't page them).
+	pagerCmd := exec.Command(context.Background(), "git", "-p", "-c", "alias.grouse-page=!cat", "grouse-page")
+	pagerCmd.Dir = outputDir
+	pagerCmd.Stdin = pagerInput
+	pagerCmd.Stdout = os.Stdout
+	pagerCmd.Stderr = os.Stderr
+	out.Debugf("Paging the source and output diffs with git\n")
+	pagerDone := make(chan error, 1)
+	go func() {
+		err := exec.Run(pagerCmd)
+		// If the user quits the pager before the end, the diffs get a broken
+		// pipe rather than waiting forever for it to read more.
+		pagerInput.Close()
+		pagerDone <- err
+	}()
+
+	err = func() error {
+		defer diffOutput.Close()
+		printSourceDiffHeader(diffOutput, base, revision)
+		sourceCmd := gitDiffCommand(revision.SourceDir, true, userArgs.diffCommand, sourceArgs, sitePaths, base.Source, revision.Source)
+		sourceCmd.Stdin = nil
+		sourceCmd.Stdout = diffOutput
+		if err := exec.Run(sourceCmd); err != nil {
+			return err
+		}
+		printOutputDiffHeader(diffOutput, base, revision)
+		outputCmd := gitDiffCommand(outputDir, true, userArgs.diffCommand, diffArgs, pathspecs, base.Output, revision.Output)
+		outputCmd.Stdin = nil
+		outputCmd.Stdout = diffOutput
+		return exec.Run(outputCmd)
+	}()
+	if pagerErr := <-pagerDone; pagerErr != nil {
+		return errors.Wrap(pagerErr, "Running the pager failed")
+	}
+	return err
+}
+
+func printSourceDiffHeader(w io.Writer, base, revision BuiltRevision) {
+	where := "the repo"
+	if revision.SiteDir != "" {
+		where = revision.SiteDir
+	}
+	// Errors are fine; the pager has gone away, and the diff will notice.
+	fmt.Fprintf(w, "Source changes in %s, from %s to %s:\n\n", where, base, revision)
+}
+
+func printOutputDiffHeader(w io.Writer, base, revision BuiltRevision) {
+	fmt.Fprintf(w, "\nOutput changes, from %s to %s:\n\n", base, revision)
+}
+
+// isTerminal reports whether f is (probably) a terminal, which is when git
+// would page its output.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorDiffs reports whether git is configured to colour diffs that it pages.
+func colorDiffs(repoDir string) bool {
+	result := exec.Exec(context.Background(), repoDir, "git", "config", "--get-colorbool", "color.diff", "true")
+	return result.Err == nil && result.StdOut == "true"
+}
@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+)
+
+// sourceMode is the way that grouse gets the source for each revision onto
+// disk before building it.
+type sourceMode string
+
+const (
+	// sourceModeAuto picks sourceModeWorktree if it can, and
+	// sourceModeClone otherwise. If that doesn't work for the user's repo, it
+	// falls back to the next mode along; see fallbackSourceMode.
+	sourceModeAuto sourceMode = "auto"
+	// sourceModeWorktree uses `git worktree add`. It's fast, but doesn't
+	// support submodules.
+	sourceModeWorktree sourceMode = "worktree"
+	// sourceModeClone makes a shared clone of the repo and all its
+	// submodules. It's the slowest, but works with everything.
+	sourceModeClone sourceMode = "clone"
+	// sourceModeExport exports the files from each commit without any git
+	// metadata. It's only suitable for builds which don't use git info.
+	sourceModeExport sourceMode = "export"
+)
+
+func parseSourceMode(mode string) (sourceMode, error) {
+	switch m := sourceMode(mode); m {
+	case "":
+		return sourceModeAuto, nil
+	case sourceModeAuto, sourceModeWorktree, sourceModeClone, sourceModeExport:
+		return m, nil
+	default:
+		return "", fmt.Errorf("Unknown source mode '%s'; expected one of auto, worktree, clone or export", mode)
+	}
+}
+
+// chooseSourceMode resolves sourceModeAuto to a concrete mode, based on what
+// the installed git supports and whether any of the revisions use submodules.
+func chooseSourceMode(ctx context.Context, git_ git.Git, repo git.Repository, refs []git.ResolvedUserRef, requested sourceMode) sourceMode {
+	if requested != sourceModeAuto && requested != "" {
+		return requested
+	}
+	if !git_.SupportsDetachedWorktrees() {
+		out.FromContext(ctx).Debugln("Installed git doesn't support detached worktrees, falling back to a shared clone.")
+		return sourceModeClone
+	}
+	for _, ref := range refs {
+		if repo.UsesSubmodules(ref.Commit().Hash()) {
+			out.FromContext(ctx).Debugf("%s uses submodules, falling back to a shared clone.\n", ref)
+			return sourceModeClone
+		}
+	}
+	return sourceModeWorktree
+}
+
+// fallbackSourceMode returns the mode to try in auto mode when preparing a
+// directory using mode fails, or false if there's nothing left to try. Export
+// mode goes last because it doesn't need anything from git except the objects
+// themselves.
+func fallbackSourceMode(mode sourceMode) (sourceMode, bool) {
+	switch mode {
+	case sourceModeWorktree:
+		return sourceModeClone, true
+	case sourceModeClone:
+		return sourceModeExport, true
+	default:
+		return "", false
+	}
+}
+
+// sparsePaths returns the paths to check out, relative to the root of the
+// repo, or nil to check out everything.
+func sparsePaths(ctx context.Context, opts BuildOptions, hugoRelativeRoot string) []string {
+	if !opts.Sparse {
+		return nil
+	}
+	if hugoRelativeRoot == "" {
+		out.FromContext(ctx).Outln("Ignoring --sparse, because the Hugo site is at the root of the repo.")
+		return nil
+	}
+	return append([]string{hugoRelativeRoot}, opts.SparsePaths...)
+}
+
+// materializeSource creates a scratch directory at dst that revisions of repo
+// can be checked out into.
+func materializeSource(ctx context.Context, repo git.Repository, mode sourceMode, dst string, sparsePaths []string) (git.WorktreeRepository, error) {
+	log := out.FromContext(ctx)
+	log.Debugf("Preparing source directory at %s using mode %s\n", dst, mode)
+	if len(sparsePaths) > 0 {
+		log.Debugf("Only checking out %v\n", sparsePaths)
+	}
+	switch mode {
+	case sourceModeWorktree:
+		return repo.AddDetachedWorktree(dst, sparsePaths)
+	case sourceModeExport:
+		return repo.ExportTo(dst, sparsePaths)
+	default:
+		return repo.RecursiveSharedCloneTo(dst, sparsePaths)
+	}
+}
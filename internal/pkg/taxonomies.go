@@ -0,0 +1,255 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+	au "github.com/logrusorgru/aurora"
+)
+
+// taxonomyTerms is how many pages have each term, by taxonomy, e.g.
+// terms["tags"]["golang"] = 3. The count is -1 if it couldn't be worked out.
+type taxonomyTerms map[string]map[string]int
+
+// Hugo's taxonomies, unless the configuration says otherwise.
+var defaultTaxonomies = []string{"categories", "tags"}
+
+// legacyTaxonomy matches the `singular:plural` pairs in the taxonomies setting
+// printed by older versions of Hugo, e.g. `map[category:categories tag:tags]`.
+var legacyTaxonomy = regexp.MustCompile(`[\w-]+:([\w-]+)`)
+
+// taxonomiesOf returns the names of the taxonomies (as they appear in URLs)
+// according to config, or the default ones if config doesn't say.
+func taxonomiesOf(config hugoConfig) []string {
+	taxonomies := []string{}
+	for key, value := range config {
+		if key == "taxonomies" {
+			if value == "{}" {
+				// Explicitly turned off.
+				return taxonomies
+			}
+			for _, match := range legacyTaxonomy.FindAllStringSubmatch(value, -1) {
+				taxonomies = appendNew(taxonomies, match[1])
+			}
+		} else if strings.HasPrefix(key, "taxonomies.") {
+			var plural string
+			if err := json.Unmarshal([]byte(value), &plural); err == nil && plural != "" {
+				taxonomies = appendNew(taxonomies, plural)
+			}
+		}
+	}
+	if len(taxonomies) == 0 {
+		return append([]string{}, defaultTaxonomies...)
+	}
+	sort.Strings(taxonomies)
+	return taxonomies
+}
+
+// rssLimitOf returns the most items that Hugo puts in an RSS feed according
+// to config, or 0 if there's no limit.
+func rssLimitOf(config hugoConfig) int {
+	for _, key := range []string{"services.rss.limit", "rsslimit"} {
+		var limit int
+		if err := json.Unmarshal([]byte(config[key]), &limit); err == nil && limit > 0 {
+			return limit
+		}
+	}
+	return 0
+}
+
+// readTaxonomyTerms finds the term pages among files, the files in the output
+// at commit, e.g. tags/golang/index.html, or de/tags/golang/index.html for a
+// language with its own directory. Those get listed under "de/tags". It
+// counts the pages for each term from the items in its RSS feed; if the feed
+// is as long as rssLimit allows, it might not have all of them, so the count
+// is unknown.
+func readTaxonomyTerms(outputRepo git.Repository, commit git.Hash, files []string, taxonomies []string, languages []siteLanguage, rssLimit int) (taxonomyTerms, error) {
+	terms := taxonomyTerms{}
+	isTaxonomy := map[string]bool{}
+	for _, taxonomy := range taxonomies {
+		isTaxonomy[taxonomy] = true
+	}
+	type termKey struct{ taxonomy, term string }
+	feeds := map[string]termKey{}
+	feedPaths := []string{}
+	for _, file := range files {
+		prefix := ""
+		for _, language := range languages {
+			if language.dir != "" && strings.HasPrefix(file, language.dir+"/") {
+				prefix = language.dir + "/"
+				break
+			}
+		}
+		parts := strings.Split(strings.TrimPrefix(file, prefix), "/")
+		// The taxonomy's own list page is paginated into e.g.
+		// tags/page/2/, which isn't a term.
+		if len(parts) != 3 || parts[1] == "page" || !isTaxonomy[parts[0]] {
+			continue
+		}
+		key := termKey{prefix + parts[0], parts[1]}
+		if terms[key.taxonomy] == nil {
+			terms[key.taxonomy] = map[string]int{}
+		}
+		if _, ok := terms[key.taxonomy][key.term]; !ok {
+			terms[key.taxonomy][key.term] = -1
+		}
+		if parts[2] == "index.xml" {
+			feeds[file] = key
+			feedPaths = append(feedPaths, file)
+		}
+	}
+	err := outputRepo.ReadFiles(commit, feedPaths, func(feed string, content []byte) error {
+		key := feeds[feed]
+		if count := bytes.Count(content, []byte("<item>")); rssLimit == 0 || count < rssLimit {
+			terms[key.taxonomy][key.term] = count
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+// termChange is a term which was added or removed, or whose page count
+// changed.
+type termChange struct {
+	status   git.ChangeStatus
+	taxonomy string
+	term     string
+	from     int
+	to       int
+	// For added terms: existing terms which are probably the same thing,
+	// spelled differently.
+	similarTo []string
+}
+
+// diffTaxonomies returns the terms that differ between from and to, sorted by
+// taxonomy and then term.
+func diffTaxonomies(from, to taxonomyTerms) []termChange {
+	changes := []termChange{}
+	for taxonomy, fromTerms := range from {
+		for term, count := range fromTerms {
+			if newCount, ok := to[taxonomy][term]; !ok {
+				changes = append(changes, termChange{status: git.Deleted, taxonomy: taxonomy, term: term, from: count})
+			} else if count != newCount && count >= 0 && newCount >= 0 {
+				changes = append(changes, termChange{status: git.Modified, taxonomy: taxonomy, term: term, from: count, to: newCount})
+			}
+		}
+	}
+	for taxonomy, toTerms := range to {
+		for term, count := range toTerms {
+			if _, ok := from[taxonomy][term]; ok {
+				continue
+			}
+			change := termChange{status: git.Added, taxonomy: taxonomy, term: term, to: count}
+			for other := range toTerms {
+				if other != term && foldTerm(other) == foldTerm(term) {
+					change.similarTo = append(change.similarTo, other)
+				}
+			}
+			sort.Strings(change.similarTo)
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].taxonomy != changes[j].taxonomy {
+			return changes[i].taxonomy < changes[j].taxonomy
+		}
+		return changes[i].term < changes[j].term
+	})
+	return changes
+}
+
+var foldDiacritics = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "ā", "a", "ă", "a", "ą", "a",
+	"ç", "c", "ć", "c", "č", "c", "ď", "d", "đ", "d",
+	"è", "e", "é", "e", "ê", "e", "ë", "e", "ē", "e", "ę", "e", "ě", "e", "ğ", "g",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ī", "i", "ı", "i", "ł", "l",
+	"ñ", "n", "ń", "n", "ň", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "ō", "o", "ő", "o",
+	"ř", "r", "ś", "s", "š", "s", "ş", "s", "ß", "ss", "ť", "t", "ţ", "t",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ū", "u", "ů", "u", "ű", "u",
+	"ý", "y", "ÿ", "y", "ź", "z", "ż", "z", "ž", "z",
+	"-", "", "_", "", " ", "",
+)
+
+// foldTerm reduces a term to a key which is the same for variants of it that
+// differ in case, diacritics, separators or (roughly) plurals, so that e.g.
+// "Café", "cafe" and "cafes" are all "cafe".
+func foldTerm(term string) string {
+	folded := foldDiacritics.Replace(strings.ToLower(term))
+	switch {
+	case strings.HasSuffix(folded, "ies") && len(folded) > 4:
+		return strings.TrimSuffix(folded, "ies") + "y"
+	case strings.HasSuffix(folded, "sses"), strings.HasSuffix(folded, "xes"),
+		strings.HasSuffix(folded, "ches"), strings.HasSuffix(folded, "shes"):
+		return strings.TrimSuffix(folded, "es")
+	case strings.HasSuffix(folded, "s") && !strings.HasSuffix(folded, "ss") && len(folded) > 3:
+		return strings.TrimSuffix(folded, "s")
+	}
+	return folded
+}
+
+func describePages(count int) string {
+	switch count {
+	case -1:
+		return "unknown number of pages"
+	case 1:
+		return "1 page"
+	}
+	return fmt.Sprintf("%d pages", count)
+}
+
+// printTaxonomyChanges shows which taxonomy terms changed between the outputs
+// of base and revision.
+func printTaxonomyChanges(outputRepo git.Repository, base, revision BuiltRevision) {
+	taxonomies := appendNew(taxonomiesOf(base.Config), taxonomiesOf(revision.Config)...)
+	languages, baseFiles, revisionFiles, err := outputLanguages(outputRepo, base, revision)
+	if err != nil {
+		out.Outf("Couldn't find the taxonomy terms, so they won't be compared: %v\n", err)
+		return
+	}
+	baseTerms, err := readTaxonomyTerms(outputRepo, base.Raw, baseFiles, taxonomies, languages, rssLimitOf(base.Config))
+	if err != nil {
+		out.Outf("Couldn't find the taxonomy terms in %s, so they won't be compared: %v\n", base, err)
+		return
+	}
+	revisionTerms, err := readTaxonomyTerms(outputRepo, revision.Raw, revisionFiles, taxonomies, languages, rssLimitOf(revision.Config))
+	if err != nil {
+		out.Outf("Couldn't find the taxonomy terms in %s, so they won't be compared: %v\n", revision, err)
+		return
+	}
+	if len(baseTerms) == 0 && len(revisionTerms) == 0 {
+		// Nothing uses taxonomies, so there's nothing to say.
+		return
+	}
+
+	changes := diffTaxonomies(baseTerms, revisionTerms)
+	if len(changes) == 0 {
+		out.Outln("No taxonomy terms were added or removed, or changed how many pages they have.")
+		return
+	}
+	out.Outf("Taxonomy terms changed (%d):\n", len(changes))
+	for _, change := range changes {
+		name := change.taxonomy + "/" + change.term
+		switch change.status {
+		case git.Added:
+			line := fmt.Sprintf("  %s %s (%s)", au.Green("+"), name, describePages(change.to))
+			if len(change.similarTo) > 0 {
+				line += " " + au.Red("probably a duplicate of "+strings.Join(change.similarTo, ", ")).String()
+			}
+			out.Outln(line)
+		case git.Deleted:
+			out.Outf("  %s %s (was %s)\n", au.Red("-"), name, describePages(change.from))
+		default:
+			out.Outf("  %s %s: %d → %s\n", au.Yellow("~"), name, change.from, describePages(change.to))
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/mocks"
+	qt "github.com/frankban/quicktest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTaxonomiesOf(t *testing.T) {
+	c := qt.New(t)
+	c.Check(taxonomiesOf(nil), qt.DeepEquals, defaultTaxonomies)
+	c.Check(taxonomiesOf(hugoConfig{"title": `"Hi"`}), qt.DeepEquals, defaultTaxonomies)
+	c.Check(taxonomiesOf(hugoConfig{
+		"taxonomies.tag":    `"tags"`,
+		"taxonomies.series": `"series"`,
+	}), qt.DeepEquals, []string{"series", "tags"})
+	c.Check(taxonomiesOf(hugoConfig{"taxonomies": "map[category:categories tag:tags]"}), qt.DeepEquals, []string{"categories", "tags"})
+	c.Check(taxonomiesOf(hugoConfig{"taxonomies": "{}"}), qt.HasLen, 0)
+}
+
+func TestReadTaxonomyTerms(t *testing.T) {
+	c := qt.New(t)
+	files := []string{
+		"index.html",
+		"tags/index.html",
+		"tags/index.xml",
+		"tags/page/2/index.html",
+		"tags/golang/index.html",
+		"tags/golang/index.xml",
+		"tags/golang/page/2/index.html",
+		"tags/café/index.html",
+		"tags/hugo/index.html",
+		"tags/hugo/index.xml",
+		"de/tags/golang/index.html",
+		"de/tags/golang/index.xml",
+		"posts/tags/index.html",
+	}
+	feeds := map[string]string{
+		"tags/golang/index.xml":    "<rss><channel><item><title>A</title></item>\n<item><title>B</title></item></channel></rss>",
+		"tags/hugo/index.xml":      "<rss><channel><item></item><item></item><item></item></channel></rss>",
+		"de/tags/golang/index.xml": "<rss><channel><item><title>A</title></item></channel></rss>",
+	}
+	repo := new(mocks.Repository)
+	repo.On("ReadFiles", git.Hash("abc"), mock.Anything, mock.Anything).Return(func(commit git.Hash, paths []string, read func(string, []byte) error) error {
+		for _, p := range paths {
+			if err := read(p, []byte(feeds[p])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	languages := []siteLanguage{{code: "en"}, {code: "de", dir: "de"}}
+
+	terms, err := readTaxonomyTerms(repo, "abc", files, defaultTaxonomies, languages, 0)
+	c.Assert(err, qt.IsNil)
+	c.Check(terms, qt.DeepEquals, taxonomyTerms{
+		"tags":    {"golang": 2, "café": -1, "hugo": 3},
+		"de/tags": {"golang": 1},
+	})
+
+	// With rssLimit = 3, the hugo feed might be missing some pages.
+	terms, err = readTaxonomyTerms(repo, "abc", files, defaultTaxonomies, languages, 3)
+	c.Assert(err, qt.IsNil)
+	c.Check(terms["tags"], qt.DeepEquals, map[string]int{"golang": 2, "café": -1, "hugo": -1})
+}
+
+func TestRSSLimitOf(t *testing.T) {
+	c := qt.New(t)
+	c.Check(rssLimitOf(nil), qt.Equals, 0)
+	c.Check(rssLimitOf(hugoConfig{"rsslimit": "-1"}), qt.Equals, 0)
+	c.Check(rssLimitOf(hugoConfig{"rsslimit": "10"}), qt.Equals, 10)
+	c.Check(rssLimitOf(hugoConfig{"services.rss.limit": "20"}), qt.Equals, 20)
+}
+
+func TestFoldTerm(t *testing.T) {
+	c := qt.New(t)
+	for _, variants := range [][]string{
+		{"cafe", "Café", "cafes", "CAFÉS"},
+		{"static-site", "static_site", "Static Sites"},
+		{"category", "categories"},
+		{"class", "classes"},
+		{"box", "boxes"},
+	} {
+		for _, variant := range variants[1:] {
+			c.Check(foldTerm(variant), qt.Equals, foldTerm(variants[0]), qt.Commentf("%s vs %s", variant, variants[0]))
+		}
+	}
+	c.Check(foldTerm("go"), qt.Not(qt.Equals), foldTerm("gos"))
+	c.Check(foldTerm("css"), qt.Not(qt.Equals), foldTerm("cs"))
+}
+
+func TestDiffTaxonomies(t *testing.T) {
+	c := qt.New(t)
+	changes := diffTaxonomies(
+		taxonomyTerms{
+			"tags":       {"golang": 3, "hugo": 2, "old": 1},
+			"categories": {"news": 4},
+		},
+		taxonomyTerms{
+			"tags":       {"golang": 3, "hugo": 5, "Golang": 1, "unknown": -1},
+			"categories": {"news": -1},
+		},
+	)
+	c.Assert(changes, qt.HasLen, 4)
+	c.Check(changes[0].term, qt.Equals, "Golang")
+	c.Check(changes[0].status, qt.Equals, git.Added)
+	c.Check(changes[0].similarTo, qt.DeepEquals, []string{"golang"})
+	c.Check(changes[1].term, qt.Equals, "hugo")
+	c.Check(changes[1].status, qt.Equals, git.Modified)
+	c.Check([]int{changes[1].from, changes[1].to}, qt.DeepEquals, []int{2, 5})
+	c.Check(changes[2].term, qt.Equals, "old")
+	c.Check(changes[2].status, qt.Equals, git.Deleted)
+	c.Check(changes[3].term, qt.Equals, "unknown")
+	c.Check(changes[3].similarTo, qt.HasLen, 0)
+}
@@ -0,0 +1,448 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/capnfabs/grouse/internal/events"
+	"github.com/capnfabs/grouse/internal/exec"
+	"github.com/capnfabs/grouse/internal/git"
+	"github.com/capnfabs/grouse/internal/out"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// What the working copy is called, as a revision.
+const workingCopyName = "working copy"
+
+// How long the working copy has to stay the same before it gets rebuilt, so
+// that saving several files at once only causes one build.
+const watchQuietPeriod = 300 * time.Millisecond
+
+// RunWatchCommand builds a baseline revision once, and then builds the user's
+// working copy and compares it to the baseline every time something in it
+// changes.
+func RunWatchCommand(cmd *cobra.Command) {
+	userArgs, err := parseWatchArgs(cmd.Flags())
+	if err != nil {
+		out.Outln("Error:", err)
+		cmd.Usage()
+		os.Exit(1)
+	}
+	out.Reinit(userArgs.debug)
+
+	runToCompletion(*userArgs, func(ctx context.Context) error {
+		return runWatch(ctx, git.NewGit(ctx), *userArgs)
+	})
+}
+
+func runWatch(ctx context.Context, git_ git.Git, userArgs cmdArgs) error {
+	build, err := BuildRevisions(ctx, git_, userArgs.buildOptions())
+	if err != nil {
+		return err
+	}
+	defer build.Close()
+
+	siteDir := build.workingCopyDir()
+	watcher, err := newSourceWatcher(siteDir)
+	if err != nil {
+		return errors.WithMessagef(err, "Couldn't watch %s for changes", siteDir)
+	}
+	defer watcher.Close()
+
+	var before siteSnapshot
+	// What changed during the last build, which the build probably wrote
+	// itself.
+	lastWrites := map[string]bool{}
+	for {
+		out.Outf("Building the %s…\n", workingCopyName)
+		before, err = snapshotSite(siteDir, before)
+		if err != nil {
+			return errors.WithMessagef(err, "Couldn't read %s", siteDir)
+		}
+		revision, err := build.buildWorkingCopy(ctx)
+		if ctx.Err() != nil {
+			// Ctrl-C is how you stop watching.
+			return nil
+		}
+		if err == nil {
+			err = showWatchResult(build.OutputRepo, build.Base, revision, userArgs)
+		}
+		if err != nil {
+			// Builds break all the time while you're editing; the next change
+			// might fix it.
+			out.Outln("Error:", err)
+		}
+
+		// The watcher saw what the build wrote into the site too (e.g. with a
+		// pre-build hook), and rebuilding for that would never stop. What
+		// really changed during the build comes from comparing snapshots
+		// instead, which doesn't miss anything.
+		if _, ok := collectChanges(ctx, watcher.changes, watchQuietPeriod); !ok {
+			return nil
+		}
+		after, err := snapshotSite(siteDir, before)
+		if err != nil {
+			return errors.WithMessagef(err, "Couldn't read %s", siteDir)
+		}
+		writes := before.changesIn(after)
+		before = after
+		changed := []string{}
+		ignored := []string{}
+		for _, p := range writes {
+			if lastWrites[p] {
+				ignored = append(ignored, p)
+			} else {
+				changed = append(changed, p)
+			}
+		}
+		lastWrites = map[string]bool{}
+		for _, p := range writes {
+			lastWrites[p] = true
+		}
+		if len(ignored) > 0 {
+			out.Outf("Not rebuilding for changes to %s during the build: they changed during the last build too, so the build probably makes them.\n", describeFiles(ignored))
+		}
+		if len(changed) > 0 {
+			out.Outf("%s changed during the build.\n", describeFiles(changed))
+			continue
+		}
+
+		out.Outf("Watching %s for changes; press Ctrl-C to stop.\n", siteDir)
+		changed, ok := waitForChanges(ctx, watcher.changes, watchQuietPeriod)
+		if !ok {
+			return nil
+		}
+		out.Outf("%s changed.\n", describeFiles(changed))
+	}
+}
+
+// describeFiles names the files in paths, e.g. "a.md and 2 other files".
+func describeFiles(paths []string) string {
+	if len(paths) == 1 {
+		return paths[0]
+	}
+	return fmt.Sprintf("%s and %s", paths[0], countOf(len(paths)-1, "other file", "other files"))
+}
+
+// Files up to this size get hashed in snapshots, so that files which the
+// build rewrites with the same content don't count as changed.
+const snapshotHashLimit = 1 << 20
+
+// fileState is what a siteSnapshot knows about a file.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	// Only for files up to snapshotHashLimit.
+	sum    [sha256.Size]byte
+	hashed bool
+}
+
+// siteSnapshot is the state of every file in a site that the watcher
+// doesn't ignore, keyed by its path relative to the site.
+type siteSnapshot map[string]fileState
+
+// snapshotSite takes a snapshot of root. Files that haven't changed since
+// previous (which can be nil) don't get hashed again.
+func snapshotSite(root string, previous siteSnapshot) (siteSnapshot, error) {
+	snapshot := siteSnapshot{}
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			// It's gone since.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && watchIgnored(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		if old, ok := previous[rel]; ok && old.size == state.size && old.modTime.Equal(state.modTime) {
+			snapshot[rel] = old
+			return nil
+		}
+		if state.size <= snapshotHashLimit {
+			content, err := ioutil.ReadFile(p)
+			if os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			state.sum = sha256.Sum256(content)
+			state.hashed = true
+		}
+		snapshot[rel] = state
+		return nil
+	})
+	return snapshot, err
+}
+
+// changesIn returns the paths of the files that were added, removed or
+// changed in after, in order. Files that got rewritten with the same content
+// don't count.
+func (s siteSnapshot) changesIn(after siteSnapshot) []string {
+	changed := []string{}
+	for p, old := range s {
+		state, ok := after[p]
+		if !ok {
+			changed = append(changed, p)
+		} else if state.size != old.size || !state.modTime.Equal(old.modTime) {
+			if !state.hashed || !old.hashed || state.sum != old.sum {
+				changed = append(changed, p)
+			}
+		}
+	}
+	for p := range after {
+		if _, ok := s[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// showWatchResult shows how revision differs from base: the same reports as
+// the root command, but without the diff, or the report in
+// userArgs.reportFormat, if there is one.
+func showWatchResult(outputRepo git.Repository, base, revision BuiltRevision, userArgs cmdArgs) error {
+	if userArgs.reportFormat.replacesDiff() {
+		return writeReport(outputRepo, base, []BuiltRevision{revision}, userArgs)
+	}
+	changes, err := outputRepo.ChangedFiles(base.Output, revision.Output)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		out.Outf("The output of the %s is the same as %s.\n", workingCopyName, base)
+		return nil
+	}
+	if _, err := printReports(outputRepo, base, revision, userArgs); err != nil {
+		return err
+	}
+	out.Outf("%s changed compared to %s.\n", countOf(len(changes), "output file", "output files"), base)
+	return nil
+}
+
+// workingCopyDir is where the Hugo site is in the user's working copy.
+func (b *Build) workingCopyDir() string {
+	return path.Join(b.repo.RootDir(), b.settings.hugoRelativeRoot)
+}
+
+// buildWorkingCopy builds the Hugo site in the user's working copy, as it is
+// right now, for comparing to b.Base. Hugo runs in the working copy itself, so
+// it writes its caches (e.g. resources/_gen) there, just like when you run it
+// yourself.
+func (b *Build) buildWorkingCopy(ctx context.Context) (BuiltRevision, error) {
+	if err := b.OutputRepo.ClearSourceControlledFilesFromWorktree(); err != nil {
+		return BuiltRevision{}, err
+	}
+
+	env := hookEnv{
+		ref:       workingCopyName,
+		side:      "b",
+		sourceDir: b.repo.RootDir(),
+		hugoDir:   b.workingCopyDir(),
+		outputDir: b.OutputRepo.RootDir(),
+	}
+	event := events.Event{Ref: workingCopyName}
+	revision, err := buildSource(ctx, "the "+workingCopyName, event, env, "Website content, built from the working copy", b.settings, b.OutputRepo)
+	if err := interrupted(ctx, err); err != nil {
+		return BuiltRevision{}, err
+	}
+	switch err.(type) {
+	case *exec.ExitError:
+		return BuiltRevision{}, errors.Wrap(err, "Building the working copy failed")
+	case *hookError, *filterError:
+		return BuiltRevision{}, errors.WithMessage(err, "Building the working copy failed")
+	case error:
+		return BuiltRevision{}, errors.WithMessagef(err, "Couldn't build the %s", workingCopyName)
+	}
+
+	revision.Name = workingCopyName
+	revision.Description = workingCopyName
+	revision.SiteDir = b.settings.hugoRelativeRoot
+	if b.sourceChanges && b.Base.Source != git.NilHash {
+		// Unlike between commits, changed submodules count as a whole.
+		changes, err := b.repo.ChangedFilesInWorkingTree(b.Base.Source)
+		if err != nil {
+			out.Outf("Couldn't work out which source files changed since %s: %v\n", b.Base, err)
+		} else {
+			revision.SourceChanges = changesInSite(changes, b.settings.hugoRelativeRoot)
+		}
+	}
+	return revision, nil
+}
+
+// sourceWatcher reports changes to the files in a directory and everything
+// inside it, apart from the ones that watchIgnored skips.
+type sourceWatcher struct {
+	fs   *fsnotify.Watcher
+	root string
+	// Gets the path of each file that changes, relative to root. Once it's
+	// full, more changes get dropped; one is enough to cause a rebuild.
+	changes chan string
+}
+
+func newSourceWatcher(root string) (*sourceWatcher, error) {
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &sourceWatcher{fs: fs, root: root, changes: make(chan string, 100)}
+	if err := w.addTree(root); err != nil {
+		fs.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// addTree watches dir, and every directory inside it; fsnotify only watches
+// the directories that it's told about, not what's inside them.
+func (w *sourceWatcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			// It's gone since; there's nothing to watch.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if rel := w.relPath(p); rel != "." && watchIgnored(rel) {
+			return filepath.SkipDir
+		}
+		return w.fs.Add(p)
+	})
+}
+
+func (w *sourceWatcher) run() {
+	for {
+		select {
+		case event, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			rel := w.relPath(event.Name)
+			if watchIgnored(rel) {
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := w.addTree(event.Name); err != nil {
+						out.Outf("Couldn't watch %s for changes: %v\n", event.Name, err)
+					}
+				}
+			}
+			if event.Op == fsnotify.Chmod {
+				// Editors and backup tools touch files without changing them.
+				continue
+			}
+			select {
+			case w.changes <- rel:
+			default:
+			}
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			out.Outln("Error watching for changes:", err)
+		}
+	}
+}
+
+func (w *sourceWatcher) relPath(p string) string {
+	rel, err := filepath.Rel(w.root, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (w *sourceWatcher) Close() error {
+	return w.fs.Close()
+}
+
+// watchIgnored reports whether changes to relPath (relative to the Hugo site)
+// don't matter: git's metadata and other hidden files (e.g. editors' swap
+// files, and Hugo's lock file), backup files, what hooks install into
+// node_modules, and what Hugo writes into the site when it builds.
+func watchIgnored(relPath string) bool {
+	if relPath == "public" || strings.HasPrefix(relPath, "public/") ||
+		relPath == "resources/_gen" || strings.HasPrefix(relPath, "resources/_gen/") {
+		return true
+	}
+	for _, part := range strings.Split(relPath, "/") {
+		if (strings.HasPrefix(part, ".") && part != "." && part != "..") || strings.HasSuffix(part, "~") || part == "node_modules" {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForChanges waits for something to arrive on changes, and then until
+// quiet goes by without anything else arriving. It returns everything that
+// arrived, without repeats, or false if ctx is cancelled first.
+func waitForChanges(ctx context.Context, changes <-chan string, quiet time.Duration) ([]string, bool) {
+	select {
+	case p := <-changes:
+		return collectChanges(ctx, changes, quiet, p)
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// collectChanges is like waitForChanges without waiting for the first change:
+// it starts with changed, and returns once quiet goes by without anything
+// arriving, even if nothing has arrived at all.
+func collectChanges(ctx context.Context, changes <-chan string, quiet time.Duration, changed ...string) ([]string, bool) {
+	collected := []string{}
+	seen := map[string]bool{}
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			collected = append(collected, p)
+		}
+	}
+	for _, p := range changed {
+		add(p)
+	}
+
+	timer := time.NewTimer(quiet)
+	defer timer.Stop()
+	for {
+		select {
+		case p := <-changes:
+			add(p)
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(quiet)
+		case <-timer.C:
+			return collected, true
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestWatchIgnored(t *testing.T) {
+	c := qt.New(t)
+	for _, relPath := range []string{"content/post.md", "layouts/_default/single.html", "resources/images/logo.png", "publications/index.md"} {
+		c.Check(watchIgnored(relPath), qt.Equals, false, qt.Commentf("path %s", relPath))
+	}
+	for _, relPath := range []string{".git", ".git/index", "content/.post.md.swp", ".hugo_build.lock", "content/post.md~", "node_modules/x/y.js", "public/index.html", "resources/_gen/images/logo.png"} {
+		c.Check(watchIgnored(relPath), qt.Equals, true, qt.Commentf("path %s", relPath))
+	}
+}
+
+func TestWaitForChanges(t *testing.T) {
+	c := qt.New(t)
+	changes := make(chan string, 10)
+	changes <- "a.md"
+	changes <- "b.md"
+	changes <- "a.md"
+	changed, ok := waitForChanges(context.Background(), changes, 10*time.Millisecond)
+	c.Check(ok, qt.Equals, true)
+	c.Check(changed, qt.DeepEquals, []string{"a.md", "b.md"})
+
+	// Changes keep the wait going until they stop.
+	go func() {
+		for _, p := range []string{"c.md", "d.md"} {
+			time.Sleep(20 * time.Millisecond)
+			changes <- p
+		}
+	}()
+	changed, ok = waitForChanges(context.Background(), changes, 50*time.Millisecond)
+	c.Check(ok, qt.Equals, true)
+	c.Check(changed, qt.DeepEquals, []string{"c.md", "d.md"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok = waitForChanges(ctx, changes, 10*time.Millisecond)
+	c.Check(ok, qt.Equals, false)
+}
+
+func TestCollectChanges(t *testing.T) {
+	c := qt.New(t)
+	changes := make(chan string, 10)
+	changed, ok := collectChanges(context.Background(), changes, 10*time.Millisecond)
+	c.Check(ok, qt.Equals, true)
+	c.Check(changed, qt.HasLen, 0)
+
+	changes <- "static/search.json"
+	changes <- "static/search.json"
+	changed, ok = collectChanges(context.Background(), changes, 10*time.Millisecond)
+	c.Check(ok, qt.Equals, true)
+	c.Check(changed, qt.DeepEquals, []string{"static/search.json"})
+	c.Check(changes, qt.HasLen, 0)
+}
+
+func TestSiteSnapshotChanges(t *testing.T) {
+	c := qt.New(t)
+	dir, err := ioutil.TempDir("", "grouse-watch-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+	write := func(relPath, content string, modTime time.Time) {
+		filePath := filepath.Join(dir, filepath.FromSlash(relPath))
+		c.Assert(os.MkdirAll(filepath.Dir(filePath), os.ModePerm), qt.IsNil)
+		c.Assert(ioutil.WriteFile(filePath, []byte(content), 0644), qt.IsNil)
+		c.Assert(os.Chtimes(filePath, modTime, modTime), qt.IsNil)
+	}
+	then := time.Now().Add(-time.Hour)
+	write("content/post.md", "hi", then)
+	write("data/search.json", "[]", then)
+	write("data/old.json", "{}", then)
+	write("public/index.html", "<p>", then)
+
+	before, err := snapshotSite(dir, nil)
+	c.Assert(err, qt.IsNil)
+	c.Check(before, qt.HasLen, 3)
+
+	now := time.Now()
+	// Rewritten with the same content, like a build would.
+	write("data/search.json", "[]", now)
+	write("content/post.md", "hello", now)
+	write("data/new.json", "{}", now)
+	c.Assert(os.Remove(filepath.Join(dir, "data", "old.json")), qt.IsNil)
+	write("public/index.html", "<p>hello", now)
+
+	after, err := snapshotSite(dir, before)
+	c.Assert(err, qt.IsNil)
+	c.Check(before.changesIn(after), qt.DeepEquals, []string{"content/post.md", "data/new.json", "data/old.json"})
+	c.Check(after.changesIn(after), qt.HasLen, 0)
+}
+
+func TestSourceWatcher(t *testing.T) {
+	c := qt.New(t)
+	dir, err := ioutil.TempDir("", "grouse-watch-test")
+	c.Assert(err, qt.IsNil)
+	defer os.RemoveAll(dir)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "content"), os.ModePerm), qt.IsNil)
+
+	watcher, err := newSourceWatcher(dir)
+	c.Assert(err, qt.IsNil)
+	defer watcher.Close()
+	// Writing a file can cause several events, so this skips repeats.
+	last := ""
+	next := func() string {
+		for {
+			select {
+			case p := <-watcher.changes:
+				if p != last {
+					last = p
+					return p
+				}
+			case <-time.After(5 * time.Second):
+				c.Fatal("Timed out waiting for a change")
+			}
+		}
+	}
+
+	// Ignored files don't come through, so the first change is the one after.
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, ".hugo_build.lock"), nil, 0644), qt.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "content", "post.md"), []byte("hi"), 0644), qt.IsNil)
+	c.Check(next(), qt.Equals, "content/post.md")
+
+	// New directories get watched too.
+	c.Assert(os.MkdirAll(filepath.Join(dir, "layouts"), os.ModePerm), qt.IsNil)
+	c.Check(next(), qt.Equals, "layouts")
+	// Give the watcher a moment to start watching it.
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "layouts", "index.html"), []byte("hi"), 0644), qt.IsNil)
+	c.Check(next(), qt.Equals, "layouts/index.html")
+}
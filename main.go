@@ -19,14 +19,72 @@ func versionString() string {
 	return fmt.Sprintf("%v, commit %v, built at %v", version, commit, date)
 }
 
+// addOutputFlags adds the flags that control how differences get shown, which
+// all the comparison commands share.
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("no-pager", false, "Prevent 'git diff' from paginating output.")
+	cmd.Flags().String("diffargs", "", "Arguments to pass on to 'git diff'")
+	cmd.Flags().BoolP("tool", "t", false, "Invoke 'git difftool' instead of 'git diff'")
+	cmd.Flags().String("image-report", "", "Compare changed images and write visual diffs of them to the given directory")
+	cmd.Flags().String("export-a", "", "Save the output of the first revision to this .tar, .tar.gz, .tgz or .zip archive")
+	cmd.Flags().String("export-b", "", "Save the output of the second revision to this .tar, .tar.gz, .tgz or .zip archive")
+	cmd.Flags().String("export-patch", "", "Save the diff between the outputs to this file, in a format that 'git apply' understands")
+	cmd.Flags().StringArray("filter", []string{}, "Run output files through a command before diffing them, as '<glob>: <command>', e.g. '*.pdf: pdftotext - -'. Can be repeated.")
+	cmd.Flags().String("ignore-rules", "", "Hide the differences described by the rules in this file, e.g. one written by 'grouse check-determinism --write-rules'")
+	cmd.Flags().Bool("no-taxonomy-diff", false, "Don't compare the tags, categories etc. in the outputs")
+	cmd.Flags().Bool("no-language-breakdown", false, "Don't break down the changes to multilingual sites by language")
+	cmd.Flags().Bool("no-format-breakdown", false, "Don't break down the changes by Hugo output format")
+	cmd.Flags().StringSlice("formats", []string{}, "Only diff output files in these Hugo output formats, e.g. 'html,json'; 'other' is everything that isn't in an output format, e.g. images")
+	cmd.Flags().String("format", "text", "How to show the differences: 'text' (a summary and the diff), 'markdown' (a summary with collapsed diffs, for pull request comments), or 'junit' or 'sarif' (what grouse's checks found, for CI systems)")
+	cmd.Flags().String("report-to", "", "Write the --format report to this file, rather than to stdout")
+	cmd.Flags().StringArray("size-budget", []string{}, "For the size-budget check in junit and sarif reports, the most that an output file should be, as '<size>' or '<glob>: <size>', e.g. '*.js: 100KB'. Can be repeated; the last one that matches a file counts.")
+	cmd.Flags().String("events", "", "Emit machine-readable progress events in this format; the only one is 'jsonl'")
+	cmd.Flags().String("events-to", "", "Where to send --events (required with it): 'stderr', 'stdout', 'fd:N' for an inherited file descriptor, or a file path")
+	cmd.Flags().Bool("debug", false, "Enables additional logging")
+	cmd.Flags().Bool("keep-cache", false, "Keeps the intermediary cache around after running grouse. Useful for debugging and development, but adds cruft to your disk.")
+	cmd.Flags().MarkHidden("keep-cache")
+}
+
+// addBuildFlags adds the flags that control how each revision gets built,
+// which all the commands that build things share.
+func addBuildFlags(cmd *cobra.Command) {
+	cmd.Flags().String("buildargs", "", "Arguments to pass on to the hugo build command")
+	cmd.Flags().String("pre-build", "", "Shell command to run in the Hugo site's directory before building each revision, e.g. 'npm ci'")
+	cmd.Flags().String("post-build", "", "Shell command to run in the output directory after building each revision")
+	cmd.Flags().String("source-mode", "auto", "How to get the source for each revision: 'worktree' (fast, no submodules), 'clone' (works with submodules), 'export' (no git metadata, so no GitInfo), or 'auto' to pick one")
+	cmd.Flags().Bool("sparse", false, "Only check out the directory containing the Hugo site (and --sparse-paths), rather than the whole repo")
+	cmd.Flags().StringSlice("sparse-paths", []string{}, "Extra paths, relative to the root of the repo, to check out with --sparse")
+}
+
 func main() {
-	rootCmd.Flags().Bool("no-pager", false, "Prevent 'git diff' from paginating output.")
-	rootCmd.Flags().String("diffargs", "", "Arguments to pass on to 'git diff'")
-	rootCmd.Flags().String("buildargs", "", "Arguments to pass on to the hugo build command")
-	rootCmd.Flags().BoolP("tool", "t", false, "Invoke 'git difftool' instead of 'git diff'")
-	rootCmd.Flags().Bool("debug", false, "Enables additional logging")
-	rootCmd.Flags().Bool("keep-cache", false, "Keeps the intermediary cache around after running grouse. Useful for debugging and development, but adds cruft to your disk.")
-	rootCmd.Flags().MarkHidden("keep-cache")
+	addOutputFlags(rootCmd)
+	addBuildFlags(rootCmd)
+	rootCmd.Flags().Bool("no-config-diff", false, "Don't compare Hugo's configuration ('hugo config') between revisions")
+	rootCmd.Flags().Bool("no-attribution", false, "Don't list the changed source files that probably caused each changed page")
+	rootCmd.Flags().Bool("source-diff", false, "Show the diff of the Hugo site's source, including inside submodules, before the diff of the output")
+	rootCmd.Flags().Bool("no-content-diff", false, "Don't compare which content is published, drafted, future-dated or expired ('hugo list') between revisions")
+	rootCmd.Flags().String("against-dir", "", "Compare the build of each commit against the contents of this directory, e.g. the deployed site")
+
+	cleanCmd.Flags().Bool("dry-run", false, "List the directories that would be removed, without removing them")
+	cleanCmd.Flags().Bool("debug", false, "Enables additional logging")
+	rootCmd.AddCommand(cleanCmd)
+
+	addOutputFlags(dirsCmd)
+	rootCmd.AddCommand(dirsCmd)
+
+	addOutputFlags(checkDeterminismCmd)
+	addBuildFlags(checkDeterminismCmd)
+	checkDeterminismCmd.Flags().Int("builds", 2, "How many times to build the revision")
+	checkDeterminismCmd.Flags().String("write-rules", "", "Write rules which hide the differences between builds to this file, for use with --ignore-rules")
+	rootCmd.AddCommand(checkDeterminismCmd)
+
+	addOutputFlags(watchCmd)
+	addBuildFlags(watchCmd)
+	watchCmd.Flags().Bool("no-config-diff", false, "Don't compare Hugo's configuration ('hugo config') with the baseline")
+	watchCmd.Flags().Bool("no-attribution", false, "Don't list the changed source files that probably caused each changed page")
+	watchCmd.Flags().Bool("no-content-diff", false, "Don't compare which content is published, drafted, future-dated or expired ('hugo list') with the baseline")
+	rootCmd.AddCommand(watchCmd)
+
 	if err := rootCmd.Execute(); err != nil {
 		out.Outln(err)
 		os.Exit(1)
@@ -34,7 +92,7 @@ func main() {
 }
 
 var rootCmd = &cobra.Command{
-	Use:     "grouse [flags] <commit> [<other-commit>]",
+	Use:     "grouse [flags] <commit> [<other-commit>...]",
 	Version: versionString(),
 	Short:   "Diffs the output of a given Hugo git repo at different commits.",
 	Long: `Diffs the output of a given Hugo git repo at different commits.
@@ -43,9 +101,84 @@ Imagine that on every commit of your Hugo site, you'd generated the site and
 stored that in version control. Then, you could see exactly what's changed in
 your generated site between different commits.
 
-Grouse approximates that process.`,
+Grouse approximates that process.
+
+With more than two commits, the first is the base, and each of the others gets
+compared against it in turn. With --against-dir, the directory is the base, and
+every commit gets compared against it.`,
 	DisableFlagsInUseLine: true,
+	// Needed because the root command has subcommands, but still takes
+	// commits as positional args.
+	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		pkg.RunRootCommand(cmd)
 	},
 }
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Removes scratch directories left behind by earlier grouse runs.",
+	Long: `Removes scratch directories left behind by earlier grouse runs.
+
+Grouse builds your site in a scratch directory, and removes it when it's done.
+If grouse crashes or is killed, though, those directories can get left behind
+in your temp directory. This finds and removes any which don't belong to a
+grouse process that's still running. If you run it inside a git repo, it also
+prunes any worktrees that grouse left registered there.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		pkg.RunCleanCommand(cmd)
+	},
+}
+
+var dirsCmd = &cobra.Command{
+	Use:   "dirs [flags] <dir-or-archive> <other-dir-or-archive>",
+	Short: "Diffs two sites which have already been built.",
+	Long: `Diffs two sites which have already been built.
+
+Each side can be a directory, or a .tar, .tar.gz, .tgz or .zip archive, e.g. a
+build artifact from CI. If everything in an archive is inside a single
+directory, grouse compares the contents of that directory. This doesn't need
+Hugo, or to be run inside a git repo.`,
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		pkg.RunDirsCommand(cmd)
+	},
+}
+
+var checkDeterminismCmd = &cobra.Command{
+	Use:   "check-determinism [flags] [<commit>]",
+	Short: "Builds one commit several times and reports output that differs.",
+	Long: `Builds one commit (HEAD by default) several times, each in a fresh checkout,
+and reports every output file which differs between the builds. These are the
+files that show up as phantom changes in every diff, e.g. because a template
+uses 'now', or shuffles related posts.
+
+With --write-rules, grouse writes rules which mask the lines that vary (or
+ignore whole files, if it can't narrow it down), for use with --ignore-rules
+in later comparisons. Exits with status 2 if the builds differ.`,
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pkg.RunCheckDeterminismCommand(cmd)
+	},
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [flags] [<baseline-commit>]",
+	Short: "Rebuilds the working copy whenever it changes, and compares it to a commit.",
+	Long: `Builds one commit (HEAD by default) once, as the baseline. Then it builds the
+Hugo site in your working copy, changes that aren't committed and all, and
+compares it to the baseline; and again every time that files in the site
+change, until you press Ctrl-C.
+
+Each time, grouse shows what changed, but not the diff. With --format and
+--report-to, it rewrites the report instead. Hugo runs in your working copy,
+so it writes its caches there, just like when you run it yourself.`,
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pkg.RunWatchCommand(cmd)
+	},
+}
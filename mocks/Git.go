@@ -76,3 +76,17 @@ func (_m *Git) OpenRepository(repoDir string) (git.Repository, error) {
 
 	return r0, r1
 }
+
+// SupportsDetachedWorktrees provides a mock function with given fields:
+func (_m *Git) SupportsDetachedWorktrees() bool {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
@@ -10,6 +10,98 @@ type WorktreeRepository struct {
 	mock.Mock
 }
 
+// AddDetachedWorktree provides a mock function with given fields: dst, sparsePaths
+func (_m *WorktreeRepository) AddDetachedWorktree(dst string, sparsePaths []string) (git.WorktreeRepository, error) {
+	ret := _m.Called(dst, sparsePaths)
+
+	var r0 git.WorktreeRepository
+	if rf, ok := ret.Get(0).(func(string, []string) git.WorktreeRepository); ok {
+		r0 = rf(dst, sparsePaths)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(git.WorktreeRepository)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, []string) error); ok {
+		r1 = rf(dst, sparsePaths)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ChangedFiles provides a mock function with given fields: from, to
+func (_m *WorktreeRepository) ChangedFiles(from git.Hash, to git.Hash) ([]git.FileChange, error) {
+	ret := _m.Called(from, to)
+
+	var r0 []git.FileChange
+	if rf, ok := ret.Get(0).(func(git.Hash, git.Hash) []git.FileChange); ok {
+		r0 = rf(from, to)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]git.FileChange)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(git.Hash, git.Hash) error); ok {
+		r1 = rf(from, to)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ChangedFilesInWorkingTree provides a mock function with given fields: from
+func (_m *WorktreeRepository) ChangedFilesInWorkingTree(from git.Hash) ([]git.FileChange, error) {
+	ret := _m.Called(from)
+
+	var r0 []git.FileChange
+	if rf, ok := ret.Get(0).(func(git.Hash) []git.FileChange); ok {
+		r0 = rf(from)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]git.FileChange)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(git.Hash) error); ok {
+		r1 = rf(from)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ChangedLines provides a mock function with given fields: from, to, filePath
+func (_m *WorktreeRepository) ChangedLines(from git.Hash, to git.Hash, filePath string) ([]git.LineChange, error) {
+	ret := _m.Called(from, to, filePath)
+
+	var r0 []git.LineChange
+	if rf, ok := ret.Get(0).(func(git.Hash, git.Hash, string) []git.LineChange); ok {
+		r0 = rf(from, to, filePath)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]git.LineChange)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(git.Hash, git.Hash, string) error); ok {
+		r1 = rf(from, to, filePath)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Checkout provides a mock function with given fields: commit
 func (_m *WorktreeRepository) Checkout(commit git.ResolvedCommit) error {
 	ret := _m.Called(commit)
@@ -24,13 +116,13 @@ func (_m *WorktreeRepository) Checkout(commit git.ResolvedCommit) error {
 	return r0
 }
 
-// RecursiveSharedCloneTo provides a mock function with given fields: dst
-func (_m *WorktreeRepository) RecursiveSharedCloneTo(dst string) (git.WorktreeRepository, error) {
-	ret := _m.Called(dst)
+// ExportTo provides a mock function with given fields: dst, sparsePaths
+func (_m *WorktreeRepository) ExportTo(dst string, sparsePaths []string) (git.WorktreeRepository, error) {
+	ret := _m.Called(dst, sparsePaths)
 
 	var r0 git.WorktreeRepository
-	if rf, ok := ret.Get(0).(func(string) git.WorktreeRepository); ok {
-		r0 = rf(dst)
+	if rf, ok := ret.Get(0).(func(string, []string) git.WorktreeRepository); ok {
+		r0 = rf(dst, sparsePaths)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(git.WorktreeRepository)
@@ -38,8 +130,135 @@ func (_m *WorktreeRepository) RecursiveSharedCloneTo(dst string) (git.WorktreeRe
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(dst)
+	if rf, ok := ret.Get(1).(func(string, []string) error); ok {
+		r1 = rf(dst, sparsePaths)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FileDiff provides a mock function with given fields: from, to, filePath
+func (_m *WorktreeRepository) FileDiff(from git.Hash, to git.Hash, filePath string) (string, error) {
+	ret := _m.Called(from, to, filePath)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(git.Hash, git.Hash, string) string); ok {
+		r0 = rf(from, to, filePath)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(git.Hash, git.Hash, string) error); ok {
+		r1 = rf(from, to, filePath)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FileSizes provides a mock function with given fields: commit, filePaths
+func (_m *WorktreeRepository) FileSizes(commit git.Hash, filePaths []string) (map[string]int, error) {
+	ret := _m.Called(commit, filePaths)
+
+	var r0 map[string]int
+	if rf, ok := ret.Get(0).(func(git.Hash, []string) map[string]int); ok {
+		r0 = rf(commit, filePaths)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]int)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(git.Hash, []string) error); ok {
+		r1 = rf(commit, filePaths)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListFiles provides a mock function with given fields: commit, dir
+func (_m *WorktreeRepository) ListFiles(commit git.Hash, dir string) ([]string, error) {
+	ret := _m.Called(commit, dir)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(git.Hash, string) []string); ok {
+		r0 = rf(commit, dir)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(git.Hash, string) error); ok {
+		r1 = rf(commit, dir)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReadFile provides a mock function with given fields: commit, filePath
+func (_m *WorktreeRepository) ReadFile(commit git.Hash, filePath string) ([]byte, error) {
+	ret := _m.Called(commit, filePath)
+
+	var r0 []byte
+	if rf, ok := ret.Get(0).(func(git.Hash, string) []byte); ok {
+		r0 = rf(commit, filePath)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(git.Hash, string) error); ok {
+		r1 = rf(commit, filePath)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReadFiles provides a mock function with given fields: commit, filePaths, read
+func (_m *WorktreeRepository) ReadFiles(commit git.Hash, filePaths []string, read func(string, []byte) error) error {
+	ret := _m.Called(commit, filePaths, read)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(git.Hash, []string, func(string, []byte) error) error); ok {
+		r0 = rf(commit, filePaths, read)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RecursiveSharedCloneTo provides a mock function with given fields: dst, sparsePaths
+func (_m *WorktreeRepository) RecursiveSharedCloneTo(dst string, sparsePaths []string) (git.WorktreeRepository, error) {
+	ret := _m.Called(dst, sparsePaths)
+
+	var r0 git.WorktreeRepository
+	if rf, ok := ret.Get(0).(func(string, []string) git.WorktreeRepository); ok {
+		r0 = rf(dst, sparsePaths)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(git.WorktreeRepository)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, []string) error); ok {
+		r1 = rf(dst, sparsePaths)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -97,3 +316,40 @@ func (_m *WorktreeRepository) RootDir() string {
 
 	return r0
 }
+
+// Submodules provides a mock function with given fields: commit
+func (_m *WorktreeRepository) Submodules(commit git.Hash) (map[string]git.Hash, error) {
+	ret := _m.Called(commit)
+
+	var r0 map[string]git.Hash
+	if rf, ok := ret.Get(0).(func(git.Hash) map[string]git.Hash); ok {
+		r0 = rf(commit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]git.Hash)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(git.Hash) error); ok {
+		r1 = rf(commit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UsesSubmodules provides a mock function with given fields: commit
+func (_m *WorktreeRepository) UsesSubmodules(commit git.Hash) bool {
+	ret := _m.Called(commit)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(git.Hash) bool); ok {
+		r0 = rf(commit)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}